@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+)
+
+//Block is a block mined with a proof of work. It contains all the transactions collected by the miner.
+//PrevHash     [32]byte is the hash of the previous block (all zeros for the first block)
+//Nonce        [32]byte is chosen by the miner so that the hash of the block has enough leading zero bits
+//Transactions []TxPublish are the published files
+type Block struct {
+	PrevHash     [32]byte
+	Nonce        [32]byte
+	Transactions []TxPublish
+}
+
+//Hash computes the hash of the block from the hash of its parent, its nonce, the number of transactions
+//and the hash of each transaction.
+func (b *Block) Hash() (out [32]byte) {
+	h := sha256.New()
+	h.Write(b.PrevHash[:])
+	h.Write(b.Nonce[:])
+	binary.Write(h, binary.LittleEndian, uint32(len(b.Transactions)))
+	for _, t := range b.Transactions {
+		th := t.Hash()
+		h.Write(th[:])
+	}
+	copy(out[:], h.Sum(nil))
+	return
+}
+
+func (b *Block) Parent() [32]byte {
+	return b.PrevHash
+}
+
+func (b *Block) GetTransactions() []TxPublish {
+	return b.Transactions
+}
+
+//CheckPoW verifies that the hash of the block has at least difficulty leading zero bits
+func (b *Block) CheckPoW(difficulty int) bool {
+	return LeadingZeroBits(b.Hash()) >= difficulty
+}
+
+//LeadingZeroBits counts the number of leading zero bits of hash
+func LeadingZeroBits(hash [32]byte) int {
+	n := 0
+	for _, b := range hash {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+//Mine tries attempts random nonces for the block. It returns true and leaves the winning nonce in the block
+//if one of them gives a hash with at least difficulty leading zero bits.
+func (b *Block) Mine(difficulty, attempts int) bool {
+	if _, err := rand.Read(b.Nonce[:]); err != nil {
+		return false
+	}
+
+	for i := 0; i < attempts; i++ {
+		if b.CheckPoW(difficulty) {
+			return true
+		}
+		incrementNonce(&b.Nonce)
+	}
+	return false
+}
+
+//incrementNonce adds one to the nonce seen as a big-endian integer
+func incrementNonce(nonce *[32]byte) {
+	for i := len(nonce) - 1; i >= 0; i-- {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package blockchain
+
+import "testing"
+
+func TestLeadingZeroBits(t *testing.T) {
+	cases := map[[32]byte]int{
+		{0x80}:          0,
+		{0x01}:          7,
+		{0, 0x10}:       11,
+		{0, 0, 0, 0xff}: 24,
+		{}:              256,
+	}
+	for hash, expected := range cases {
+		if n := LeadingZeroBits(hash); n != expected {
+			t.Errorf("%d leading zero bits in %s, expected %d", n, HashString(hash), expected)
+		}
+	}
+}
+
+func TestMine(t *testing.T) {
+	const difficulty = 12
+
+	block := &Block{Transactions: []TxPublish{{Name: "file", Size: 1}}}
+	if !block.Mine(difficulty, 1<<24) {
+		t.Fatal("no nonce found")
+	}
+	if !block.CheckPoW(difficulty) || LeadingZeroBits(block.Hash()) < difficulty {
+		t.Fatalf("mined block %s without the proof of work", HashString(block.Hash()))
+	}
+
+	//the proof of work does not survive a change of the block
+	for LeadingZeroBits(block.Hash()) >= difficulty {
+		incrementNonce(&block.Nonce)
+	}
+	if block.CheckPoW(difficulty) {
+		t.Fatal("proof of work accepted for a modified block")
+	}
+	if block.Mine(256, 1) {
+		t.Fatal("impossible proof of work found")
+	}
+}
+
+func TestIncrementNonce(t *testing.T) {
+	nonce := [32]byte{}
+	nonce[31], nonce[30] = 0xff, 0xff
+	incrementNonce(&nonce)
+	if nonce[31] != 0 || nonce[30] != 0 || nonce[29] != 1 {
+		t.Fatalf("nonce %x after the increment", nonce)
+	}
+}
@@ -1,18 +1,169 @@
 package blockchain
 
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/dedis/protobuf"
+)
+
+//TxType is the tag of a transaction on a file
+type TxType uint32
+
+const (
+	//TX_PUBLISH announces a new file
+	TX_PUBLISH TxType = iota
+	//TX_UNPUBLISH withdraws a published file
+	TX_UNPUBLISH
+	//TX_RENAME publishes a file under a new name (NewName)
+	TX_RENAME
+	//TX_TRANSFER gives the ownership of a file to another gossiper (NewOwner)
+	TX_TRANSFER
+)
+
 //TxPublish represents a transaction to be published. When a new file is
 //indexed (and potentially shared) the others peers need to be notified
-//with a corresponding transaction.
-//Name         string
+//with a corresponding transaction. The published file can then be unpublished, renamed or transferred
+//by its owner with transactions of the other types.
+//Name         string is the name of the file, the current one for an unpublish, a rename or a transfer
 //Size         int64 is the size in bytes
 //MetafileHash []byte
+//Membership   *MembershipChange is set (and the other fields are empty) if the transaction changes the members of TLC
+//Type         TxType is the kind of transaction on the file
+//NewName      string is the new name of the file (TX_RENAME)
+//NewOwner     string is the gossiper receiving the file (TX_TRANSFER)
+//NewOwnerKey  []byte is the public key of the new owner (TX_TRANSFER)
+//Previous     [32]byte is the hash of the last transaction on the file. It prevents a transaction from being replayed.
+//Owner        string is the owner of the file: the publisher for TX_PUBLISH, the current owner otherwise
+//PublicKey    []byte is the public key of the owner
+//Signature    []byte is the signature of the transaction by the owner
 type TxPublish struct {
 	Name         string
 	Size         int64
 	MetafileHash []byte
+	Membership   *MembershipChange
+	Type         TxType
+	NewName      string
+	NewOwner     string
+	NewOwnerKey  []byte
+	Previous     [32]byte
+	Owner        string
+	PublicKey    []byte
+	Signature    []byte
 }
 
 type BlockPublish struct{
 	PrevHash [32]byte
 	Transaction TxPublish
-}
\ No newline at end of file
+}
+
+//Hash computes the hash of the transaction from the length of its name, its name and its metafile hash.
+//The hash of a membership change also covers the member, the kind of change, its sequence number and its signature.
+//The hash of a signed transaction also covers its type, the fields of its type and its signature.
+func (t *TxPublish) Hash() (out [32]byte) {
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, uint32(len(t.Name)))
+	h.Write([]byte(t.Name))
+	h.Write(t.MetafileHash)
+	if mc := t.Membership; mc != nil {
+		binary.Write(h, binary.LittleEndian, uint32(len(mc.Member)))
+		h.Write([]byte(mc.Member))
+		binary.Write(h, binary.LittleEndian, mc.Join)
+		binary.Write(h, binary.LittleEndian, mc.Sequence)
+		h.Write(mc.Signature)
+	}
+	if t.Type != TX_PUBLISH || len(t.Signature) > 0 {
+		binary.Write(h, binary.LittleEndian, uint32(t.Type))
+		binary.Write(h, binary.LittleEndian, uint32(len(t.NewName)))
+		h.Write([]byte(t.NewName))
+		binary.Write(h, binary.LittleEndian, uint32(len(t.NewOwner)))
+		h.Write([]byte(t.NewOwner))
+		h.Write(t.Previous[:])
+		h.Write(t.Signature)
+	}
+	copy(out[:], h.Sum(nil))
+	return
+}
+
+//IsMembershipChange checks if the transaction changes the members of TLC instead of publishing a file
+func (t *TxPublish) IsMembershipChange() bool {
+	return t.Membership != nil
+}
+
+//IsFileOperation checks if the transaction unpublishes, renames or transfers a published file
+func (t *TxPublish) IsFileOperation() bool {
+	return !t.IsMembershipChange() && t.Type != TX_PUBLISH
+}
+
+//Label returns the name of the file, or a description of the operation such as "rename(<name>-><new name>)",
+//"unpublish(<name>)", "transfer(<name>-><new owner>)" or "join(<member>)". It is used when the chain is printed.
+func (t *TxPublish) Label() string {
+	if t.IsMembershipChange() {
+		return t.Membership.Label()
+	}
+
+	switch t.Type {
+	case TX_UNPUBLISH:
+		return "unpublish(" + t.Name + ")"
+	case TX_RENAME:
+		return "rename(" + t.Name + "->" + t.NewName + ")"
+	case TX_TRANSFER:
+		return "transfer(" + t.Name + "->" + t.NewOwner + ")"
+	default:
+		return t.Name
+	}
+}
+
+//Sign signs the transaction with the private key of its owner and attaches the public key.
+func (t *TxPublish) Sign(sk ed25519.PrivateKey) error {
+	t.PublicKey = sk.Public().(ed25519.PublicKey)
+	t.Signature = nil
+
+	toSign, err := protobuf.Encode(t)
+	if err != nil {
+		return err
+	}
+
+	t.Signature = ed25519.Sign(sk, toSign)
+	return nil
+}
+
+//Verify checks that the signature of the transaction matches its attached public key.
+func (t *TxPublish) Verify() bool {
+	if len(t.PublicKey) != ed25519.PublicKeySize || len(t.Signature) != ed25519.SignatureSize {
+		return false
+	}
+
+	unsigned := *t
+	unsigned.Signature = nil
+	signed, err := protobuf.Encode(&unsigned)
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(t.PublicKey, signed, t.Signature)
+}
+
+//Hash computes the hash of the block from the hash of its parent and the hash of its transaction.
+func (b *BlockPublish) Hash() (out [32]byte) {
+	h := sha256.New()
+	h.Write(b.PrevHash[:])
+	th := b.Transaction.Hash()
+	h.Write(th[:])
+	copy(out[:], h.Sum(nil))
+	return
+}
+
+func (b *BlockPublish) Parent() [32]byte {
+	return b.PrevHash
+}
+
+func (b *BlockPublish) GetTransactions() []TxPublish {
+	return []TxPublish{b.Transaction}
+}
+
+//HashString returns the hexadecimal representation of a block hash
+func HashString(hash [32]byte) string {
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,412 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+//MAX_ORPHANS is the maximal number of blocks kept while they wait for their parent. The oldest one is dropped beyond it.
+const MAX_ORPHANS = 256
+
+//Linked is the interface of the blocks that can be added to a Chain.
+//Hash is the hash of the block, Parent the hash of the previous block (all zeros for the first block)
+//and GetTransactions the transactions contained in the block.
+type Linked interface {
+	Hash() [32]byte
+	Parent() [32]byte
+	GetTransactions() []TxPublish
+}
+
+//node is a block of the chain together with its height (1 for a block whose parent is the genesis).
+type node struct {
+	block  Linked
+	height int
+}
+
+//orphanRef references a block waiting for its parent
+type orphanRef struct {
+	parent [32]byte
+	hash   [32]byte
+}
+
+//Chain keeps the tree of all the valid blocks received so far and the head of the longest chain.
+//Blocks whose parent is unknown are kept aside until their parent arrives, at most MAX_ORPHANS of them:
+//waiting references them in their order of arrival so that the oldest one is dropped first.
+//Forks are resolved with the longest-chain rule. On a tie, the chain whose head has the smallest hash wins,
+//so that all the nodes agree on the same chain once they received the same blocks.
+//The ledger (file name -> state of the file) is rebuilt from the longest chain each time the head changes.
+//All operations on the Chain are thread-safe.
+type Chain struct {
+	sync.RWMutex
+	blocks      map[[32]byte]*node
+	orphans     map[[32]byte][]Linked
+	waiting     []orphanRef
+	orphanCount int
+	head        [32]byte
+	height      int
+	ledger      map[string]FileRecord
+}
+
+func ChainFactory() *Chain {
+	return &Chain{
+		RWMutex:     sync.RWMutex{},
+		blocks:      make(map[[32]byte]*node),
+		orphans:     make(map[[32]byte][]Linked),
+		waiting:     make([]orphanRef, 0),
+		orphanCount: 0,
+		head:        [32]byte{},
+		height:      0,
+		ledger:      make(map[string]FileRecord),
+	}
+}
+
+//Head returns the hash of the last block of the longest chain (all zeros if the chain is empty) and its height.
+func (c *Chain) Head() ([32]byte, int) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.head, c.height
+}
+
+//Contains checks if the block with the given hash is in the chain
+func (c *Chain) Contains(hash [32]byte) bool {
+	c.RLock()
+	defer c.RUnlock()
+	_, ok := c.blocks[hash]
+	return ok
+}
+
+//Ledger returns a copy of the ledger of the longest chain: the files indexed by name, including the unpublished ones.
+func (c *Chain) Ledger() map[string]FileRecord {
+	c.RLock()
+	defer c.RUnlock()
+
+	ledger := make(map[string]FileRecord, len(c.ledger))
+	for name, record := range c.ledger {
+		ledger[name] = record
+	}
+	return ledger
+}
+
+//Lookup returns the record of the name in the ledger of the longest chain. ok is false if the name was never used.
+func (c *Chain) Lookup(name string) (record FileRecord, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	record, ok = c.ledger[name]
+	return
+}
+
+//Resolve returns the record of the file called name in the ledger of the longest chain, following its renames.
+//ok is false if the name was never used.
+func (c *Chain) Resolve(name string) (FileRecord, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	return resolve(c.ledger, name)
+}
+
+//CheckTransaction verifies that the transaction can be applied to the ledger of the longest chain.
+//Membership changes are checked by the Membership.
+func (c *Chain) CheckTransaction(tx TxPublish) error {
+	c.RLock()
+	defer c.RUnlock()
+	return checkTransaction(c.ledger, tx)
+}
+
+//FilterTransactions returns the transactions that can be applied one after the other to the ledger of the longest chain.
+//The others are dropped.
+func (c *Chain) FilterTransactions(txs []TxPublish) []TxPublish {
+	c.RLock()
+	ledger := make(map[string]FileRecord, len(c.ledger))
+	for name, record := range c.ledger {
+		ledger[name] = record
+	}
+	c.RUnlock()
+
+	valid := make([]TxPublish, 0, len(txs))
+	for _, tx := range txs {
+		if checkTransaction(ledger, tx) == nil {
+			applyTransaction(ledger, tx)
+			valid = append(valid, tx)
+		}
+	}
+	return valid
+}
+
+//Add validates the block and adds it to the chain.
+//It returns true if the head of the longest chain changed.
+//A block whose parent is unknown is kept until its parent is added, and an UnknownParentError is returned.
+func (c *Chain) Add(block Linked) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	previousHead := c.head
+	if err := c.add(block); err != nil {
+		return false, err
+	}
+
+	if c.head != previousHead {
+		c.switchHead(previousHead)
+		return true, nil
+	}
+	fmt.Printf("FORK-SHORTER %s\n", HashString(block.Hash()))
+	return false, nil
+}
+
+//Load adds the block to the chain like Add, without printing anything. It is used to rebuild the chain from a log.
+func (c *Chain) Load(block Linked) error {
+	c.Lock()
+	defer c.Unlock()
+
+	previousHead := c.head
+	if err := c.add(block); err != nil {
+		return err
+	}
+	if c.head != previousHead {
+		c.ledger = c.ledgerAt(c.head)
+	}
+	return nil
+}
+
+//add inserts the block and the orphans that were waiting for it. It moves the head if a longer chain appears.
+//It must be called with the lock held.
+func (c *Chain) add(block Linked) error {
+	hash := block.Hash()
+	if _, ok := c.blocks[hash]; ok {
+		return &DuplicateBlockError{Hash: hash}
+	}
+
+	parent := block.Parent()
+	height := 1
+	if parent != [32]byte{} {
+		parentNode, ok := c.blocks[parent]
+		if !ok {
+			c.addOrphan(block)
+			return &UnknownParentError{Parent: parent}
+		}
+		height = parentNode.height + 1
+	}
+
+	if err := c.validate(block); err != nil {
+		return err
+	}
+
+	c.blocks[hash] = &node{block: block, height: height}
+	if height > c.height || (height == c.height && bytes.Compare(hash[:], c.head[:]) < 0) {
+		c.head = hash
+		c.height = height
+	}
+
+	orphans := c.orphans[hash]
+	delete(c.orphans, hash)
+	c.orphanCount -= len(orphans)
+	for _, orphan := range orphans {
+		c.add(orphan)
+	}
+	return nil
+}
+
+//addOrphan keeps the block until its parent arrives. The oldest orphan is dropped beyond MAX_ORPHANS.
+//It must be called with the lock held.
+func (c *Chain) addOrphan(block Linked) {
+	parent := block.Parent()
+	for _, orphan := range c.orphans[parent] {
+		if orphan.Hash() == block.Hash() {
+			return
+		}
+	}
+
+	for c.orphanCount >= MAX_ORPHANS && len(c.waiting) > 0 {
+		c.dropOrphan(c.waiting[0])
+		c.waiting = c.waiting[1:]
+	}
+
+	c.orphans[parent] = append(c.orphans[parent], block)
+	c.orphanCount += 1
+	c.waiting = append(c.waiting, orphanRef{parent: parent, hash: block.Hash()})
+
+	//the orphans added to the chain since leave stale references behind
+	if len(c.waiting) > 2*MAX_ORPHANS {
+		waiting := make([]orphanRef, 0, c.orphanCount)
+		for _, ref := range c.waiting {
+			if c.isOrphan(ref) {
+				waiting = append(waiting, ref)
+			}
+		}
+		c.waiting = waiting
+	}
+}
+
+//isOrphan checks if the block referenced by ref still waits for its parent. It must be called with the lock held.
+func (c *Chain) isOrphan(ref orphanRef) bool {
+	for _, orphan := range c.orphans[ref.parent] {
+		if orphan.Hash() == ref.hash {
+			return true
+		}
+	}
+	return false
+}
+
+//dropOrphan forgets the block referenced by ref if it still waits for its parent. It must be called with the lock held.
+func (c *Chain) dropOrphan(ref orphanRef) {
+	orphans := c.orphans[ref.parent]
+	for i, orphan := range orphans {
+		if orphan.Hash() == ref.hash {
+			c.orphans[ref.parent] = append(orphans[:i:i], orphans[i+1:]...)
+			if len(c.orphans[ref.parent]) == 0 {
+				delete(c.orphans, ref.parent)
+			}
+			c.orphanCount -= 1
+			return
+		}
+	}
+}
+
+//validate checks that the transactions of the block can be applied one after the other to the ledger of the chain
+//ending at its parent. Membership changes are checked by the Membership.
+//It must be called with the lock held.
+func (c *Chain) validate(block Linked) error {
+	ledger := c.ledgerAt(block.Parent())
+	for _, tx := range block.GetTransactions() {
+		if err := checkTransaction(ledger, tx); err != nil {
+			return err
+		}
+		applyTransaction(ledger, tx)
+	}
+	return nil
+}
+
+//ledgerAt builds the ledger of the chain ending at the block with the given hash.
+//It must be called with the lock held.
+func (c *Chain) ledgerAt(head [32]byte) map[string]FileRecord {
+	ledger := make(map[string]FileRecord)
+	hashes := c.path(head)
+	for i := len(hashes) - 1; i >= 0; i-- {
+		for _, tx := range c.blocks[hashes[i]].block.GetTransactions() {
+			applyTransaction(ledger, tx)
+		}
+	}
+	return ledger
+}
+
+//switchHead rebuilds the ledger from the new head and prints the chain.
+//If the new head does not extend the previous one, it prints by how many blocks the chain was rewound.
+//It must be called with the lock held.
+func (c *Chain) switchHead(previousHead [32]byte) {
+	ancestors := make(map[[32]byte]bool)
+	for _, hash := range c.path(c.head) {
+		ancestors[hash] = true
+	}
+	c.ledger = c.ledgerAt(c.head)
+
+	rewind := 0
+	for hash := previousHead; hash != [32]byte{} && !ancestors[hash]; hash = c.blocks[hash].block.Parent() {
+		rewind++
+	}
+	if rewind > 0 {
+		fmt.Printf("FORK-LONGER rewind %d blocks\n", rewind)
+	}
+
+	fmt.Println(c.format())
+}
+
+//path returns the hashes of the blocks from head to the first block
+func (c *Chain) path(head [32]byte) [][32]byte {
+	hashes := make([][32]byte, 0)
+	for hash := head; hash != [32]byte{}; hash = c.blocks[hash].block.Parent() {
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+//Block returns the block with the given hash and its height, on the longest chain or on a fork.
+//ok is false if the block is not in the chain.
+func (c *Chain) Block(hash [32]byte) (block Linked, height int, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	n, ok := c.blocks[hash]
+	if !ok {
+		return nil, 0, false
+	}
+	return n.block, n.height, true
+}
+
+//Blocks returns the blocks of the longest chain, from the first one to the head
+func (c *Chain) Blocks() []Linked {
+	c.RLock()
+	defer c.RUnlock()
+	return c.branch(c.head)
+}
+
+//Branch returns the blocks of the chain ending at the block with the given hash, from the first one to this block.
+//It is empty if the block is not in the chain.
+func (c *Chain) Branch(head [32]byte) []Linked {
+	c.RLock()
+	defer c.RUnlock()
+
+	if _, ok := c.blocks[head]; !ok {
+		return []Linked{}
+	}
+	return c.branch(head)
+}
+
+//branch is the implementation of Branch. It must be called with the lock held.
+func (c *Chain) branch(head [32]byte) []Linked {
+	hashes := c.path(head)
+	blocks := make([]Linked, len(hashes))
+	for i, hash := range hashes {
+		blocks[len(hashes)-1-i] = c.blocks[hash].block
+	}
+	return blocks
+}
+
+//String returns the longest chain in the format "CHAIN [block-latest] [block-latest-1] ..."
+//where a block is <hash>:<prev-hash>:<comma separated names of the files>.
+func (c *Chain) String() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.format()
+}
+
+//format is the implementation of String. It must be called with the lock held.
+func (c *Chain) format() string {
+	s := "CHAIN"
+	for _, hash := range c.path(c.head) {
+		block := c.blocks[hash].block
+		names := make([]string, 0)
+		for _, tx := range block.GetTransactions() {
+			names = append(names, tx.Label())
+		}
+		s += fmt.Sprintf(" %s:%s:%s", HashString(hash), HashString(block.Parent()), strings.Join(names, ","))
+	}
+	return s
+}
+
+//DuplicateBlockError is returned when a block is added twice.
+type DuplicateBlockError struct {
+	Hash [32]byte
+}
+
+func (e *DuplicateBlockError) Error() string {
+	return "block " + HashString(e.Hash) + " is already in the chain"
+}
+
+//UnknownParentError is returned when the parent of a block is not in the chain.
+type UnknownParentError struct {
+	Parent [32]byte
+}
+
+func (e *UnknownParentError) Error() string {
+	return "parent " + HashString(e.Parent) + " is not in the chain"
+}
+
+//DuplicateNameError is returned when a transaction publishes or renames a file to a name that is already used.
+type DuplicateNameError struct {
+	Name string
+}
+
+func (e *DuplicateNameError) Error() string {
+	return "file name " + e.Name + " is already published"
+}
@@ -0,0 +1,150 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+//child returns a block without transactions on top of parent, told apart from its siblings by nonce
+func child(parent Linked, nonce byte) *Block {
+	block := &Block{Nonce: [32]byte{nonce}}
+	if parent != nil {
+		block.PrevHash = parent.Hash()
+	}
+	return block
+}
+
+//smallest returns the block with the smallest hash
+func smallest(a, b Linked) Linked {
+	hashA, hashB := a.Hash(), b.Hash()
+	if bytes.Compare(hashB[:], hashA[:]) < 0 {
+		return b
+	}
+	return a
+}
+
+//addBlocks adds the blocks in order and fails the test on error
+func addBlocks(t *testing.T, c *Chain, blocks ...Linked) {
+	for _, block := range blocks {
+		if _, err := c.Add(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkHead(t *testing.T, c *Chain, expected Linked, height int) {
+	head, h := c.Head()
+	if head != expected.Hash() || h != height {
+		t.Fatalf("head %s at height %d, expected %s at height %d", HashString(head), h, HashString(expected.Hash()), height)
+	}
+}
+
+func TestChainLongestWins(t *testing.T) {
+	c := ChainFactory()
+	b1 := child(nil, 1)
+	b2 := child(b1, 2)
+	addBlocks(t, c, b1, b2)
+	checkHead(t, c, b2, 2)
+
+	//a fork of the same height only wins with a smaller hash, a longer fork always wins
+	f2 := child(b1, 3)
+	f3 := child(f2, 4)
+	addBlocks(t, c, f2)
+	checkHead(t, c, smallest(b2, f2), 2)
+	addBlocks(t, c, f3)
+	checkHead(t, c, f3, 3)
+
+	b3 := child(b2, 5)
+	b4 := child(b3, 6)
+	addBlocks(t, c, b3, b4)
+	checkHead(t, c, b4, 4)
+
+	branch := c.Blocks()
+	for i, block := range []Linked{b1, b2, b3, b4} {
+		if len(branch) != 4 || branch[i].Hash() != block.Hash() {
+			t.Fatalf("longest chain of %d blocks, expected b1, b2, b3, b4", len(branch))
+		}
+	}
+}
+
+func TestChainTieSmallestHash(t *testing.T) {
+	b1 := child(nil, 1)
+	left, right := child(b1, 2), child(b1, 3)
+
+	//all the nodes agree on the same head whatever the order in which they received the blocks
+	for _, order := range [][]Linked{{b1, left, right}, {b1, right, left}} {
+		c := ChainFactory()
+		addBlocks(t, c, order...)
+		checkHead(t, c, smallest(left, right), 2)
+	}
+}
+
+func TestChainDuplicate(t *testing.T) {
+	c := ChainFactory()
+	b1 := child(nil, 1)
+	addBlocks(t, c, b1)
+	if _, err := c.Add(child(nil, 1)); err == nil {
+		t.Fatal("duplicate block accepted")
+	} else if _, ok := err.(*DuplicateBlockError); !ok {
+		t.Fatalf("unexpected error %s", err)
+	}
+}
+
+func TestChainOrphan(t *testing.T) {
+	c := ChainFactory()
+	b1 := child(nil, 1)
+	b2 := child(b1, 2)
+	b3 := child(b2, 3)
+
+	for _, orphan := range []Linked{b3, b2} {
+		if _, err := c.Add(orphan); err == nil {
+			t.Fatal("block without parent accepted")
+		} else if _, ok := err.(*UnknownParentError); !ok {
+			t.Fatalf("unexpected error %s", err)
+		}
+	}
+
+	addBlocks(t, c, b1)
+	checkHead(t, c, b3, 3)
+}
+
+func TestChainOrphanCap(t *testing.T) {
+	c := ChainFactory()
+	parents := make([]*Block, 0, MAX_ORPHANS+1)
+	orphans := make([]*Block, 0, MAX_ORPHANS+1)
+	for i := 0; i <= MAX_ORPHANS; i++ {
+		parent := &Block{Nonce: [32]byte{1, byte(i), byte(i >> 8)}}
+		orphan := child(parent, 2)
+		if _, err := c.Add(orphan); err == nil {
+			t.Fatal("block without parent accepted")
+		}
+		parents = append(parents, parent)
+		orphans = append(orphans, orphan)
+	}
+	if c.orphanCount != MAX_ORPHANS {
+		t.Fatalf("%d orphans kept, expected %d", c.orphanCount, MAX_ORPHANS)
+	}
+
+	//the oldest orphan was dropped, the newest one is adopted by its parent
+	addBlocks(t, c, parents[0])
+	if c.Contains(orphans[0].Hash()) {
+		t.Fatal("the oldest orphan was kept")
+	}
+	addBlocks(t, c, parents[MAX_ORPHANS])
+	if !c.Contains(orphans[MAX_ORPHANS].Hash()) {
+		t.Fatal("the newest orphan was dropped")
+	}
+	if c.orphanCount != MAX_ORPHANS-1 {
+		t.Fatalf("%d orphans counted, expected %d", c.orphanCount, MAX_ORPHANS-1)
+	}
+
+	//the references of the adopted orphans do not grow without bound
+	for i := 0; i < 4*MAX_ORPHANS; i++ {
+		parent := &Block{Nonce: [32]byte{3, byte(i), byte(i >> 8)}}
+		c.Add(child(parent, 4))
+		addBlocks(t, c, parent)
+	}
+	if len(c.waiting) > 2*MAX_ORPHANS+1 {
+		t.Fatalf("%d references to orphans", len(c.waiting))
+	}
+}
@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+)
+
+//FileRecord is the state of a file name in the ledger of the longest chain.
+//Name         string
+//Size         int64 is the size in bytes
+//MetafileHash []byte
+//Owner        string is the gossiper allowed to unpublish, rename or transfer the file
+//OwnerKey     []byte is the public key of the owner
+//Last         [32]byte is the hash of the last transaction on the name
+//Unpublished  bool is true if the file was unpublished or renamed: the name is free again
+//RenamedTo    string is the new name of the file if it was renamed
+type FileRecord struct {
+	Name         string
+	Size         int64
+	MetafileHash []byte
+	Owner        string
+	OwnerKey     []byte
+	Last         [32]byte
+	Unpublished  bool
+	RenamedTo    string
+}
+
+//checkTransaction verifies that the transaction can be applied to the ledger.
+//Every transaction refers to the last transaction on its name (none for a name never used), so that it cannot be replayed.
+//A file is published under a free name and signed by its publisher. The other transactions must be signed by the current
+//owner of a published file. A file is renamed to a free name and transferred to a named
+//gossiper whose key is given. Membership changes are checked by the Membership.
+func checkTransaction(ledger map[string]FileRecord, tx TxPublish) error {
+	if tx.IsMembershipChange() {
+		return nil
+	}
+
+	if !tx.Verify() || tx.Owner == "" {
+		return &InvalidTransactionError{Tx: tx, Reason: "invalid signature"}
+	}
+
+	record, published := ledger[tx.Name]
+	published = published && !record.Unpublished
+	if tx.Type == TX_PUBLISH && published {
+		return &DuplicateNameError{Name: tx.Name}
+	}
+	if tx.Previous != record.Last {
+		return &InvalidTransactionError{Tx: tx, Reason: "it does not follow the last transaction on the name"}
+	}
+	if tx.Type == TX_PUBLISH {
+		return nil
+	}
+
+	if !published {
+		return &InvalidTransactionError{Tx: tx, Reason: "the file is not published"}
+	}
+	if tx.Owner != record.Owner || !bytes.Equal(tx.PublicKey, record.OwnerKey) {
+		return &InvalidTransactionError{Tx: tx, Reason: "the file is owned by " + record.Owner}
+	}
+
+	switch tx.Type {
+	case TX_UNPUBLISH:
+		return nil
+	case TX_RENAME:
+		if tx.NewName == "" {
+			return &InvalidTransactionError{Tx: tx, Reason: "the new name is empty"}
+		}
+		if renamed, ok := ledger[tx.NewName]; ok && !renamed.Unpublished {
+			return &DuplicateNameError{Name: tx.NewName}
+		}
+		return nil
+	case TX_TRANSFER:
+		if tx.NewOwner == "" || len(tx.NewOwnerKey) != ed25519.PublicKeySize {
+			return &InvalidTransactionError{Tx: tx, Reason: "the new owner is not valid"}
+		}
+		return nil
+	default:
+		return &InvalidTransactionError{Tx: tx, Reason: "unknown type"}
+	}
+}
+
+//applyTransaction applies a valid transaction to the ledger
+func applyTransaction(ledger map[string]FileRecord, tx TxPublish) {
+	if tx.IsMembershipChange() {
+		return
+	}
+
+	hash := tx.Hash()
+	record := ledger[tx.Name]
+	switch tx.Type {
+	case TX_PUBLISH:
+		ledger[tx.Name] = FileRecord{
+			Name:         tx.Name,
+			Size:         tx.Size,
+			MetafileHash: tx.MetafileHash,
+			Owner:        tx.Owner,
+			OwnerKey:     tx.PublicKey,
+			Last:         hash,
+		}
+	case TX_UNPUBLISH:
+		record.Unpublished = true
+		record.Last = hash
+		ledger[tx.Name] = record
+	case TX_RENAME:
+		renamed := record
+		renamed.Name = tx.NewName
+		renamed.Last = hash
+		ledger[tx.NewName] = renamed
+
+		record.Unpublished = true
+		record.RenamedTo = tx.NewName
+		record.Last = hash
+		ledger[tx.Name] = record
+	case TX_TRANSFER:
+		record.Owner = tx.NewOwner
+		record.OwnerKey = tx.NewOwnerKey
+		record.Last = hash
+		ledger[tx.Name] = record
+	}
+}
+
+//resolve follows the renames of the file called name in the ledger. It returns the record of the file under its current
+//name. ok is false if the name is not in the ledger.
+func resolve(ledger map[string]FileRecord, name string) (record FileRecord, ok bool) {
+	record, ok = ledger[name]
+	//a name is only renamed once before being used again, the bound only protects against a corrupted ledger
+	for i := 0; ok && record.RenamedTo != "" && i < len(ledger); i++ {
+		record, ok = ledger[record.RenamedTo]
+	}
+	return
+}
+
+//InvalidTransactionError is returned when a transaction cannot be applied to the ledger.
+type InvalidTransactionError struct {
+	Tx     TxPublish
+	Reason string
+}
+
+func (e *InvalidTransactionError) Error() string {
+	return "invalid transaction " + e.Tx.Label() + ": " + e.Reason
+}
@@ -0,0 +1,463 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/dedis/protobuf"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//MembershipChange is a transaction that adds a gossiper to the members of TLC (Join) or removes it (leave).
+//It is signed by the gossiper itself, so that any member can propose it on its behalf.
+//Member    string is the name of the gossiper joining or leaving
+//Join      bool is true for a join and false for a leave
+//Sequence  uint32 is the number of changes of the member already in the chain. It prevents a change from being replayed.
+//PublicKey []byte is the public key of the member
+//Signature []byte is the signature of the change by the member
+type MembershipChange struct {
+	Member    string
+	Join      bool
+	Sequence  uint32
+	PublicKey []byte
+	Signature []byte
+}
+
+//String returns the change in the format "JOIN <member>" or "LEAVE <member>"
+func (mc *MembershipChange) String() string {
+	if mc.Join {
+		return "JOIN " + mc.Member
+	}
+	return "LEAVE " + mc.Member
+}
+
+//Label returns the change in the format "join(<member>)" or "leave(<member>)", used in place of a file name when the chain is printed
+func (mc *MembershipChange) Label() string {
+	if mc.Join {
+		return "join(" + mc.Member + ")"
+	}
+	return "leave(" + mc.Member + ")"
+}
+
+//Sign signs the change with the private key of the member and attaches its public key.
+func (mc *MembershipChange) Sign(sk ed25519.PrivateKey) error {
+	mc.PublicKey = sk.Public().(ed25519.PublicKey)
+	mc.Signature = nil
+
+	toSign, err := protobuf.Encode(mc)
+	if err != nil {
+		return err
+	}
+
+	mc.Signature = ed25519.Sign(sk, toSign)
+	return nil
+}
+
+//Verify checks that the signature of the change matches its attached public key.
+func (mc *MembershipChange) Verify() bool {
+	if len(mc.PublicKey) != ed25519.PublicKeySize || len(mc.Signature) != ed25519.SignatureSize {
+		return false
+	}
+
+	unsigned := *mc
+	unsigned.Signature = nil
+	signed, err := protobuf.Encode(&unsigned)
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(mc.PublicKey, signed, mc.Signature)
+}
+
+//Founder is a founding member of TLC
+//Name      string is the name of the member
+//PublicKey []byte is the public key of the member. It is nil if it is not given, in which case the key of the member
+//is the one learned on first use by the gossiper.
+type Founder struct {
+	Name      string
+	PublicKey []byte
+}
+
+//Members is the set of the members of TLC at some point of the chain. It maps the name of each member to its public key,
+//nil for a founding member whose key is not given.
+type Members map[string][]byte
+
+//Names returns the names of the members, sorted
+func (ms Members) Names() []string {
+	names := make([]string, 0, len(ms))
+	for name := range ms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Membership is the set of the members of TLC agreed in the longest chain.
+//The founding members are given by name (flag -founders): a founding member does not join, but it can leave and join
+//again. The other gossipers join and leave with MembershipChange transactions. The key of a member is the key of the
+//founder, or the key with which it joined for the first time: all its later changes must be signed with this key.
+//All operations on the Membership are thread-safe.
+type Membership struct {
+	sync.RWMutex
+	founders map[string][]byte
+	joined   map[string]bool
+	left     map[string]bool
+	keys     map[string][]byte
+	changes  map[string]uint32
+	history  map[string]bool
+}
+
+func MembershipFactory(founders []Founder) *Membership {
+	m := &Membership{
+		RWMutex:  sync.RWMutex{},
+		founders: make(map[string][]byte),
+		joined:   make(map[string]bool),
+		left:     make(map[string]bool),
+		keys:     make(map[string][]byte),
+		changes:  make(map[string]uint32),
+	}
+	for _, founder := range founders {
+		m.founders[founder.Name] = founder.PublicKey
+	}
+	m.history = map[string]bool{historyKey(m.current().Names()): true}
+	return m
+}
+
+//Size returns the number of members
+func (m *Membership) Size() int {
+	m.RLock()
+	defer m.RUnlock()
+	return len(m.founders) - len(m.left) + len(m.joined)
+}
+
+//IsMember checks if the gossiper called name is a member
+func (m *Membership) IsMember(name string) bool {
+	m.RLock()
+	defer m.RUnlock()
+	return m.isMember(name)
+}
+
+//isMember is the implementation of IsMember. It must be called with the lock held.
+func (m *Membership) isMember(name string) bool {
+	if m.joined[name] {
+		return true
+	}
+	_, founder := m.founders[name]
+	return founder && !m.left[name]
+}
+
+//Current returns the members with their keys
+func (m *Membership) Current() Members {
+	m.RLock()
+	defer m.RUnlock()
+	return m.current()
+}
+
+//current is the implementation of Current. It must be called with the lock held.
+func (m *Membership) current() Members {
+	members := make(Members)
+	for name, key := range m.founders {
+		if !m.left[name] {
+			members[name] = key
+		}
+	}
+	for name := range m.joined {
+		members[name] = m.keys[name]
+	}
+	return members
+}
+
+//Members returns the names of the members that joined, sorted, and the number of founding members that did not leave
+func (m *Membership) Members() ([]string, int) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return sortedNames(m.joined), len(m.founders) - len(m.left)
+}
+
+//WasMembers returns the members with the given names, with their keys, if they were the members after some block of the
+//longest chain (or before the first one). It returns false otherwise.
+func (m *Membership) WasMembers(names []string) (Members, bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	if !m.history[historyKey(sorted)] {
+		return nil, false
+	}
+
+	members := make(Members)
+	for _, name := range sorted {
+		if key, founder := m.founders[name]; founder {
+			members[name] = key
+		} else {
+			members[name] = m.keys[name]
+		}
+	}
+	return members, true
+}
+
+//Sequence returns the number of changes of the member in the chain, i.e. the sequence number of its next change
+func (m *Membership) Sequence(member string) uint32 {
+	m.RLock()
+	defer m.RUnlock()
+	return m.changes[member]
+}
+
+//Check verifies that the change can be applied: its sequence number is the next one of the member,
+//a joining gossiper is not a member yet, a leaving gossiper is still a member, and the change is signed with the key
+//known for the member, if any. The signature itself is not checked.
+func (m *Membership) Check(change *MembershipChange) error {
+	m.RLock()
+	defer m.RUnlock()
+	return m.check(change)
+}
+
+//check is the implementation of Check. It must be called with the lock held.
+func (m *Membership) check(change *MembershipChange) error {
+	if change.Sequence != m.changes[change.Member] {
+		return &InvalidMembershipChangeError{Change: *change, Reason: fmt.Sprintf("sequence %d instead of %d", change.Sequence, m.changes[change.Member])}
+	}
+
+	if change.Join && m.isMember(change.Member) {
+		return &InvalidMembershipChangeError{Change: *change, Reason: "already a member"}
+	}
+
+	if !change.Join && !m.isMember(change.Member) {
+		return &InvalidMembershipChangeError{Change: *change, Reason: "not a member"}
+	}
+
+	if key := m.key(change.Member); key != nil && !bytes.Equal(key, change.PublicKey) {
+		return &InvalidMembershipChangeError{Change: *change, Reason: "not signed with the key of the member"}
+	}
+	return nil
+}
+
+//key returns the key known for the member, nil if there is none. It must be called with the lock held.
+func (m *Membership) key(member string) []byte {
+	if key, founder := m.founders[member]; founder {
+		return key
+	}
+	return m.keys[member]
+}
+
+//apply applies a valid change. It must be called with the lock held.
+func (m *Membership) apply(change *MembershipChange) {
+	_, founder := m.founders[change.Member]
+	switch {
+	case change.Join && founder:
+		delete(m.left, change.Member)
+	case change.Join:
+		m.joined[change.Member] = true
+		if _, ok := m.keys[change.Member]; !ok {
+			m.keys[change.Member] = append([]byte(nil), change.PublicKey...)
+		}
+	case founder:
+		m.left[change.Member] = true
+	default:
+		delete(m.joined, change.Member)
+	}
+	m.changes[change.Member] += 1
+}
+
+//Sync rebuilds the membership from the changes contained in the blocks of the longest chain, from the first block to the head.
+//The changes that are not valid or not signed are ignored.
+func (m *Membership) Sync(blocks []Linked) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.joined = make(map[string]bool)
+	m.left = make(map[string]bool)
+	m.keys = make(map[string][]byte)
+	m.changes = make(map[string]uint32)
+	m.history = map[string]bool{historyKey(m.current().Names()): true}
+
+	for _, block := range blocks {
+		for _, tx := range block.GetTransactions() {
+			if tx.IsMembershipChange() && tx.Membership.Verify() && m.check(tx.Membership) == nil {
+				m.apply(tx.Membership)
+			}
+		}
+		m.history[historyKey(m.current().Names())] = true
+	}
+}
+
+//historyKey returns the key of a set of members in the history, from their sorted names
+func historyKey(names []string) string {
+	return strings.Join(names, "\n")
+}
+
+//MembershipSnapshot is the state of a Membership, stored in the snapshots of the ledger log.
+//Founders []MemberKey are the founding members with their keys (nil if not given), sorted by member
+//Joined   []string are the members that joined, sorted
+//Left     []string are the founding members that left, sorted
+//Keys     []MemberKey are the keys of the gossipers that joined, sorted by member
+//Changes  []MemberChanges are the numbers of changes of each member in the chain, sorted by member
+//History  []MemberSet are the members after some block of the chain, sorted
+type MembershipSnapshot struct {
+	Founders []MemberKey
+	Joined   []string
+	Left     []string
+	Keys     []MemberKey
+	Changes  []MemberChanges
+	History  []MemberSet
+}
+
+//MemberKey is the public key of a member
+type MemberKey struct {
+	Member    string
+	PublicKey []byte
+}
+
+//MemberChanges is the number of changes of a member in the chain
+type MemberChanges struct {
+	Member string
+	Count  uint32
+}
+
+//MemberSet is a set of members, by sorted names
+type MemberSet struct {
+	Names []string
+}
+
+//Equal checks if the two snapshots describe the same membership
+func (ms MembershipSnapshot) Equal(other MembershipSnapshot) bool {
+	if len(ms.Founders) != len(other.Founders) || len(ms.Joined) != len(other.Joined) || len(ms.Left) != len(other.Left) ||
+		len(ms.Keys) != len(other.Keys) || len(ms.Changes) != len(other.Changes) || len(ms.History) != len(other.History) {
+		return false
+	}
+	for i := range ms.Founders {
+		if ms.Founders[i].Member != other.Founders[i].Member || !bytes.Equal(ms.Founders[i].PublicKey, other.Founders[i].PublicKey) {
+			return false
+		}
+	}
+	for i := range ms.Joined {
+		if ms.Joined[i] != other.Joined[i] {
+			return false
+		}
+	}
+	for i := range ms.Left {
+		if ms.Left[i] != other.Left[i] {
+			return false
+		}
+	}
+	for i := range ms.Keys {
+		if ms.Keys[i].Member != other.Keys[i].Member || !bytes.Equal(ms.Keys[i].PublicKey, other.Keys[i].PublicKey) {
+			return false
+		}
+	}
+	for i := range ms.Changes {
+		if ms.Changes[i] != other.Changes[i] {
+			return false
+		}
+	}
+	for i := range ms.History {
+		if historyKey(ms.History[i].Names) != historyKey(other.History[i].Names) {
+			return false
+		}
+	}
+	return true
+}
+
+//Snapshot returns the state of the membership
+func (m *Membership) Snapshot() MembershipSnapshot {
+	m.RLock()
+	defer m.RUnlock()
+
+	snapshot := MembershipSnapshot{
+		Founders: sortedKeys(m.founders),
+		Joined:   sortedNames(m.joined),
+		Left:     sortedNames(m.left),
+		Keys:     sortedKeys(m.keys),
+		Changes:  make([]MemberChanges, 0, len(m.changes)),
+		History:  make([]MemberSet, 0, len(m.history)),
+	}
+	for member, count := range m.changes {
+		snapshot.Changes = append(snapshot.Changes, MemberChanges{Member: member, Count: count})
+	}
+	sort.Slice(snapshot.Changes, func(i, j int) bool {
+		return snapshot.Changes[i].Member < snapshot.Changes[j].Member
+	})
+	for _, key := range sortedNames(m.history) {
+		names := make([]string, 0)
+		if key != "" {
+			names = strings.Split(key, "\n")
+		}
+		snapshot.History = append(snapshot.History, MemberSet{Names: names})
+	}
+	return snapshot
+}
+
+//Restore replaces the state of the membership with the snapshot.
+//It returns false (and leaves the membership unchanged) if the snapshot has other founding members.
+func (m *Membership) Restore(snapshot MembershipSnapshot) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	founders := sortedKeys(m.founders)
+	if len(founders) != len(snapshot.Founders) {
+		return false
+	}
+	for i := range founders {
+		if founders[i].Member != snapshot.Founders[i].Member || !bytes.Equal(founders[i].PublicKey, snapshot.Founders[i].PublicKey) {
+			return false
+		}
+	}
+
+	m.joined = make(map[string]bool)
+	for _, name := range snapshot.Joined {
+		m.joined[name] = true
+	}
+	m.left = make(map[string]bool)
+	for _, name := range snapshot.Left {
+		m.left[name] = true
+	}
+	m.keys = make(map[string][]byte)
+	for _, key := range snapshot.Keys {
+		m.keys[key.Member] = key.PublicKey
+	}
+	m.changes = make(map[string]uint32)
+	for _, changes := range snapshot.Changes {
+		m.changes[changes.Member] = changes.Count
+	}
+	m.history = make(map[string]bool)
+	for _, set := range snapshot.History {
+		m.history[historyKey(set.Names)] = true
+	}
+	return true
+}
+
+//sortedKeys returns the keys of the members, sorted by member
+func sortedKeys(keys map[string][]byte) []MemberKey {
+	sorted := make([]MemberKey, 0, len(keys))
+	for member, key := range keys {
+		sorted = append(sorted, MemberKey{Member: member, PublicKey: key})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Member < sorted[j].Member
+	})
+	return sorted
+}
+
+//sortedNames returns the names of the set, sorted
+func sortedNames(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//InvalidMembershipChangeError is returned when a membership change cannot be applied.
+type InvalidMembershipChangeError struct {
+	Change MembershipChange
+	Reason string
+}
+
+func (e *InvalidMembershipChangeError) Error() string {
+	return "invalid membership change " + e.Change.String() + ": " + e.Reason
+}
@@ -0,0 +1,91 @@
+package blockchain
+
+import (
+	"sync"
+)
+
+//Miner keeps the transactions waiting to be included in a mined block.
+//All the known transactions are kept, so that a transaction comes back to the pending ones
+//if the block including it is abandoned because of a fork. The pending transactions are the known ones that can be
+//applied to the ledger of the longest chain.
+//Difficulty is the number of leading zero bits required in the hash of a block.
+//The miners wait on the channel returned by Work: it is closed every time the pending transactions
+//or the head of the chain change, so that they restart with fresh work.
+//All operations on the Miner are thread-safe.
+type Miner struct {
+	sync.Mutex
+	Difficulty int
+	known      []TxPublish
+	pending    []TxPublish
+	ledger     map[string]FileRecord
+	seen       map[[32]byte]bool
+	changed    chan struct{}
+}
+
+func MinerFactory(difficulty int) *Miner {
+	return &Miner{
+		Mutex:      sync.Mutex{},
+		Difficulty: difficulty,
+		known:      make([]TxPublish, 0),
+		pending:    make([]TxPublish, 0),
+		ledger:     make(map[string]FileRecord),
+		seen:       make(map[[32]byte]bool),
+		changed:    make(chan struct{}),
+	}
+}
+
+//AddTransaction adds tx to the pending transactions. It returns false if tx was already seen.
+func (m *Miner) AddTransaction(tx TxPublish) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	hash := tx.Hash()
+	if m.seen[hash] {
+		return false
+	}
+	m.seen[hash] = true
+
+	//only the first file published under a free name is kept
+	for _, known := range m.known {
+		if tx.Type == TX_PUBLISH && known.Type == TX_PUBLISH && known.Name == tx.Name && known.Previous == tx.Previous {
+			return true
+		}
+	}
+	m.known = append(m.known, tx)
+
+	if checkTransaction(m.ledger, tx) == nil {
+		m.pending = append(m.pending, tx)
+		m.notify()
+	}
+	return true
+}
+
+//Prune sets the ledger of the new head of the chain: the pending transactions are the known transactions
+//that can be applied to the ledger. The miners are notified.
+func (m *Miner) Prune(ledger map[string]FileRecord) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.ledger = ledger
+	pending := make([]TxPublish, 0, len(m.known))
+	for _, tx := range m.known {
+		if checkTransaction(ledger, tx) == nil {
+			pending = append(pending, tx)
+		}
+	}
+	m.pending = pending
+	m.notify()
+}
+
+//Work returns a copy of the pending transactions and a channel that is closed when they become stale
+func (m *Miner) Work() ([]TxPublish, <-chan struct{}) {
+	m.Lock()
+	defer m.Unlock()
+	return append([]TxPublish(nil), m.pending...), m.changed
+}
+
+//notify wakes up the miners. It must be called with the lock held.
+func (m *Miner) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
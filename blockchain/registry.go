@@ -0,0 +1,166 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+//Registration is the claim of a file name
+//Name     string is the claimed file name
+//MetaHash []byte is the metahash of the file published under this name
+//Owner    string is the owner of the file, empty if it is unknown
+type Registration struct {
+	Name     string
+	MetaHash []byte
+	Owner    string
+}
+
+//String returns the registration in the format "REGISTERED <name> metahash <metahash> owner <owner>"
+func (r Registration) String() string {
+	return fmt.Sprintf("REGISTERED %s metahash %s owner %s", r.Name, hex.EncodeToString(r.MetaHash), r.Owner)
+}
+
+//Registry maps each claimed file name to its registration.
+//A name is claimed by the first confirmed TLC message publishing it, and later claims of the same name with another
+//file are rejected. The names of the longest chain override the claims, since the chain is what the nodes agreed on:
+//the names unpublished or renamed in the chain are released.
+//All operations on the Registry are thread-safe.
+type Registry struct {
+	sync.RWMutex
+	names map[string]Registration
+}
+
+func RegistryFactory() *Registry {
+	return &Registry{
+		RWMutex: sync.RWMutex{},
+		names:   make(map[string]Registration),
+	}
+}
+
+//Claim registers the name of the transaction for owner.
+//It returns a NameClaimedError if the name is already claimed for another file.
+func (r *Registry) Claim(tx TxPublish, owner string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if registration, ok := r.names[tx.Name]; ok {
+		if !bytes.Equal(registration.MetaHash, tx.MetafileHash) {
+			return &NameClaimedError{Registration: registration}
+		}
+		if registration.Owner == "" {
+			registration.Owner = owner
+			r.names[tx.Name] = registration
+		}
+		return nil
+	}
+
+	r.names[tx.Name] = Registration{
+		Name:     tx.Name,
+		MetaHash: tx.MetafileHash,
+		Owner:    owner,
+	}
+	return nil
+}
+
+//Sync registers the names of the ledger of the longest chain with their owner. A name of the ledger replaces a claim
+//for another file. An unpublished name is released, unless it is claimed for another file.
+func (r *Registry) Sync(ledger map[string]FileRecord) {
+	r.Lock()
+	defer r.Unlock()
+
+	for name, record := range ledger {
+		registration, ok := r.names[name]
+		sameFile := ok && bytes.Equal(registration.MetaHash, record.MetafileHash)
+		if record.Unpublished {
+			if sameFile {
+				delete(r.names, name)
+			}
+			continue
+		}
+
+		if sameFile && registration.Owner == record.Owner {
+			continue
+		}
+		r.names[name] = Registration{
+			Name:     name,
+			MetaHash: record.MetafileHash,
+			Owner:    record.Owner,
+		}
+	}
+}
+
+//Restore replaces all the registrations with the given ones. It is used to resume from a snapshot of the ledger log.
+func (r *Registry) Restore(registrations []Registration) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.names = make(map[string]Registration, len(registrations))
+	for _, registration := range registrations {
+		r.names[registration.Name] = registration
+	}
+}
+
+//Check verifies that the transaction does not claim a name registered for another file, by publishing or by renaming a file
+func (r *Registry) Check(tx TxPublish) error {
+	r.RLock()
+	defer r.RUnlock()
+
+	name := tx.Name
+	switch {
+	case tx.Type == TX_RENAME:
+		name = tx.NewName
+	case tx.IsFileOperation():
+		return nil
+	}
+
+	if registration, ok := r.names[name]; ok && !bytes.Equal(registration.MetaHash, tx.MetafileHash) {
+		return &NameClaimedError{Registration: registration}
+	}
+	return nil
+}
+
+//Lookup returns the registration of the name. ok is false if the name is not claimed.
+func (r *Registry) Lookup(name string) (registration Registration, ok bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	registration, ok = r.names[name]
+	return
+}
+
+//IsConfirmed checks if the name is registered for the file with the given metahash
+func (r *Registry) IsConfirmed(name string, metaHash []byte) bool {
+	registration, ok := r.Lookup(name)
+	return ok && bytes.Equal(registration.MetaHash, metaHash)
+}
+
+//Registrations returns all the registrations sorted by name
+func (r *Registry) Registrations() []Registration {
+	r.RLock()
+	defer r.RUnlock()
+
+	registrations := make([]Registration, 0, len(r.names))
+	for _, registration := range r.names {
+		registrations = append(registrations, registration)
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrations[i].Name < registrations[j].Name
+	})
+	return registrations
+}
+
+//NameClaimedError is returned when a file name is already claimed for another file.
+type NameClaimedError struct {
+	Registration Registration
+}
+
+func (e *NameClaimedError) Error() string {
+	owner := e.Registration.Owner
+	if owner == "" {
+		owner = "an unknown gossiper"
+	}
+	return "file name " + e.Registration.Name + " is already claimed by " + owner
+}
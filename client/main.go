@@ -4,13 +4,18 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"github.com/somecookie/Peerster/clock"
 	"github.com/somecookie/Peerster/helper"
 	"github.com/somecookie/Peerster/packet"
+	"github.com/somecookie/Peerster/transport"
 	"log"
 	"net"
 	"os"
+	"time"
 )
 
+const REPLY_TIMEOUT = 2
+
 var (
 	uiPort        string
 	msg           string
@@ -19,6 +24,15 @@ var (
 	requestString string
 	keywords      string
 	budget        uint64
+	encrypt       bool
+	download      string
+	registry      string
+	membership    string
+	unpublish     bool
+	rename        string
+	transfer      string
+	anonymous     bool
+	reply         string
 )
 
 func init() {
@@ -29,12 +43,60 @@ func init() {
 	flag.StringVar(&requestString, "request", "", "request a chunk or metafile of this hash")
 	flag.StringVar(&keywords, "keywords", "", "comma separated list of searched keywords")
 	flag.Uint64Var(&budget, "budget", 0, "budget for the search")
+	flag.StringVar(&download, "download", "", "manage the downloads: list, or pause, resume or cancel the download of -file")
+	flag.StringVar(&registry, "registry", "", "query the name registry: all, or a comma separated list of file names")
+	flag.StringVar(&membership, "membership", "", "change the membership of the gossiper in TLC: join or leave")
+	flag.BoolVar(&unpublish, "unpublish", false, "unpublish the file -file owned by the gossiper")
+	flag.StringVar(&rename, "rename", "", "new name of the file -file owned by the gossiper")
+	flag.StringVar(&transfer, "transfer", "", "name of the gossiper receiving the ownership of the file -file")
+	flag.BoolVar(&encrypt, "encrypt", false, "encrypt the private message end-to-end for its destination")
+	flag.BoolVar(&anonymous, "anonymous", false, "send the private message anonymously through a path of relays")
+	flag.StringVar(&reply, "reply", "", "ID of the reply block of the anonymous message answered with -msg")
 
 	flag.Parse()
 }
 
 func validFlags() bool {
 
+	if encrypt && (dest == "" || msg == "") {
+		return false //only private messages can be encrypted
+	}
+
+	if anonymous && (dest == "" || msg == "" || encrypt) {
+		return false //only private messages can be anonymous, and they are always encrypted
+	}
+
+	if reply != "" {
+		return msg != "" && dest == "" && file == "" && requestString == "" && keywords == "" && budget == 0 && !encrypt &&
+			!anonymous && download == "" && registry == "" && membership == "" //reply to an anonymous message
+	}
+
+	if registry != "" {
+		return msg == "" && dest == "" && file == "" && requestString == "" && keywords == "" && budget == 0 && !encrypt &&
+			download == "" //registry query
+	}
+
+	if membership != "" {
+		return msg == "" && dest == "" && file == "" && requestString == "" && keywords == "" && budget == 0 && !encrypt &&
+			download == "" && registry == "" && (membership == "join" || membership == "leave") //membership change
+	}
+
+	if unpublish || rename != "" || transfer != "" {
+		operations := 0
+		for _, set := range []bool{unpublish, rename != "", transfer != ""} {
+			if set {
+				operations++
+			}
+		}
+		return operations == 1 && file != "" && msg == "" && dest == "" && requestString == "" && keywords == "" &&
+			budget == 0 && !encrypt && download == "" && registry == "" && membership == "" //operation on a published file
+	}
+
+	if download != "" {
+		return msg == "" && dest == "" && requestString == "" && keywords == "" && budget == 0 && !encrypt &&
+			(download == "list" || file != "") //download management
+	}
+
 	if file != "" && requestString != "" && msg == "" && keywords == "" && budget == 0 {
 		return true //download
 	} else if dest != "" && file == "" && requestString == "" && msg != "" && keywords == "" && budget == 0{
@@ -61,7 +123,9 @@ func main() {
 	defer conn.Close()
 
 	msg := &packet.Message{
-		Text: msg,
+		Text:      msg,
+		Encrypt:   encrypt,
+		Anonymous: anonymous,
 	}
 
 	if requestString != "" {
@@ -79,6 +143,10 @@ func main() {
 		msg.Destination = &dest
 	}
 
+	if reply != "" {
+		msg.Reply = &reply
+	}
+
 	if file != "" {
 		msg.File = &file
 	}
@@ -91,10 +159,57 @@ func main() {
 		msg.Budget = &budget
 	}
 
+	if download != "" {
+		msg.Download = &download
+	}
+
+	if registry != "" {
+		msg.Registry = &registry
+	}
+
+	if membership != "" {
+		msg.Membership = &membership
+	}
+
+	msg.Unpublish = unpublish
+	if rename != "" {
+		msg.Rename = &rename
+	}
+
+	if transfer != "" {
+		msg.Transfer = &transfer
+	}
+
 	packetBytes, err := packet.GetPacketBytes(msg)
 
 	helper.HandleCrashingErr(err)
 	sendPacket(conn, packetBytes, udpAddr)
+
+	if download != "" || registry != "" {
+		printReply(conn)
+	}
+}
+
+//printReply waits for the reply of the gossiper to a command and prints it.
+func printReply(conn *net.UDPConn) {
+	buffer := make([]byte, transport.MAX_UDP_SIZE)
+	reassembler := transport.ReassemblerFactory(transport.REASSEMBLY_TIMEOUT, clock.RealClockFactory())
+	helper.LogError(conn.SetReadDeadline(time.Now().Add(REPLY_TIMEOUT * time.Second)))
+
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil {
+			fmt.Println("ERROR (No reply from the gossiper)")
+			os.Exit(1)
+		}
+
+		reply, complete, err := reassembler.Add(conn.RemoteAddr().String(), buffer[:n])
+		helper.LogError(err)
+		if complete {
+			fmt.Println(string(reply))
+			return
+		}
+	}
 }
 
 // sendPacket sends the previously created packet.
@@ -0,0 +1,174 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+//Clock is the source of time of the gossiper. All the timers of the gossiper are created with its Clock,
+//so that a simulation can control the time.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+//Ticker delivers ticks at intervals on the channel returned by C.
+//As for time.Ticker, ticks are dropped if the receiver is too slow.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+//Sleep blocks until c moved forward by d
+func Sleep(c Clock, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	ticker := c.NewTicker(d)
+	defer ticker.Stop()
+	<-ticker.C()
+}
+
+//RealClock is the Clock backed by the package time.
+type RealClock struct{}
+
+func RealClockFactory() *RealClock {
+	return &RealClock{}
+}
+
+func (rc *RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (rc *RealClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (rt *realTicker) C() <-chan time.Time {
+	return rt.ticker.C
+}
+
+func (rt *realTicker) Stop() {
+	rt.ticker.Stop()
+}
+
+//ManualClock is a Clock whose time only moves forward when Advance is called.
+//All operations on the ManualClock are thread-safe.
+type ManualClock struct {
+	sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+}
+
+//ManualClockFactory creates a ManualClock starting at start
+func ManualClockFactory(start time.Time) *ManualClock {
+	return &ManualClock{
+		Mutex:   sync.Mutex{},
+		now:     start,
+		tickers: make([]*manualTicker, 0),
+	}
+}
+
+func (mc *ManualClock) Now() time.Time {
+	mc.Lock()
+	defer mc.Unlock()
+	return mc.now
+}
+
+func (mc *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	mc.Lock()
+	defer mc.Unlock()
+
+	mt := &manualTicker{
+		clock:  mc,
+		c:      make(chan time.Time, 1),
+		period: d,
+		next:   mc.now.Add(d),
+	}
+	mc.tickers = append(mc.tickers, mt)
+	return mt
+}
+
+//Advance moves the time forward by d. The tickers fire in chronological order.
+func (mc *ManualClock) Advance(d time.Duration) {
+	mc.Lock()
+	defer mc.Unlock()
+
+	target := mc.now.Add(d)
+	for {
+		sort.Slice(mc.tickers, func(i, j int) bool {
+			return mc.tickers[i].next.Before(mc.tickers[j].next)
+		})
+
+		if len(mc.tickers) == 0 || mc.tickers[0].next.After(target) {
+			break
+		}
+
+		mt := mc.tickers[0]
+		mc.now = mt.next
+		mt.next = mt.next.Add(mt.period)
+
+		select {
+		case mt.c <- mc.now:
+		default:
+		}
+	}
+	mc.now = target
+}
+
+//Pending returns the number of ticks that were delivered to the active tickers but not received yet
+func (mc *ManualClock) Pending() int {
+	mc.Lock()
+	defer mc.Unlock()
+
+	pending := 0
+	for _, mt := range mc.tickers {
+		pending += len(mt.c)
+	}
+	return pending
+}
+
+//NbrTickers returns the number of active tickers
+func (mc *ManualClock) NbrTickers() int {
+	mc.Lock()
+	defer mc.Unlock()
+	return len(mc.tickers)
+}
+
+//remove stops the ticker mt
+func (mc *ManualClock) remove(mt *manualTicker) {
+	mc.Lock()
+	defer mc.Unlock()
+
+	for i, ticker := range mc.tickers {
+		if ticker == mt {
+			mc.tickers = append(mc.tickers[:i], mc.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+type manualTicker struct {
+	clock  *ManualClock
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+func (mt *manualTicker) C() <-chan time.Time {
+	return mt.c
+}
+
+func (mt *manualTicker) Stop() {
+	mt.clock.remove(mt)
+}
@@ -6,20 +6,32 @@ import (
 )
 
 //DownloadState is a structure used to keep track of the downloads/uploads
-//The State fields maps the origin to a file metahash. Then the metahash is mapped to the index of the next chunk.
-//At 0-index is the metafile.
+//The State fields maps the file name to a file metahash. Then the metahash is mapped to the number of pieces received so far.
+//The metafile is the first piece.
+//Schedulers maps the metahash of a file being downloaded to the scheduler of its chunks.
+//Downloads maps the metahash of a file being downloaded to the state of the download kept on disk.
+//Window is the maximal number of chunk requests in flight per download.
+//Metafiles is the set of the metahashes whose metafile is being requested, so that it is requested only once at a time.
 type DownloadState struct {
-	State map[string]map[string]uint64
-	ACKs  map[string]map[string]chan *packet.DataReply //destination -> hash of chunk -> chan to know if chunk has been acked
-	Mutex sync.RWMutex
+	State      map[string]map[string]uint64
+	ACKs       map[string]map[string][]chan *packet.DataReply //destination -> hash of chunk -> chans of the requests waiting for the chunk
+	Metafiles  map[string]bool
+	Schedulers map[string]*ChunkScheduler
+	Downloads  map[string]*PartialDownload
+	Window     int
+	Mutex      sync.RWMutex
 }
 
 
-func DownloadStateFactory() *DownloadState {
+func DownloadStateFactory(window int) *DownloadState {
 	return &DownloadState{
-		State: make(map[string]map[string]uint64),
-		ACKs:  make(map[string]map[string]chan  *packet.DataReply),
-		Mutex: sync.RWMutex{},
+		State:      make(map[string]map[string]uint64),
+		ACKs:       make(map[string]map[string][]chan  *packet.DataReply),
+		Metafiles:  make(map[string]bool),
+		Schedulers: make(map[string]*ChunkScheduler),
+		Downloads:  make(map[string]*PartialDownload),
+		Window:     window,
+		Mutex:      sync.RWMutex{},
 	}
 }
 
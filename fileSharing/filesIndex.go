@@ -28,7 +28,7 @@ func (fi *FilesIndex) Store(metadata *Metadata){
 }
 
 //FindChunkFromHash takes a hash of a chunk and find the chunk in the index.
-//If the hash is the metahash, it returns the metafile
+//If the hash is the metahash, it returns the metafile, otherwise the chunk is read from disk.
 //It returns nil if this chunk is not in the index
 func (fi *FilesIndex)FindChunkFromHash(hash string) []byte{
 	for metahash, metadata := range fi.Index{
@@ -36,8 +36,8 @@ func (fi *FilesIndex)FindChunkFromHash(hash string) []byte{
 			return metadata.Metafile
 		}
 
-		if chunk, ok := metadata.Chunks[hash]; ok{
-			return chunk
+		if _, ok := metadata.Chunks[hash]; ok{
+			return metadata.ReadChunk(hash)
 		}
 	}
 	return nil
@@ -45,21 +45,20 @@ func (fi *FilesIndex)FindChunkFromHash(hash string) []byte{
 
 //FindMatchingFiles finds all indexed files that match to the list keywords.
 //keywords []string the list of keywords
+//resolve func(name string, metaHash []byte) (string, bool) gives the current name of a file and whether it is still published.
+//The unpublished files are skipped and the others are matched and returned under their current name.
 //Returns a list of *packet.SearchResult
-func (fi *FilesIndex) FindMatchingFiles(keywords []string) []*packet.SearchResult{
+func (fi *FilesIndex) FindMatchingFiles(keywords []string, resolve func(name string, metaHash []byte) (string, bool)) []*packet.SearchResult{
 	results := make([]*packet.SearchResult,0)
 	for _, keyword := range keywords{
 		for _, metadata := range fi.Index{
-			if strings.Contains(metadata.Name, keyword){
+			name, published := resolve(metadata.Name, metadata.MetaHash)
+			if published && strings.Contains(name, keyword){
 
-				chunkMap := make([]uint64,metadata.LastReceivedChunk)
-
-				for i := uint64(1); i <= metadata.LastReceivedChunk; i++{
-					chunkMap[i-1] = i
-				}
+				chunkMap := metadata.ChunkMap()
 
 				results = append(results, &packet.SearchResult{
-					FileName:     metadata.Name,
+					FileName:     name,
 					MetafileHash: metadata.MetaHash,
 					ChunkMap:     chunkMap,
 					ChunkCount:   metadata.NbrChunks,
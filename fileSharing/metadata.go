@@ -14,34 +14,44 @@ import (
 //The name of the file
 //The size of the file
 //The Metafile is the concatenation of the sha256 of each files
-//Chunks is a map used to store the chunks mapped to its hash
+//Chunks is a map used to locate the chunks available on disk, mapped to their hash
 //The MetaHash, i.e., the hash of the metafile. The metahash is the only unique identifier of the file.
+//NbrChunks is the number of chunks of the file
+//Path is the path of the file holding the chunks. The chunks are read from it on demand.
 type Metadata struct {
-	Name              string
-	Size              uint32
-	Metafile          []byte
-	Chunks            map[string][]byte
-	MetaHash          []byte
-	NbrChunks         uint64
-	LastReceivedChunk uint64
+	Name      string
+	Size      uint64
+	Metafile  []byte
+	Chunks    map[string]ChunkLocation
+	MetaHash  []byte
+	NbrChunks uint64
+	Path      string
+}
+
+//ChunkLocation is the position of a chunk in the file at Metadata.Path.
+//Index uint64 is the index of the chunk (from 1), the chunk starts at offset (Index-1)*CHUNK_SIZE
+//Size  int is the size of the chunk in bytes
+type ChunkLocation struct {
+	Index uint64
+	Size  int
 }
 
 const CHUNK_SIZE = 8192
 const PATH_SHAREDFILES = "./_SharedFiles/"
 const PATH_DOWNLOADS = "./_Downloads/"
 
-var hasher = sha256.New()
-
 //MetadataFromIndexing builds the metadata for a file and index it.
+//The file is read chunk by chunk and only the hash and the location of each chunk are kept in memory.
 func MetadataFromIndexing(fileName string) (*Metadata, error) {
+	filePath := PATH_SHAREDFILES + fileName
 	metadata := &Metadata{
 		Name:      fileName,
-		Chunks:    make(map[string][]byte),
+		Chunks:    make(map[string]ChunkLocation),
 		Size:      0,
 		NbrChunks: 0,
+		Path:      filePath,
 	}
 
-	filePath := PATH_SHAREDFILES + fileName
 	file, err := os.Open(filePath)
 	helper.LogError(err)
 	defer file.Close()
@@ -49,47 +59,42 @@ func MetadataFromIndexing(fileName string) (*Metadata, error) {
 	if err == nil {
 
 		metafileSlice := make([]byte, 0, 320)
+		chunk := make([]byte, CHUNK_SIZE)
 
 		for {
-			chunk := make([]byte, CHUNK_SIZE)
-			n, err := file.Read(chunk)
+			n, err := io.ReadFull(file, chunk)
 
 			if err == io.EOF {
 				break
 			}
 
-			if err != nil {
+			if err != nil && err != io.ErrUnexpectedEOF {
 				helper.LogError(err)
 				return nil, err
 			}
 
 			if n > 0 {
-				metadata.Size += uint32(n)
+				metadata.Size += uint64(n)
 				metadata.NbrChunks += 1
-				hash, err := metadata.hash(chunk[:n])
-
-				if err != nil {
-					helper.LogError(err)
-					return nil, err
-				}
+				hash := metadata.hash(chunk[:n])
 				hashStr := hex.EncodeToString(hash)
-				metadata.Chunks[hashStr] = chunk[:n]
+				if _, ok := metadata.Chunks[hashStr]; !ok {
+					metadata.Chunks[hashStr] = ChunkLocation{
+						Index: metadata.NbrChunks,
+						Size:  n,
+					}
+				}
 				metafileSlice = append(metafileSlice, hash...)
 			}
 
 		}
 
-		metaHash, err := metadata.hash(metafileSlice)
-		if err != nil {
-			helper.LogError(err)
-			return nil, err
-		}
+		metaHash := metadata.hash(metafileSlice)
 		metadata.MetaHash = metaHash
 		metadata.Metafile = metafileSlice
-		metadata.LastReceivedChunk = metadata.NbrChunks
 
 		fmt.Println("metaHash:  " + hex.EncodeToString(metaHash))
-		fmt.Println("n° chunks: ",len(metadata.Chunks))
+		fmt.Println("n° chunks: ",metadata.NbrChunks)
 
 		return metadata, nil
 	}
@@ -97,17 +102,50 @@ func MetadataFromIndexing(fileName string) (*Metadata, error) {
 	return nil, err
 }
 
-//hash returns the hash (as []byte) of the chunk or an error.
-func (metadata *Metadata) hash(chunk []byte) ([]byte, error) {
-	//hash
-	hasher.Reset()
-	_, err := hasher.Write(chunk)
+//ReadChunk reads the chunk with the given hash from the file on disk.
+//It returns nil if the chunk is not available.
+func (metadata *Metadata) ReadChunk(hash string) []byte {
+	location, ok := metadata.Chunks[hash]
+	if !ok {
+		return nil
+	}
 
+	file, err := os.Open(metadata.Path)
 	if err != nil {
-		return nil, err
+		helper.LogError(err)
+		return nil
 	}
-	hash := hasher.Sum(nil)
-	return hash, nil
+	defer file.Close()
+
+	chunk := make([]byte, location.Size)
+	if _, err := file.ReadAt(chunk, int64(location.Index-1)*CHUNK_SIZE); err != nil {
+		helper.LogError(err)
+		return nil
+	}
+	return chunk
+}
+
+//ChunkHash returns the hash of the chunk at the given index (indexed from 1)
+func (metadata *Metadata) ChunkHash(index uint64) []byte {
+	return metadata.Metafile[(index-1)*32 : index*32]
+}
+
+//ChunkMap returns the indexes (from 1) of the chunks that are locally available
+func (metadata *Metadata) ChunkMap() []uint64 {
+	chunkMap := make([]uint64, 0, metadata.NbrChunks)
+	for i := uint64(1); i <= metadata.NbrChunks; i++ {
+		if _, ok := metadata.Chunks[hex.EncodeToString(metadata.ChunkHash(i))]; ok {
+			chunkMap = append(chunkMap, i)
+		}
+	}
+	return chunkMap
+}
+
+//hash returns the hash (as []byte) of the chunk.
+//It does not share a hasher, so that several files can be indexed concurrently.
+func (metadata *Metadata) hash(chunk []byte) []byte {
+	hash := sha256.Sum256(chunk)
+	return hash[:]
 }
 
 type MetadataQueue struct{
@@ -0,0 +1,274 @@
+package fileSharing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const PART_EXTENSION = ".part"
+const STATE_EXTENSION = ".state"
+
+//SAVE_INTERVAL is the number of chunks received between two saves of the sidecar file.
+//The chunks received since the last save are simply downloaded again after a restart.
+const SAVE_INTERVAL = 64
+
+//PartialDownload is the state of a download that is kept on disk so that the download can be resumed after a restart.
+//The chunks are written in the preallocated file PATH_DOWNLOADS/<Name>.part at their offset and the state is written
+//in the sidecar file PATH_DOWNLOADS/<Name>.state.
+//Name          string is the name under which the file is stored
+//MetaHash      string is the hex encoded metahash of the file
+//Destination   string is the peer the metafile is requested from
+//Metafile      string is the hex encoded metafile, empty while the metafile has not been received
+//Received      []byte is a bitmap of the chunks already written in the part file, the bit i-1 stands for the chunk i
+//LastChunkSize int is the size of the last chunk of the file, 0 while it has not been received
+//Owners        map[uint64][]string is the mapping of the index of a chunk to the peers who own it
+//Paused        bool tells if the download has been paused by the user
+//unsaved       int is the number of chunks received since the last save
+type PartialDownload struct {
+	Name          string
+	MetaHash      string
+	Destination   string
+	Metafile      string
+	Received      []byte
+	LastChunkSize int
+	Owners        map[uint64][]string
+	Paused        bool
+	unsaved       int
+}
+
+//PartialDownloadFactory creates the state of a new download
+func PartialDownloadFactory(name, metaHash, destination string) *PartialDownload {
+	return &PartialDownload{
+		Name:        name,
+		MetaHash:    metaHash,
+		Destination: destination,
+		Received:    make([]byte, 0),
+		Owners:      make(map[uint64][]string),
+	}
+}
+
+//LoadPartialDownloads finds all the sidecar files in PATH_DOWNLOADS and loads the corresponding downloads.
+func LoadPartialDownloads() ([]*PartialDownload, error) {
+	paths, err := filepath.Glob(PATH_DOWNLOADS + "*" + STATE_EXTENSION)
+	if err != nil {
+		return nil, err
+	}
+
+	downloads := make([]*PartialDownload, 0, len(paths))
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		pd := &PartialDownload{}
+		if err := json.Unmarshal(content, pd); err != nil {
+			return nil, err
+		}
+
+		if pd.Owners == nil {
+			pd.Owners = make(map[uint64][]string)
+		}
+		downloads = append(downloads, pd)
+	}
+	return downloads, nil
+}
+
+//Save writes the sidecar file. The file is first written to a temporary file and then renamed
+//so that a crash never leaves a corrupted state.
+func (pd *PartialDownload) Save() error {
+	content, err := json.Marshal(pd)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(PATH_DOWNLOADS, 0755); err != nil {
+		return err
+	}
+
+	tmp := pd.statePath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+
+	pd.unsaved = 0
+	return os.Rename(tmp, pd.statePath())
+}
+
+//Remove deletes the sidecar file and the part file
+func (pd *PartialDownload) Remove() {
+	os.Remove(pd.statePath())
+	os.Remove(pd.PartPath())
+}
+
+//Preallocate sets the metafile of the download and allocates the part file for all the chunks of the file.
+func (pd *PartialDownload) Preallocate(metafile []byte) error {
+	pd.Metafile = hex.EncodeToString(metafile)
+	pd.Received = make([]byte, (pd.NbrChunks()+7)/8)
+
+	file, err := os.OpenFile(pd.PartPath(), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Truncate(int64(pd.NbrChunks()) * CHUNK_SIZE)
+}
+
+//WriteChunk writes the chunk at the given index (from 1) in the part file and records it as received.
+//The sidecar file is saved every SAVE_INTERVAL chunks.
+func (pd *PartialDownload) WriteChunk(index uint64, chunk []byte) error {
+	if index == 0 || index > pd.NbrChunks() {
+		return &ChunkIndexError{Index: index, Name: pd.Name}
+	}
+
+	file, err := os.OpenFile(pd.PartPath(), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.WriteAt(chunk, int64(index-1)*CHUNK_SIZE); err != nil {
+		return err
+	}
+
+	if index == pd.NbrChunks() {
+		pd.LastChunkSize = len(chunk)
+	}
+
+	if !pd.HasChunk(index) {
+		pd.Received[(index-1)/8] |= 1 << ((index - 1) % 8)
+		pd.unsaved += 1
+	}
+
+	if pd.unsaved >= SAVE_INTERVAL {
+		return pd.Save()
+	}
+	return nil
+}
+
+//ReadChunk reads the chunk at the given index (from 1) from the part file.
+func (pd *PartialDownload) ReadChunk(index uint64) ([]byte, error) {
+	file, err := os.Open(pd.PartPath())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	chunk := make([]byte, pd.ChunkSize(index))
+	if _, err := file.ReadAt(chunk, int64(index-1)*CHUNK_SIZE); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+//ChunkSize returns the size of the chunk at the given index.
+//All chunks have a size of CHUNK_SIZE except the last one.
+func (pd *PartialDownload) ChunkSize(index uint64) int {
+	if index == pd.NbrChunks() && pd.LastChunkSize > 0 {
+		return pd.LastChunkSize
+	}
+	return CHUNK_SIZE
+}
+
+//Finalize truncates the part file to the exact size of the file, moves it to PATH_DOWNLOADS/<Name>
+//and removes the sidecar file. It returns the path and the size of the downloaded file.
+func (pd *PartialDownload) Finalize() (string, uint64, error) {
+	size := uint64(0)
+	if pd.NbrChunks() > 0 {
+		size = (pd.NbrChunks()-1)*CHUNK_SIZE + uint64(pd.ChunkSize(pd.NbrChunks()))
+	}
+
+	if err := os.Truncate(pd.PartPath(), int64(size)); err != nil {
+		return "", 0, err
+	}
+
+	path := PATH_DOWNLOADS + pd.Name
+	if err := os.Rename(pd.PartPath(), path); err != nil {
+		return "", 0, err
+	}
+
+	os.Remove(pd.statePath())
+	return path, size, nil
+}
+
+//HasChunk tells if the chunk at the given index has already been received
+func (pd *PartialDownload) HasChunk(index uint64) bool {
+	if index == 0 || (index-1)/8 >= uint64(len(pd.Received)) {
+		return false
+	}
+	return pd.Received[(index-1)/8]&(1<<((index-1)%8)) != 0
+}
+
+//ReceivedChunks returns the indexes (from 1) of the received chunks
+func (pd *PartialDownload) ReceivedChunks() []uint64 {
+	received := make([]uint64, 0)
+	for i := uint64(1); i <= pd.NbrChunks(); i++ {
+		if pd.HasChunk(i) {
+			received = append(received, i)
+		}
+	}
+	return received
+}
+
+//Forget marks the chunk at the given index as not received
+func (pd *PartialDownload) Forget(index uint64) {
+	if pd.HasChunk(index) {
+		pd.Received[(index-1)/8] &^= 1 << ((index - 1) % 8)
+	}
+}
+
+//MetafileBytes returns the decoded metafile, nil if it has not been received yet.
+func (pd *PartialDownload) MetafileBytes() []byte {
+	metafile, err := hex.DecodeString(pd.Metafile)
+	if err != nil || len(metafile) == 0 {
+		return nil
+	}
+	return metafile
+}
+
+//NbrChunks returns the number of chunks of the file, 0 if the metafile has not been received yet.
+func (pd *PartialDownload) NbrChunks() uint64 {
+	return uint64(len(pd.Metafile) / 64)
+}
+
+//PartPath returns the path of the part file
+func (pd *PartialDownload) PartPath() string {
+	return PATH_DOWNLOADS + pd.Name + PART_EXTENSION
+}
+
+func (pd *PartialDownload) statePath() string {
+	return PATH_DOWNLOADS + pd.Name + STATE_EXTENSION
+}
+
+//String returns a one line summary of the download
+func (pd *PartialDownload) String() string {
+	status := "downloading"
+	if pd.Paused {
+		status = "paused"
+	} else if pd.Metafile == "" {
+		status = "waiting for metafile"
+	}
+
+	return strings.Join([]string{
+		pd.Name,
+		pd.MetaHash,
+		strconv.Itoa(len(pd.ReceivedChunks())) + "/" + strconv.FormatUint(pd.NbrChunks(), 10),
+		status,
+	}, " ")
+}
+
+//ChunkIndexError is returned when a chunk index is out of the bounds of the file
+type ChunkIndexError struct {
+	Index uint64
+	Name  string
+}
+
+func (e *ChunkIndexError) Error() string {
+	return "chunk " + strconv.FormatUint(e.Index, 10) + " is out of the bounds of " + e.Name
+}
@@ -0,0 +1,185 @@
+package fileSharing
+
+import (
+	"sort"
+	"sync"
+)
+
+//ChunkScheduler decides which chunk of a file is requested from which peer.
+//It keeps at most window requests in flight, always requests the rarest missing chunk first
+//and spreads the requests over the owners of the chunk.
+//When a request times out, the chunk is given back and requested from another owner.
+//All operations of ChunkScheduler are thread-safe.
+//hashes   map[uint64]string is the mapping of the index of a chunk to its hash
+//byHash   map[string][]uint64 is the mapping of a hash to the indexes of the chunks with this hash
+//owners   map[uint64][]string is the mapping of the index of a chunk to the peers who own it
+//order    []uint64 are the indexes of the chunks sorted from the rarest to the most common
+//start    int is the position in order before which all the chunks have been received
+//missing  map[uint64]bool is the set of chunks that have not been received yet
+//inFlight map[uint64]string is the mapping of a requested chunk to the peer it has been requested from
+//load     map[string]int is the number of requests in flight per peer
+//failed   map[uint64]map[string]bool records the peers that did not answer the request for a chunk
+type ChunkScheduler struct {
+	sync.Mutex
+	window   int
+	hashes   map[uint64]string
+	byHash   map[string][]uint64
+	owners   map[uint64][]string
+	order    []uint64
+	start    int
+	missing  map[uint64]bool
+	inFlight map[uint64]string
+	load     map[string]int
+	failed   map[uint64]map[string]bool
+}
+
+//ChunkSchedulerFactory creates a scheduler for the chunks with the given hashes (indexed from 1).
+//window int is the maximal number of requests in flight
+//hashes map[uint64]string is the mapping of the index of each missing chunk to its hash
+//owners map[uint64][]string is the mapping of the index of a chunk to the peers who own it
+func ChunkSchedulerFactory(window int, hashes map[uint64]string, owners map[uint64][]string) *ChunkScheduler {
+	if window < 1 {
+		window = 1
+	}
+
+	missing := make(map[uint64]bool)
+	byHash := make(map[string][]uint64)
+	order := make([]uint64, 0, len(hashes))
+	for index, hash := range hashes {
+		missing[index] = true
+		byHash[hash] = append(byHash[hash], index)
+		order = append(order, index)
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		if len(owners[order[i]]) != len(owners[order[j]]) {
+			return len(owners[order[i]]) < len(owners[order[j]])
+		}
+		return order[i] < order[j]
+	})
+
+	return &ChunkScheduler{
+		Mutex:    sync.Mutex{},
+		window:   window,
+		hashes:   hashes,
+		byHash:   byHash,
+		owners:   owners,
+		order:    order,
+		start:    0,
+		missing:  missing,
+		inFlight: make(map[uint64]string),
+		load:     make(map[string]int),
+		failed:   make(map[uint64]map[string]bool),
+	}
+}
+
+//Next selects the next chunk to request and the peer to request it from.
+//It returns false if the window is full or if no missing chunk can be requested.
+func (cs *ChunkScheduler) Next() (uint64, string, bool) {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if len(cs.inFlight) >= cs.window {
+		return 0, "", false
+	}
+
+	for cs.start < len(cs.order) && !cs.missing[cs.order[cs.start]] {
+		cs.start += 1
+	}
+
+	requestedHashes := make(map[string]bool)
+	for index := range cs.inFlight {
+		requestedHashes[cs.hashes[index]] = true
+	}
+
+	found := false
+	var next uint64
+	for _, index := range cs.order[cs.start:] {
+		if _, ok := cs.inFlight[index]; !cs.missing[index] || ok || requestedHashes[cs.hashes[index]] || len(cs.owners[index]) == 0 {
+			continue
+		}
+		next = index
+		found = true
+		break
+	}
+
+	if !found {
+		return 0, "", false
+	}
+
+	candidates := cs.candidates(next)
+	owner := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if cs.load[candidate] < cs.load[owner] {
+			owner = candidate
+		}
+	}
+
+	cs.inFlight[next] = owner
+	cs.load[owner] += 1
+	return next, owner, true
+}
+
+//candidates returns the owners of the chunk that did not fail to send it yet.
+//If all of them failed, they are all given a new chance.
+func (cs *ChunkScheduler) candidates(index uint64) []string {
+	candidates := make([]string, 0, len(cs.owners[index]))
+	for _, owner := range cs.owners[index] {
+		if !cs.failed[index][owner] {
+			candidates = append(candidates, owner)
+		}
+	}
+
+	if len(candidates) == 0 {
+		delete(cs.failed, index)
+		return cs.owners[index]
+	}
+	return candidates
+}
+
+//Received marks the chunk at the given index, and all the chunks with the same hash, as received.
+//It returns the indexes of all the chunks that were marked as received.
+func (cs *ChunkScheduler) Received(index uint64) []uint64 {
+	cs.Lock()
+	defer cs.Unlock()
+
+	received := make([]uint64, 0, 1)
+	for _, i := range cs.byHash[cs.hashes[index]] {
+		if cs.missing[i] {
+			delete(cs.missing, i)
+			received = append(received, i)
+		}
+	}
+	cs.release(index)
+	return received
+}
+
+//TimedOut gives back the chunk at the given index so that it is requested from another owner.
+func (cs *ChunkScheduler) TimedOut(index uint64) {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if owner, ok := cs.inFlight[index]; ok {
+		if _, ok := cs.failed[index]; !ok {
+			cs.failed[index] = make(map[string]bool)
+		}
+		cs.failed[index][owner] = true
+	}
+	cs.release(index)
+}
+
+//release removes the request of the chunk from the requests in flight
+func (cs *ChunkScheduler) release(index uint64) {
+	if owner, ok := cs.inFlight[index]; ok {
+		cs.load[owner] -= 1
+		delete(cs.inFlight, index)
+	}
+}
+
+//Done tells if all the chunks have been received
+func (cs *ChunkScheduler) Done() bool {
+	cs.Lock()
+	defer cs.Unlock()
+
+	return len(cs.missing) == 0
+}
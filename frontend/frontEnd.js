@@ -1,10 +1,17 @@
 let numberMessage = 0
 let myID = ""
 let active = "Rumors"
+let explorerBlock = null
+let explorerRound = null
 
 function sendNewMessage() {
     let inputText = document.getElementById("text-input")
 
+    if (active === "Explorer") {
+        alert("You cannot send a message to the explorer")
+        inputText.value = ""
+        return
+    }
 
     if (inputText.value != "") {
         $.ajax({
@@ -12,7 +19,8 @@ function sendNewMessage() {
             url: "http://localhost:8080/message",
             data: {
                 "value": inputText.value,
-                "dest": active
+                "dest": active,
+                "encrypt": document.getElementById("encrypt-input").checked
             },
             success: () => {
                 inputText.value = ""
@@ -29,7 +37,9 @@ function sendNewMessage() {
 }
 
 
-function addNewMessage(origin, content) {
+//addNewMessage shows a message of the chat. status is the delivery status of a private message sent by the gossiper
+//(sent, queued, deposited or delivered), it is not shown for the other messages.
+function addNewMessage(origin, content, status) {
     let messageList = document.getElementById("chat-message-list")
     let messageRow = document.createElement("div")
     if (origin === myID) {
@@ -48,6 +58,13 @@ function addNewMessage(origin, content) {
     messageRow.appendChild(messageText)
     messageRow.appendChild(messageOrigin)
 
+    if (origin === myID && status) {
+        let messageStatus = document.createElement("div")
+        messageStatus.className = "message-status status-" + status
+        messageStatus.innerHTML = status
+        messageRow.appendChild(messageStatus)
+    }
+
     messageList.insertAdjacentElement('afterbegin', messageRow)
 
 }
@@ -100,6 +117,11 @@ setInterval(() => {
 }, 1000)
 
 function getMessages() {
+    if (active === "Explorer") {
+        getExplorer()
+        return
+    }
+
     $.ajax({
         type: "GET",
         url: "http://localhost:8080/message",
@@ -115,7 +137,7 @@ function getMessages() {
             }
 
             for (let msg of data) {
-                addNewMessage(msg.Origin, msg.Text)
+                addNewMessage(msg.Origin, msg.Text, msg.Status)
             }
         }, error: status => {
             let list = document.getElementById("chat-message-list")
@@ -127,6 +149,109 @@ function getMessages() {
     })
 }
 
+//getExplorer shows what the network agreed on: the selected block or round (if any),
+//then the blocks of the longest chain and the TLC rounds, the latest first
+function getExplorer() {
+    $.when(
+        $.ajax({ type: "GET", url: "http://localhost:8080/blocks", dataType: 'json' }),
+        $.ajax({ type: "GET", url: "http://localhost:8080/rounds", dataType: 'json' })
+    ).done((blocks, rounds) => {
+        let list = document.getElementById("chat-message-list")
+
+        while (list.hasChildNodes()) {
+            list.removeChild(list.lastChild)
+        }
+
+        if (explorerBlock !== null) {
+            addExplorerRow(`block ${explorerBlock.Height}`, blockDetails(explorerBlock), () => { explorerBlock = null; getExplorer() })
+        }
+
+        if (explorerRound !== null) {
+            let text = `round ${explorerRound.Round}<br>`
+            for (let c of explorerRound.Confirmations) {
+                text += `${c.Origin} #${c.ID} ${transactionLabel(c.Transaction)} fitness ${c.Fitness.toFixed(3)} ` +
+                    `block ${c.BlockHash.substring(0, 8)} witnesses ${c.Witnesses.join(",")}<br>`
+            }
+            addExplorerRow(`round ${explorerRound.Round}`, text, () => { explorerRound = null; getExplorer() })
+        }
+
+        for (let block of blocks[0]) {
+            let text = `${block.Hash.substring(0, 8)} &larr; ${block.PrevHash.substring(0, 8)}: ` +
+                block.Transactions.map(transactionLabel).join(", ")
+            addExplorerRow(`block ${block.Height}`, text, () => selectBlock(block.Hash))
+        }
+
+        for (let round of rounds[0]) {
+            let text = `${round.Confirmed} confirmed of ${round.Members} members`
+            if (round.Best !== "") {
+                text += `, best from ${round.Best}`
+            }
+            addExplorerRow(`round ${round.Round}`, text, () => selectRound(round.Round))
+        }
+    })
+}
+
+function addExplorerRow(title, content, onclick) {
+    let messageList = document.getElementById("chat-message-list")
+    let messageRow = document.createElement("div")
+    messageRow.className = "message-row other-message explorer"
+    messageRow.onclick = onclick
+
+    let messageText = document.createElement("div")
+    messageText.className = "message-text"
+    messageText.innerHTML = content
+
+    let messageOrigin = document.createElement("div")
+    messageOrigin.className = "message-origin"
+    messageOrigin.innerHTML = title
+
+    messageRow.appendChild(messageText)
+    messageRow.appendChild(messageOrigin)
+    messageList.appendChild(messageRow)
+}
+
+function transactionLabel(tx) {
+    return tx.Label
+}
+
+function blockDetails(block) {
+    let text = `hash ${block.Hash}<br>previous ${block.PrevHash}<br>`
+    for (let tx of block.Transactions) {
+        if (tx.Membership !== "") {
+            text += `${tx.Membership}<br>`
+        } else {
+            text += `${tx.Label} (${tx.Size} bytes) owner ${tx.Owner} metahash ${tx.MetaHash}<br>`
+        }
+    }
+    return text
+}
+
+function selectBlock(hash) {
+    $.ajax({
+        type: "GET",
+        url: "http://localhost:8080/blocks",
+        data: { "hash": hash },
+        dataType: 'json',
+        success: function (data, status) {
+            explorerBlock = data
+            getExplorer()
+        }
+    })
+}
+
+function selectRound(round) {
+    $.ajax({
+        type: "GET",
+        url: "http://localhost:8080/rounds",
+        data: { "round": round },
+        dataType: 'json',
+        success: function (data, status) {
+            explorerRound = { Round: round, Confirmations: data }
+            getExplorer()
+        }
+    })
+}
+
 function getAllNodes() {
     $.ajax({
         type: "GET",
@@ -166,7 +291,8 @@ function getMatches() {
                 origin = match["Origin"]
                 metahash = match["MetaHash"]
                 let matchDiv = document.createElement("div")
-                matchDiv.className = "search"
+                matchDiv.className = match["Confirmed"] ? "search confirmed" : "search"
+                matchDiv.title = match["Confirmed"] ? "name registered for this file" : "name not confirmed"
                 matchDiv.onclick =(function() {
                     var currentFileName = fileName;
                     var currentMetaHash = metahash;
@@ -223,8 +349,8 @@ function buttonClickSearch() {
 
 function buttonClickDownload() {
 
-    if (active === "Rumors") {
-        alert("You cannot download from Rumors")
+    if (active === "Rumors" || active === "Explorer") {
+        alert(`You cannot download from ${active}`)
         return
     }
 
@@ -277,6 +403,7 @@ function getAllOrigins() {
             }
 
             addConversation("Rumors")
+            addConversation("Explorer")
 
             for (let origin of data.sort()) {
                 addConversation(origin)
@@ -21,7 +21,7 @@ type PendingACK struct {
 
 
 //WaitForAck waits for the acknowledgment. It timeouts after 10 seconds.
-func (g *Gossiper) WaitForAck(message *packet.GossipPacket, peerAddr *net.UDPAddr) {
+func (g *Gossiper) WaitForAck(message *packet.GossipPacket, peerAddr net.Addr) {
 
 	origin, id := message.GetOriginAndID()
 
@@ -33,11 +33,11 @@ func (g *Gossiper) WaitForAck(message *packet.GossipPacket, peerAddr *net.UDPAdd
 		return
 	}
 
-	ticker := time.NewTicker(TIMEOUT * time.Second)
+	ticker := g.clock.NewTicker(TIMEOUT * time.Second)
 	defer ticker.Stop()
 
 	select {
-	case <-ticker.C:
+	case <-ticker.C():
 
 		g.pendingACK.Mutex.Lock()
 		g.RemoveACKed(message, peerAddr)
@@ -54,7 +54,7 @@ func (g *Gossiper) WaitForAck(message *packet.GossipPacket, peerAddr *net.UDPAdd
 }
 
 //AddToPendingACK adds the ack to the List of pending acknowledgment
-func (g *Gossiper) AddToPendingACK(origin string, ID uint32, peerAddr *net.UDPAddr) chan *packet.StatusPacket {
+func (g *Gossiper) AddToPendingACK(origin string, ID uint32, peerAddr net.Addr) chan *packet.StatusPacket {
 	new := false
 	ack := ACK{
 		Origin: origin,
@@ -80,7 +80,7 @@ func (g *Gossiper) AddToPendingACK(origin string, ID uint32, peerAddr *net.UDPAd
 
 }
 
-func (g *Gossiper) RemoveACKed(message *packet.GossipPacket, peerAddr *net.UDPAddr) {
+func (g *Gossiper) RemoveACKed(message *packet.GossipPacket, peerAddr net.Addr) {
 	origin, id := message.GetOriginAndID()
 	ack := ACK{
 		Origin: origin,
@@ -94,7 +94,7 @@ func (g *Gossiper) RemoveACKed(message *packet.GossipPacket, peerAddr *net.UDPAd
 
 }
 
-func (g *Gossiper) AckRumors(peerAddr *net.UDPAddr, statusPacket *packet.StatusPacket) bool {
+func (g *Gossiper) AckRumors(peerAddr net.Addr, statusPacket *packet.StatusPacket) bool {
 	hasACKED := false
 	peerVector := statusPacket.Want
 
@@ -0,0 +1,172 @@
+package gossip
+
+import (
+	"github.com/somecookie/Peerster/packet"
+	"net"
+	"time"
+)
+
+//requestCatchUp asks the peers for the certificates of the rounds the gossiper missed.
+//Only one request is sent at a time: a new one can be sent once a reply is applied or after stubbornTimeout seconds.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) requestCatchUp() {
+	tm := g.TLCMajority
+	if tm.CatchingUp {
+		return
+	}
+	tm.CatchingUp = true
+
+	request := &packet.GossipPacket{CatchUp: &packet.TLCCatchUpRequest{
+		Origin: g.Name,
+		Round:  tm.MyRound,
+	}}
+
+	go func() {
+		g.broadcast(request, nil)
+
+		ticker := g.clock.NewTicker(time.Duration(g.stubbornTimeout) * time.Second)
+		<-ticker.C()
+		ticker.Stop()
+
+		tm.Lock()
+		tm.CatchingUp = false
+		tm.Unlock()
+	}()
+}
+
+//CatchUpRequestRoutine answers a lagging peer with the certificates of the rounds from the requested one,
+//at most packet.CATCHUP_MAX_ROUNDS of them. Nothing is sent if the gossiper is not ahead of the peer.
+func (g *Gossiper) CatchUpRequestRoutine(request *packet.TLCCatchUpRequest, from net.Addr) {
+	tm := g.TLCMajority
+	tm.RLock()
+	reply := &packet.TLCCatchUpReply{
+		Origin:       g.Name,
+		Round:        tm.MyRound,
+		Certificates: make([]packet.RoundCertificate, 0),
+	}
+	for r := request.Round; r < tm.MyRound && r < request.Round+packet.CATCHUP_MAX_ROUNDS; r++ {
+		certificate := packet.RoundCertificate{
+			Round:         r,
+			Members:       tm.Members[r].Names(),
+			Confirmations: make([]packet.TLCMessage, 0, len(tm.Confirmed[r])),
+		}
+		for _, c := range tm.Confirmed[r] {
+			if c.Message != nil {
+				certificate.Confirmations = append(certificate.Confirmations, *c.Message)
+			}
+		}
+		reply.Certificates = append(reply.Certificates, certificate)
+	}
+	tm.RUnlock()
+
+	if len(reply.Certificates) > 0 {
+		g.sendMessage(&packet.GossipPacket{Certificates: reply}, from)
+	}
+}
+
+//CatchUpReplyRoutine verifies the certificates of the reply and fast-forwards the gossiper through the rounds they prove.
+//The certificates are applied in order from the round of the gossiper, until one of them is not valid.
+//Once caught up, the gossiper takes part in the current round again. If the peer is still ahead, the gossiper asks again.
+func (g *Gossiper) CatchUpReplyRoutine(reply *packet.TLCCatchUpReply) {
+	tm := g.TLCMajority
+	tm.Lock()
+	defer tm.Unlock()
+
+	start := tm.MyRound
+	for i := range reply.Certificates {
+		certificate := &reply.Certificates[i]
+		if certificate.Round != tm.MyRound {
+			continue
+		}
+
+		confirmations, ok := g.verifyCertificate(certificate)
+		if !ok {
+			break
+		}
+		g.fastForward(certificate.Round, confirmations)
+	}
+
+	if tm.MyRound == start {
+		return
+	}
+	packet.PrintCaughtUp(tm.MyRound, reply.Origin)
+
+	tm.CatchingUp = false
+	if tm.MyRound < reply.Round {
+		g.requestCatchUp()
+	}
+
+	if tm.ReicvCommand {
+		g.proposeNext()
+		g.TryNextRound()
+	} else {
+		g.rejoin()
+	}
+}
+
+//verifyCertificate returns the confirmations of the certificate that are signed by members of its round, one per origin.
+//The certificate is valid if they are more than a majority of the members in its round and if each of them carries
+//a valid certificate of its witnesses. The members
+//given by the certificate are used since the membership of the gossiper may already include later changes,
+//but they must be the members at some point of the chain. They are then recorded for the round.
+//The keys of the members are never learned from the certificate.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) verifyCertificate(certificate *packet.RoundCertificate) ([]*packet.TLCMessage, bool) {
+	members, ok := g.TLCMajority.Membership.WasMembers(certificate.Members)
+	if !ok {
+		return nil, false
+	}
+
+	origins := make(map[string]bool)
+	confirmations := make([]*packet.TLCMessage, 0, len(certificate.Confirmations))
+	for i := range certificate.Confirmations {
+		msg := &certificate.Confirmations[i]
+		if msg.Confirmed == -1 || msg.Round != certificate.Round || origins[msg.Origin] {
+			continue
+		}
+
+		if !g.isFromMember(msg, members) {
+			continue
+		}
+
+		if !g.isWitnessed(msg, members) {
+			continue
+		}
+		origins[msg.Origin] = true
+		confirmations = append(confirmations, msg)
+	}
+
+	if len(confirmations) <= g.TLCMajority.threshold(len(members)) {
+		return nil, false
+	}
+	g.TLCMajority.Members[certificate.Round] = members
+	return confirmations, true
+}
+
+//fastForward counts the confirmations of a certificate in the given round and leaves the round without proposing anything.
+//The pending message of the gossiper in this round, if any, is abandoned.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) fastForward(round uint32, confirmations []*packet.TLCMessage) {
+	tm := g.TLCMajority
+	for _, msg := range confirmations {
+		tm.seen(msg.Origin, msg.Round)
+		if tm.hasConfirmation(round, msg.Origin, msg.ID) {
+			continue
+		}
+
+		g.recordConfirmation(msg)
+		tm.AddConfirmation(round, Confirmation{
+			Origin:  msg.Origin,
+			ID:      msg.ID,
+			TxBlock: msg.TxBlock,
+			Fitness: msg.Fitness,
+			Message: msg,
+		})
+		g.adoptAncestors(msg.TxBlock.PrevHash)
+	}
+
+	tm.MyRound += 1
+	g.PrintNextRound(tm.MyRound, tm.Confirmed[round])
+	tm.signal(tm.LastID, false)
+	g.qscRecord(round)
+}
@@ -0,0 +1,157 @@
+package gossip
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/packet"
+)
+
+//testMember is a gossiper of the tests, with its signing key
+type testMember struct {
+	name string
+	sk   ed25519.PrivateKey
+}
+
+//testMembers generates members with fresh keys
+func testMembers(t *testing.T, names ...string) []testMember {
+	members := make([]testMember, 0, len(names))
+	for _, name := range names {
+		_, sk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, testMember{name: name, sk: sk})
+	}
+	return members
+}
+
+//founders returns the members as founders with their keys
+func founders(members []testMember) []blockchain.Founder {
+	founders := make([]blockchain.Founder, 0, len(members))
+	for _, member := range members {
+		founders = append(founders, blockchain.Founder{Name: member.name, PublicKey: member.sk.Public().(ed25519.PublicKey)})
+	}
+	return founders
+}
+
+//testGossiper returns a gossiper with only the TLC state of the given founders
+func testGossiper(founders []blockchain.Founder, f int) *Gossiper {
+	return &Gossiper{TLCMajority: TLCMajorityFactory(founders, f)}
+}
+
+//ack returns the ack of witness for the unconfirmed message msg
+func ack(t *testing.T, witness testMember, msg *packet.TLCMessage) packet.TLCAck {
+	hash := msg.Witnessed()
+	ack := packet.TLCAck{Origin: witness.name, ID: msg.ID, Destination: msg.Origin, Hash: hash[:]}
+	if err := ack.Sign(witness.sk); err != nil {
+		t.Fatal(err)
+	}
+	return ack
+}
+
+//confirmation returns the signed confirmation by origin of its message in round, witnessed by witnesses.
+//The message publishes a file of origin.
+func confirmation(t *testing.T, origin testMember, round uint32, witnesses ...testMember) packet.TLCMessage {
+	tx := blockchain.TxPublish{Name: origin.name + ".txt", Size: 1, MetafileHash: []byte(origin.name), Owner: origin.name}
+	if err := tx.Sign(origin.sk); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &packet.TLCMessage{
+		Origin:    origin.name,
+		ID:        1,
+		Confirmed: -1,
+		Round:     round,
+		TxBlock:   blockchain.BlockPublish{Transaction: tx},
+		Fitness:   0.5,
+	}
+
+	acks := make([]packet.TLCAck, 0, len(witnesses))
+	for _, witness := range witnesses {
+		acks = append(acks, ack(t, witness, msg))
+	}
+
+	msg.ID, msg.Confirmed, msg.Witnesses = 2, 1, acks
+	if err := msg.Sign(origin.sk); err != nil {
+		t.Fatal(err)
+	}
+	return *msg
+}
+
+func names(members []testMember) []string {
+	names := make([]string, 0, len(members))
+	for _, member := range members {
+		names = append(names, member.name)
+	}
+	return names
+}
+
+func TestVerifyCertificate(t *testing.T) {
+	members := testMembers(t, "A", "B", "C", "D")
+	a, b, c, d := members[0], members[1], members[2], members[3]
+	outsider := testMembers(t, "E")[0]
+
+	cases := []struct {
+		name        string
+		certificate packet.RoundCertificate
+		valid       bool
+	}{
+		{"majority", packet.RoundCertificate{Round: 0, Members: names(members), Confirmations: []packet.TLCMessage{
+			confirmation(t, a, 0, a, b, c), confirmation(t, b, 0, b, c, d), confirmation(t, c, 0, a, c, d),
+		}}, true},
+		{"no majority", packet.RoundCertificate{Round: 0, Members: names(members), Confirmations: []packet.TLCMessage{
+			confirmation(t, a, 0, a, b, c), confirmation(t, b, 0, b, c, d),
+		}}, false},
+		{"duplicated origin", packet.RoundCertificate{Round: 0, Members: names(members), Confirmations: []packet.TLCMessage{
+			confirmation(t, a, 0, a, b, c), confirmation(t, a, 0, b, c, d), confirmation(t, b, 0, a, c, d),
+		}}, false},
+		{"other round", packet.RoundCertificate{Round: 0, Members: names(members), Confirmations: []packet.TLCMessage{
+			confirmation(t, a, 0, a, b, c), confirmation(t, b, 0, b, c, d), confirmation(t, c, 1, a, c, d),
+		}}, false},
+		{"confirmation of an outsider", packet.RoundCertificate{Round: 0, Members: names(members), Confirmations: []packet.TLCMessage{
+			confirmation(t, a, 0, a, b, c), confirmation(t, b, 0, b, c, d), confirmation(t, outsider, 0, a, c, d),
+		}}, false},
+		{"not the members", packet.RoundCertificate{Round: 0, Members: []string{"A", "B", "C"}, Confirmations: []packet.TLCMessage{
+			confirmation(t, a, 0, a, b, c), confirmation(t, b, 0, a, b, c),
+		}}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := testGossiper(founders(members), 0)
+			confirmations, ok := g.verifyCertificate(&tc.certificate)
+			if ok != tc.valid {
+				t.Fatalf("valid %t, expected %t", ok, tc.valid)
+			}
+			if !ok {
+				return
+			}
+
+			if len(confirmations) != len(tc.certificate.Confirmations) {
+				t.Fatalf("%d confirmations kept out of %d", len(confirmations), len(tc.certificate.Confirmations))
+			}
+			if len(g.TLCMajority.Members[tc.certificate.Round]) != len(members) {
+				t.Fatalf("members of the round not recorded")
+			}
+		})
+	}
+}
+
+func TestVerifyCertificateFaultThreshold(t *testing.T) {
+	members := testMembers(t, "A", "B", "C", "D", "E")
+	a, b, c, d := members[0], members[1], members[2], members[3]
+	certificate := packet.RoundCertificate{Round: 0, Members: names(members), Confirmations: []packet.TLCMessage{
+		confirmation(t, a, 0, a, b, c), confirmation(t, b, 0, b, c, d), confirmation(t, c, 0, a, c, d),
+	}}
+
+	//3 out of 5 is a majority, but tolerating f=1 faulty member needs more than 2f+1=3 confirmations and witnesses
+	if _, ok := testGossiper(founders(members), 0).verifyCertificate(&certificate); !ok {
+		t.Fatal("certificate rejected without faulty member")
+	}
+	if _, ok := testGossiper(founders(members), 1).verifyCertificate(&certificate); ok {
+		t.Fatal("certificate accepted with f=1")
+	}
+}
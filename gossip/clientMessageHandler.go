@@ -2,6 +2,7 @@ package gossip
 
 import (
 	"encoding/hex"
+	"github.com/somecookie/Peerster/helper"
 	"github.com/somecookie/Peerster/packet"
 	"strings"
 	"sync/atomic"
@@ -13,7 +14,13 @@ const MAX_BUDGET = 32
 //HandleMessage is used to handle the messages that come from the client
 func (g *Gossiper) HandleMessage(message *packet.Message) {
 
-	if message.Destination == nil && message.File == nil && message.Request == nil && message.Text != "" {
+	if message.Reply != nil && message.Text != "" {
+		packet.PrintClientMessage(message)
+		go g.replyAnonymous(message)
+	} else if message.Anonymous && message.Destination != nil && message.Text != "" {
+		packet.PrintClientMessage(message)
+		go g.startAnonymous(message)
+	} else if message.Destination == nil && message.File == nil && message.Request == nil && message.Text != "" {
 		packet.PrintClientMessage(message)
 		if g.simple {
 			go g.sendSimpleMessage(message)
@@ -71,12 +78,12 @@ func (g *Gossiper) StartSearchRequest(message *packet.Message){
 
 		go g.SearchRequestRoutine(sr, nil)
 
-		ticker := time.NewTicker(time.Second)
+		ticker := g.clock.NewTicker(time.Second)
 		defer ticker.Stop()
 
 		for {
 			select {
-			case <-ticker.C:
+			case <-ticker.C():
 
 				g.fullMatches.Lock()
 				if budget >= MAX_BUDGET || g.fullMatches.n== THRESHOLD_MATCHES {
@@ -99,14 +106,18 @@ func (g *Gossiper) StartSearchRequest(message *packet.Message){
 //startRumor starts a new rumor when the gossiper receives a message from the client which is not a private message.
 func (g *Gossiper) startRumor(message *packet.Message) {
 
-	atomic.AddUint32(&g.counter, 1)
+	ID := atomic.AddUint32(&g.counter, 1)
 	rumorMessage := &packet.RumorMessage{
-		Origin: g.Name,
-		ID:     g.counter,
-		Text:   message.Text,
+		Origin:        g.Name,
+		ID:            ID,
+		Text:          message.Text,
+		EncryptionKey: g.Identity.EncryptionPublicKey(),
 	}
+	helper.LogError(rumorMessage.Sign(g.Identity.SigningKey))
 	gp := &packet.GossipPacket{Rumor:rumorMessage}
+	g.State.Mutex.Lock()
 	g.State.UpdateGossiperState(gp)
+	g.State.Mutex.Unlock()
 
 	g.Peers.Mutex.RLock()
 	length := len(g.Peers.Set)
@@ -138,26 +149,22 @@ func (g *Gossiper) sendSimpleMessage(message *packet.Message) {
 }
 
 //startPrivate starts a private chat between g.Name and message.Destination
+//If message.Encrypt is set, the text is encrypted for the destination so that the relays only see the ciphertext.
+//A message that cannot be delivered is queued until there is a route to its destination, or deposited at a mailbox peer.
 func (g *Gossiper) startPrivate(message *packet.Message) {
 
 	pm := &packet.PrivateMessage{
 		Origin:      g.Name,
-		ID:          0,
+		ID:          atomic.AddUint32(&g.privateCounter, 1),
 		Text:        message.Text,
 		Destination: *message.Destination,
 		HopLimit:    packet.MaxHops - 1,
 	}
 
-	g.DSDV.Mutex.RLock()
-	if g.DSDV.Contains(*message.Destination) {
-		g.sendMessage(&packet.GossipPacket{Private: pm}, g.DSDV.NextHop[*message.Destination])
-		g.DSDV.Mutex.RUnlock()
-
-		g.State.Mutex.Lock()
-		g.State.UpdatePrivateQueue(*message.Destination, pm)
-		g.State.Mutex.Unlock()
-	} else {
-		g.DSDV.Mutex.RUnlock()
-	}
+	g.State.Mutex.Lock()
+	g.State.UpdatePrivateQueue(*message.Destination, pm, PRIVATE_QUEUED, message.Encrypt)
+	g.State.Mutex.Unlock()
 
+	status := g.deliverPrivate(*message.Destination, PrivateEntry{PrivateMessage: *pm, Encrypted: message.Encrypt})
+	g.setDeliveryStatus(*message.Destination, pm.ID, status)
 }
@@ -0,0 +1,52 @@
+package gossip
+
+//Config contains the parameters of a gossiper. They have the same meaning as the flags of main.go.
+//GossipAddr is the address (ip:port) at which the gossiper listens for the other gossipers.
+//UIPort is the port at which the gossiper listens for the clients. If it is empty, the gossiper does not listen for clients.
+//Name is the name of the gossiper.
+//Peers are the addresses (ip:port) of the initial peers.
+//If the state is persisted with the storage backend called StorageKind, the gossiper resumes where it left off.
+//The chain, the registry and the membership are persisted with the same kind of backend in the ledger log.
+//If Miners > 0, the files are published by mining blocks (with Miners goroutines) instead of using TLC.
+//Difficulty is the number of leading zero bits of the hash of a valid mined block.
+//N is the number of founding members of TLC. If Join is set, the gossiper is not one of them and has to join them with ChangeMembership.
+//Founders are the founding members, of the form name or name:key (the hex encoded public key of the member). They are
+//needed when N > 1, unless the files are published by mining. Without key, the key of a founder is learned on first use.
+//FaultThreshold is the number of faulty members tolerated by TLC: a message needs more than 2*FaultThreshold+1 witnesses.
+//A route expires when it was not refreshed during RouteExpiry route rumor intervals (RTimer seconds). It never expires if
+//RouteExpiry or RTimer is 0.
+//Routing is the routing scheme used to forward the point-to-point messages: routing.ROUTING_DSDV or
+//routing.ROUTING_LINK_STATE. Link-state routing floods the neighbors with the route rumors, so it needs RTimer > 0.
+//The peers are probed every ProbeTimer seconds, so that the packets are sent to another candidate next hop when
+//the best one is unreachable.
+//The anonymous messages go through (at most) OnionRelays relays, and so do their replies. OnionRelays is less than
+//ONION_MAX_HOPS.
+//The undelivered private messages are retried every MailboxTimer seconds, or deposited at one of the Mailboxes peers.
+//Seed is the seed of the random choices of the gossiper (peers, coin flips, fitness of the proposals and onion relays).
+type Config struct {
+	GossipAddr      string
+	UIPort          string
+	Name            string
+	Peers           []string
+	Simple          bool
+	AckAll          bool
+	AntiEntropy     int
+	RTimer          int
+	HopLimit        int
+	N               int
+	Founders        []string
+	StubbornTimeout int
+	StorageKind     string
+	DownloadWindow  int
+	Miners          int
+	Difficulty      int
+	Join            bool
+	FaultThreshold  int
+	RouteExpiry     int
+	Routing         string
+	ProbeTimer      int
+	OnionRelays     int
+	Mailboxes       []string
+	MailboxTimer    int
+	Seed            int64
+}
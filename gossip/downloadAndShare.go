@@ -3,11 +3,12 @@ package gossip
 import (
 	"encoding/hex"
 	"fmt"
+	"github.com/somecookie/Peerster/blockchain"
 	"github.com/somecookie/Peerster/fileSharing"
 	"github.com/somecookie/Peerster/helper"
 	"github.com/somecookie/Peerster/packet"
+	"io"
 	"math"
-	"net"
 	"os"
 	"time"
 )
@@ -17,221 +18,410 @@ const DOWNLOAD_TIMEOUT = 5
 //startDownload starts the downloading process.
 //The gossiper wants to download the file with metahash *message.Request from
 //*message.Destination and store it with the name *message.File.
+//The metafile is downloaded from *message.Destination, then the chunks are downloaded in parallel
+//from all the peers known to own them.
+//If the file is already partially downloaded, the download is resumed.
 func (g *Gossiper) startDownload(message *packet.Message) {
+	metaHashStr := hex.EncodeToString(*message.Request)
 
-	fmt.Printf("DOWNLOADING metafile of %s from %s\n", *message.File, *message.Destination)
-	dr := &packet.DataRequest{
-		Origin:      g.Name,
-		Destination: *message.Destination,
-		HopLimit:    9,
-		HashValue:   *message.Request,
+	g.Requested.Mutex.RLock()
+	_, downloading := g.Requested.Downloads[metaHashStr]
+	g.Requested.Mutex.RUnlock()
+
+	if downloading {
+		g.resumeDownload(metaHashStr)
+		return
 	}
 
 	newMetadata := &fileSharing.Metadata{
 		Name:     *message.File,
 		MetaHash: *message.Request,
-		Chunks:   make(map[string][]byte),
+		Chunks:   make(map[string]fileSharing.ChunkLocation),
 		Size: 0,
 	}
 
+	g.FilesIndex.Mutex.Lock()
 	g.FilesIndex.Store(newMetadata)
+	g.FilesIndex.Mutex.Unlock()
 
-	g.DSDV.Mutex.RLock()
-	if g.DSDV.Contains(*message.Destination) {
-		metaHashStr := hex.EncodeToString(*message.Request)
-		nextHopAddr := g.DSDV.NextHop[*message.Destination]
-		g.DSDV.Mutex.RUnlock()
-
-		g.sendMessage(&packet.GossipPacket{DataRequest: dr}, nextHopAddr)
+	download := fileSharing.PartialDownloadFactory(*message.File, metaHashStr, *message.Destination)
+	helper.LogError(download.Save())
 
-		g.Requested.Mutex.Lock()
-		if _, ok := g.Requested.State[*message.File]; !ok {
-			g.Requested.State[*message.File] = make(map[string]uint64)
-		}
+	g.Requested.Mutex.Lock()
+	if _, ok := g.Requested.State[*message.File]; !ok {
+		g.Requested.State[*message.File] = make(map[string]uint64)
+	}
 
-		g.Requested.State[*message.File][metaHashStr] = 0
-		g.Requested.Mutex.Unlock()
+	g.Requested.State[*message.File][metaHashStr] = 0
+	g.Requested.Downloads[metaHashStr] = download
+	g.Requested.Mutex.Unlock()
 
+	g.requestMetafile(download)
+}
 
-		go g.waitDownloadACK(nextHopAddr, dr, metaHashStr, *message.File)
+//requestMetafile starts the request of the metafile of the download, unless it is already being requested
+func (g *Gossiper) requestMetafile(download *fileSharing.PartialDownload) {
+	metaHash, err := hex.DecodeString(download.MetaHash)
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
 
-	} else {
-		g.DSDV.Mutex.RUnlock()
+	g.Requested.Mutex.Lock()
+	requested := g.Requested.Metafiles[download.MetaHash]
+	g.Requested.Metafiles[download.MetaHash] = true
+	g.Requested.Mutex.Unlock()
+	if requested {
 		return
 	}
 
+	fmt.Printf("DOWNLOADING metafile of %s from %s\n", download.Name, download.Destination)
+	dr := &packet.DataRequest{
+		Origin:      g.Name,
+		Destination: download.Destination,
+		HopLimit:    9,
+		HashValue:   metaHash,
+	}
+
+	go g.waitDownloadACK(dr, download.MetaHash, download.Name, 0)
+}
+
+//sendDataRequest sends the request to the next hop towards its destination.
+//It returns false if there is no route to the destination.
+func (g *Gossiper) sendDataRequest(dataRequest *packet.DataRequest) bool {
+	nextHop := g.nextHop(dataRequest.Destination, nil)
+	if nextHop == nil {
+		return false
+	}
+	g.sendMessage(&packet.GossipPacket{DataRequest: dataRequest}, nextHop)
+	return true
 }
 
-func (g *Gossiper) waitDownloadACK(nextHopAddr *net.UDPAddr, dataRequest *packet.DataRequest, metaHashStr, name string) {
+//waitDownloadACK sends the request and waits for the corresponding reply.
+//index is the index of the requested chunk, 0 means that the metafile is requested.
+//The request for the metafile is resent every DOWNLOAD_TIMEOUT seconds whereas the request for a chunk
+//is given back to the scheduler so that the chunk is requested from another peer.
+//The waiter stops if its channel is closed.
+func (g *Gossiper) waitDownloadACK(dataRequest *packet.DataRequest, metaHashStr, name string, index uint64) {
 	from := dataRequest.Destination
 	hash := hex.EncodeToString(dataRequest.HashValue)
-	ack := make(chan *packet.DataReply)
+	ack := make(chan *packet.DataReply, 1)
 
 	g.Requested.Mutex.Lock()
 	g.addDownloadACK(from, hash, ack)
-	chunkNbr := g.Requested.State[name][metaHashStr]
 	g.Requested.Mutex.Unlock()
-	ticker := time.NewTicker(DOWNLOAD_TIMEOUT * time.Second)
+
+	if index == 0 {
+		defer func() {
+			g.Requested.Mutex.Lock()
+			delete(g.Requested.Metafiles, metaHashStr)
+			g.Requested.Mutex.Unlock()
+		}()
+	}
+
+	g.sendDataRequest(dataRequest)
+
+	ticker := g.clock.NewTicker(DOWNLOAD_TIMEOUT * time.Second)
+	defer ticker.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			if chunkNbr == 0{
+		case <-ticker.C():
+			if index == 0 && g.isDownloadActive(metaHashStr) {
 				fmt.Printf("DOWNLOADING metafile of %s from %s\n", name, from)
-			}else{
-				fmt.Printf("DOWNLOADING %s chunk %d from %s\n", name, chunkNbr-1, from)
+				g.sendDataRequest(dataRequest)
+				continue
 			}
-			g.sendMessage(&packet.GossipPacket{DataRequest: dataRequest}, nextHopAddr)
-		case dataReply := <-ack:
-			ticker.Stop()
 
 			g.Requested.Mutex.Lock()
-			g.removeDownloadACK(from, hash)
+			g.removeDownloadACK(from, hash, ack)
+			g.Requested.Mutex.Unlock()
+			if index > 0 {
+				g.chunkFailed(metaHashStr, index)
+			}
+			return
+		case dataReply, ok := <-ack:
+			if !ok {
+				return
+			}
+			g.Requested.Mutex.Lock()
+			g.removeDownloadACK(from, hash, ack)
 			g.Requested.Mutex.Unlock()
-			g.processReply(dataReply, name, metaHashStr)
+			g.processReply(dataReply, name, metaHashStr, index)
 			return
 		}
 	}
 
 }
 
-func (g *Gossiper) processReply(dataReply *packet.DataReply,fileName, metaHashStr string) {
-	if dataReply.Data != nil && len(dataReply.Data) != 0{
+//processReply handles the reply to the request for the piece at the given index (0 being the metafile).
+func (g *Gossiper) processReply(dataReply *packet.DataReply, fileName, metaHashStr string, index uint64) {
+	if dataReply.Data == nil || len(dataReply.Data) == 0 {
+		if index > 0 {
+			g.chunkFailed(metaHashStr, index)
+		}
+		return
+	}
 
-		g.Requested.Mutex.Lock()
-		chunkNbr := g.Requested.GetAndIncrement(fileName, metaHashStr)
+	g.Requested.Mutex.Lock()
+	download, ok := g.Requested.Downloads[metaHashStr]
+	scheduler := g.Requested.Schedulers[metaHashStr]
+	if !ok || (index > 0 && scheduler == nil) {
 		g.Requested.Mutex.Unlock()
+		return
+	}
+	g.Requested.GetAndIncrement(fileName, metaHashStr)
 
-		g.FilesIndex.Mutex.RLock()
-		metadata := g.FilesIndex.Index[metaHashStr]
-		g.FilesIndex.Mutex.RUnlock()
+	if index == 0 {
+		helper.LogError(download.Preallocate(dataReply.Data))
+		helper.LogError(download.Save())
+		g.Requested.Mutex.Unlock()
 
-		if chunkNbr > 0{
-			metadata.LastReceivedChunk = chunkNbr
+		//the download may have been cancelled since Requested.Mutex was released
+		g.FilesIndex.Mutex.Lock()
+		metadata := g.FilesIndex.Index[metaHashStr]
+		if metadata == nil {
+			g.FilesIndex.Mutex.Unlock()
+			return
 		}
+		metadata.Metafile = make([]byte, 0, len(dataReply.Data))
+		metadata.Metafile = append(metadata.Metafile, dataReply.Data...)
+		metadata.NbrChunks = uint64(math.Ceil(float64(len(dataReply.Data))/32.0))
+		metadata.Path = download.PartPath()
+		g.FilesIndex.Mutex.Unlock()
 
-		if chunkNbr == 0 {
-			metadata.Metafile = make([]byte, 0, len(dataReply.Data))
-			metadata.Metafile = append(metadata.Metafile, dataReply.Data...)
-			metadata.NbrChunks = uint64(math.Ceil(float64(len(dataReply.Data))/32.0))
-			dataRequest, nextHopAddr := g.sendNextRequest(metadata, dataReply, chunkNbr+1)
-			if dataRequest != nil && nextHopAddr != nil{
-				go g.waitDownloadACK(nextHopAddr, dataRequest, metaHashStr, metadata.Name)
-			}
+		g.startChunksDownload(metadata, download)
+		return
+	}
 
+	//all the chunks with the same hash are written at once
+	received := scheduler.Received(index)
+	for _, i := range received {
+		helper.LogError(download.WriteChunk(i, dataReply.Data))
+	}
+	g.Requested.Mutex.Unlock()
 
-		} else if chunkNbr < metadata.NbrChunks {
+	g.FilesIndex.Mutex.Lock()
+	metadata := g.FilesIndex.Index[metaHashStr]
+	if metadata == nil {
+		g.FilesIndex.Mutex.Unlock()
+		return
+	}
+	if len(received) > 0 {
+		metadata.Chunks[hex.EncodeToString(dataReply.HashValue)] = fileSharing.ChunkLocation{
+			Index: received[0],
+			Size:  len(dataReply.Data),
+		}
+	}
+	g.FilesIndex.Mutex.Unlock()
 
-			metadata.Chunks[hex.EncodeToString(dataReply.HashValue)] = make([]byte, 0, len(dataReply.Data))
-			metadata.Chunks[hex.EncodeToString(dataReply.HashValue)] = append(metadata.Chunks[hex.EncodeToString(dataReply.HashValue)], dataReply.Data...)
-			dataRequest, nextHopAddr := g.sendNextRequest(metadata, dataReply, chunkNbr+1)
+	if scheduler.Done() {
+		g.Requested.Mutex.Lock()
+		_, last := g.Requested.Schedulers[metaHashStr]
+		delete(g.Requested.Schedulers, metaHashStr)
+		delete(g.Requested.Downloads, metaHashStr)
+		g.Requested.Mutex.Unlock()
 
-			if dataRequest != nil && nextHopAddr != nil{
-				go g.waitDownloadACK(nextHopAddr, dataRequest, metaHashStr, metadata.Name)
+		if last {
+			g.reconstructFile(metadata, download)
+		}
+	} else {
+		g.scheduleChunks(metadata, scheduler)
+	}
+}
+
+//startChunksDownload creates the scheduler of the missing chunks of the file once its metafile is known.
+//The owners of each chunk are taken from the matches of the last search and from the owners recorded
+//in the state of the download. The chunks without known owner are requested from the peer who sent the metafile.
+func (g *Gossiper) startChunksDownload(metadata *fileSharing.Metadata, download *fileSharing.PartialDownload) {
+	metaHashStr := hex.EncodeToString(metadata.MetaHash)
+	owners := g.Matches.GetChunkMap(metadata.Name, metaHashStr)
+
+	g.Requested.Mutex.Lock()
+	hashes := make(map[uint64]string)
+	for i := uint64(1); i <= metadata.NbrChunks; i++ {
+		for _, owner := range download.Owners[i] {
+			if !isOwner(owner, owners[i]) {
+				owners[i] = append(owners[i], owner)
 			}
-		} else {
-			metadata.Chunks[hex.EncodeToString(dataReply.HashValue)] = make([]byte, 0, len(dataReply.Data))
-			metadata.Chunks[hex.EncodeToString(dataReply.HashValue)] = append(metadata.Chunks[hex.EncodeToString(dataReply.HashValue)], dataReply.Data...)
-			g.reconstructFile(metadata)
 		}
 
+		if len(owners[i]) == 0 {
+			owners[i] = []string{download.Destination}
+		}
+
+		if !download.HasChunk(i) {
+			hashes[i] = hex.EncodeToString(metadata.ChunkHash(i))
+		}
+	}
+	download.Owners = owners
+	helper.LogError(download.Save())
+
+	scheduler := fileSharing.ChunkSchedulerFactory(g.Requested.Window, hashes, owners)
+	g.Requested.Schedulers[metaHashStr] = scheduler
+	if scheduler.Done() {
+		delete(g.Requested.Schedulers, metaHashStr)
+		delete(g.Requested.Downloads, metaHashStr)
 	}
+	g.Requested.Mutex.Unlock()
 
+	if scheduler.Done() {
+		g.reconstructFile(metadata, download)
+		return
+	}
+	g.scheduleChunks(metadata, scheduler)
 }
 
-func (g *Gossiper) sendNextRequest(metadata *fileSharing.Metadata, dataReply *packet.DataReply, nextChunk uint64) (*packet.DataRequest, *net.UDPAddr) {
-
-	var dest string
+//scheduleChunks requests chunks until the window of the scheduler is full.
+//Nothing is requested while the download is paused.
+func (g *Gossiper) scheduleChunks(metadata *fileSharing.Metadata, scheduler *fileSharing.ChunkScheduler) {
+	metaHashStr := hex.EncodeToString(metadata.MetaHash)
+	for g.isDownloadActive(metaHashStr) {
+		index, owner, ok := scheduler.Next()
+		if !ok {
+			return
+		}
 
-	if owners := g.Matches.GetOwners(metadata.Name, hex.EncodeToString(metadata.MetaHash), nextChunk); owners != nil{
-		dest = owners[0]
-		fmt.Printf("DOWNLOADING %s chunk %d from %s\n", metadata.Name, nextChunk, dest)
-	}else{
-		return nil,nil
+		fmt.Printf("DOWNLOADING %s chunk %d from %s\n", metadata.Name, index, owner)
+		dataRequest := &packet.DataRequest{
+			Origin:      g.Name,
+			Destination: owner,
+			HopLimit:    9,
+			HashValue:   metadata.ChunkHash(index),
+		}
+		go g.waitDownloadACK(dataRequest, metaHashStr, metadata.Name, index)
 	}
+}
 
-	nextHashValue := metadata.Metafile[(nextChunk-1)*32 : nextChunk*32]
-	dataRequest := &packet.DataRequest{
-		Origin:      g.Name,
-		Destination: dest,
-		HopLimit:    9,
-		HashValue:   nextHashValue,
+//chunkFailed gives the chunk back to the scheduler after a timeout or an empty reply,
+//so that it is requested from another owner.
+func (g *Gossiper) chunkFailed(metaHashStr string, index uint64) {
+	g.Requested.Mutex.RLock()
+	scheduler := g.Requested.Schedulers[metaHashStr]
+	g.Requested.Mutex.RUnlock()
+
+	g.FilesIndex.Mutex.RLock()
+	metadata := g.FilesIndex.Index[metaHashStr]
+	g.FilesIndex.Mutex.RUnlock()
+
+	if scheduler != nil && metadata != nil {
+		scheduler.TimedOut(index)
+		g.scheduleChunks(metadata, scheduler)
 	}
-	g.DSDV.Mutex.RLock()
-	nextHopAddr := g.DSDV.NextHop[dataRequest.Destination]
-	g.DSDV.Mutex.RUnlock()
-	g.sendMessage(&packet.GossipPacket{DataRequest: dataRequest}, nextHopAddr)
-	return dataRequest, nextHopAddr
 }
 
-func (g *Gossiper) reconstructFile(metadata *fileSharing.Metadata) {
+//reconstructFile finalizes a completed download: the preallocated file is truncated to the size of the file
+//and moved to fileSharing.PATH_DOWNLOADS. The file is also made available in fileSharing.PATH_SHAREDFILES.
+func (g *Gossiper) reconstructFile(metadata *fileSharing.Metadata, download *fileSharing.PartialDownload) {
 
-	fsf, err := os.Create(fileSharing.PATH_SHAREDFILES+metadata.Name)
-	defer fsf.Close()
-	if err != nil{
+	path, size, err := download.Finalize()
+	if err != nil {
 		helper.LogError(err)
 		return
 	}
 
-	fd, err := os.Create(fileSharing.PATH_DOWNLOADS+metadata.Name)
-	defer fd.Close()
-	if err != nil{
+	g.FilesIndex.Mutex.Lock()
+	metadata.Path = path
+	metadata.Size = size
+	g.FilesIndex.Mutex.Unlock()
+
+	if err := linkOrCopy(path, fileSharing.PATH_SHAREDFILES+metadata.Name); err != nil {
 		helper.LogError(err)
-		return
 	}
 
-	for i := uint64(0); i < metadata.NbrChunks; i++{
-		hashChunk := metadata.Metafile[i*32:(i+1)*32]
-		chunk := metadata.Chunks[hex.EncodeToString(hashChunk)]
-		metadata.Size += uint32(len(chunk))
+	fmt.Printf("RECONSTRUCTED file %s\n", metadata.Name)
+}
 
-		if _, err:= fsf.Write(chunk); err != nil{
-			helper.LogError(err)
-			return
-		}
-		if _, err:= fd.Write(chunk); err != nil{
-			helper.LogError(err)
-			return
-		}
+//linkOrCopy makes the file at src available at dst, with a hard link if possible and by streaming a copy otherwise.
+func linkOrCopy(src, dst string) error {
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
 
+	in, err := os.Open(src)
+	if err != nil {
+		return err
 	}
+	defer in.Close()
 
-	fmt.Printf("RECONSTRUCTED file %s\n", metadata.Name)
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
 }
 
+//addDownloadACK registers the channel ack of a waiter of the reply to hash from the peer from.
+//Several requests may wait for the same hash from the same peer, each one with its own channel.
 func (g *Gossiper) addDownloadACK(from, hash string, ack chan *packet.DataReply) {
 	if _, ok := g.Requested.ACKs[from]; !ok {
-		g.Requested.ACKs[from] = make(map[string]chan *packet.DataReply)
+		g.Requested.ACKs[from] = make(map[string][]chan *packet.DataReply)
 	}
-	g.Requested.ACKs[from][hash] = ack
+	g.Requested.ACKs[from][hash] = append(g.Requested.ACKs[from][hash], ack)
 
 }
 
-func (g *Gossiper) removeDownloadACK(from, hash string) {
-	if _, ok := g.Requested.ACKs[from]; ok {
-		if c, ok := g.Requested.ACKs[from][hash]; ok {
+//removeDownloadACK removes and closes the channel ack of the waiter of the reply to hash from the peer from,
+//leaving the other waiters of the same hash untouched
+func (g *Gossiper) removeDownloadACK(from, hash string, ack chan *packet.DataReply) {
+	acks := g.Requested.ACKs[from][hash]
+	for i, c := range acks {
+		if c == ack {
 			close(c)
-			delete(g.Requested.ACKs[from], hash)
+			acks = append(acks[:i:i], acks[i+1:]...)
+			break
 		}
 	}
+
+	if len(acks) == 0 {
+		delete(g.Requested.ACKs[from], hash)
+	} else {
+		g.Requested.ACKs[from][hash] = acks
+	}
 }
 
 //IndexFile indexes the file at _SharedFiles called.
 //It creates the metadata of the file and stores it in the sync.Map called FilesIndex.
+//A file whose name is already claimed in the registry for another file is rejected.
 func (g *Gossiper) IndexFile(fileName string) {
 	metadata, err := fileSharing.MetadataFromIndexing(fileName)
 	helper.LogError(err)
 	if err == nil {
+		if err := g.Registry.Check(blockchain.TxPublish{Name: metadata.Name, MetafileHash: metadata.MetaHash}); err != nil {
+			helper.LogError(err)
+			return
+		}
+
 		g.FilesIndex.Mutex.Lock()
 		g.FilesIndex.Store(metadata)
 		g.FilesIndex.Mutex.Unlock()
 
+		//the registered file is shared but it is not published again
+		if _, claimed := g.Registry.Lookup(metadata.Name); claimed {
+			return
+		}
+
+		if err := g.Blockchain.CheckTransaction(g.newPublish(metadata)); err != nil {
+			helper.LogError(err)
+			return
+		}
+
+		if g.miners > 0 {
+			g.publishTransaction(metadata)
+			return
+		}
+
 		g.TLCMajority.Lock()
 		defer g.TLCMajority.Unlock()
 
 		if g.ackAll{
 			g.BroadcastNewFile(metadata)
-		}else if !g.TLCMajority.ReicvCommand{
+		}else if !g.TLCMajority.ReicvCommand && g.isMember(){
 			g.TLCMajority.ReicvCommand = true
+			g.TLCMajority.Proposal = metadata
 			g.BroadcastNewFile(metadata)
 			g.TryNextRound()
 		}else{
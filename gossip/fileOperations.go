@@ -0,0 +1,94 @@
+package gossip
+
+import (
+	"fmt"
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/packet"
+	"time"
+)
+
+//handleFileCommand executes the unpublish, rename or transfer command of the client on the file message.File
+func (g *Gossiper) handleFileCommand(message *packet.Message) {
+	switch {
+	case message.Unpublish:
+		g.ChangeFile(*message.File, blockchain.TX_UNPUBLISH, "")
+	case message.Rename != nil:
+		g.ChangeFile(*message.File, blockchain.TX_RENAME, *message.Rename)
+	case message.Transfer != nil:
+		g.ChangeFile(*message.File, blockchain.TX_TRANSFER, *message.Transfer)
+	}
+}
+
+//ChangeFile unpublishes the file published under name, renames it to target or transfers it to the gossiper called target.
+//The transaction is signed by the gossiper, which must own the file, and gossiped to its peers.
+//With TLC, it is gossiped again every stubbornTimeout seconds until it is in the chain or it can no longer be applied.
+func (g *Gossiper) ChangeFile(name string, txType blockchain.TxType, target string) {
+	if g.ackAll {
+		helper.LogError(&helper.IllegalArgumentError{
+			ErrorMessage: "the files only change when they are published with TLC majorities or by mining",
+			Where:        "fileOperations.go",
+		})
+		return
+	}
+
+	record, ok := g.Blockchain.Lookup(name)
+	if !ok || record.Unpublished {
+		helper.LogError(&helper.IllegalArgumentError{
+			ErrorMessage: "the file " + name + " is not published in the chain",
+			Where:        "fileOperations.go",
+		})
+		return
+	}
+
+	tx := blockchain.TxPublish{
+		Name:         name,
+		Size:         record.Size,
+		MetafileHash: record.MetafileHash,
+		Type:         txType,
+		Previous:     record.Last,
+		Owner:        g.Name,
+	}
+	switch txType {
+	case blockchain.TX_RENAME:
+		tx.NewName = target
+	case blockchain.TX_TRANSFER:
+		key, ok := g.KeyStore.Get(target)
+		if !ok {
+			helper.LogError(&helper.IllegalArgumentError{
+				ErrorMessage: "the key of " + target + " is unknown",
+				Where:        "fileOperations.go",
+			})
+			return
+		}
+		tx.NewOwner = target
+		tx.NewOwnerKey = key
+	}
+
+	if err := tx.Sign(g.Identity.SigningKey); err != nil {
+		helper.LogError(err)
+		return
+	}
+	if err := g.checkTransaction(tx); err != nil {
+		helper.LogError(err)
+		return
+	}
+	fmt.Printf("FILE REQUEST %s\n", tx.Label())
+
+	if g.miners > 0 {
+		g.submitTransaction(tx)
+		return
+	}
+
+	ticker := g.clock.NewTicker(time.Duration(g.stubbornTimeout) * time.Second)
+	defer ticker.Stop()
+
+	for g.checkTransaction(tx) == nil {
+		g.addChange(tx)
+		g.broadcast(&packet.GossipPacket{TxPublish: &packet.TxPublishMessage{
+			Transaction: tx,
+			HopLimit:    packet.TX_HOP_LIMIT,
+		}}, nil)
+		<-ticker.C()
+	}
+}
@@ -1,10 +1,17 @@
 package gossip
 
 import (
+	"fmt"
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/clock"
 	"github.com/somecookie/Peerster/fileSharing"
 	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/identity"
 	"github.com/somecookie/Peerster/packet"
 	"github.com/somecookie/Peerster/routing"
+	"github.com/somecookie/Peerster/storage"
+	"github.com/somecookie/Peerster/transport"
+	"math/rand"
 	"net"
 	"strings"
 	"sync"
@@ -17,14 +24,24 @@ type Gossiper struct {
 	Name            string
 	Peers           PeersSet
 	simple          bool
-	connClient      *net.UDPConn
-	connGossip      *net.UDPConn
+	clientTransport transport.Transport
+	gossipTransport transport.Transport
 	State           *GossiperState
 	pendingACK      PendingACK
 	counter         uint32
 	antiEntropy     time.Duration
 	rtimer          time.Duration
 	DSDV            *routing.DSDV
+	LinkState       *routing.LinkState
+	Router          routing.Router
+	Health          *routing.Health
+	probeTimer      time.Duration
+	Onions          *OnionState
+	onionRelays     int
+	Mailbox         *Mailbox
+	mailboxTimer    time.Duration
+	privateCounter  uint32
+	linkStateSeq    uint32
 	FilesIndex      *fileSharing.FilesIndex
 	Requested       *fileSharing.DownloadState
 	DSR             *packet.DuplicateSearchRequest
@@ -32,14 +49,28 @@ type Gossiper struct {
 	Matches         *Matches
 	hoplimit        int
 	TLCMajority     *TLCMajority
+	tlcMutex        sync.Mutex
 	stubbornTimeout int
 	ackAll          bool
+	Identity        *identity.Identity
+	KeyStore        *identity.KeyStore
+	clock           clock.Clock
+	Blockchain      *blockchain.Chain
+	Registry        *blockchain.Registry
+	Miner           *blockchain.Miner
+	LedgerLog       *LedgerLog
+	miners          int
+	randMutex       sync.Mutex
+	random          *rand.Rand
 }
 
-//GossiperFactory creates a Gossiper from the parsed flags of main.go.
-func GossiperFactory(gossipAddr, uiPort, name string, peers []*net.UDPAddr, simple, ackAll bool, antiEntropy, rtimer, hoplimit, N, stubbornTimeout int) (*Gossiper, error) {
+//GossiperFactory creates a Gossiper with the parameters of config.
+//gossipTransport is the transport used to communicate with the other gossipers. It should listen at config.GossipAddr.
+//The peers are resolved with gossipTransport.
+//clk is the clock used for all the timers of the gossiper.
+func GossiperFactory(config Config, gossipTransport transport.Transport, clk clock.Clock) (*Gossiper, error) {
 
-	ipPort := strings.Split(gossipAddr, ":")
+	ipPort := strings.Split(config.GossipAddr, ":")
 	if len(ipPort) != 2 {
 		helper.HandleCrashingErr(&helper.IllegalArgumentError{
 			ErrorMessage: "gossipAddress has the wrong format",
@@ -47,115 +78,272 @@ func GossiperFactory(gossipAddr, uiPort, name string, peers []*net.UDPAddr, simp
 		})
 	}
 
-	udpAddrClient, err := net.ResolveUDPAddr("udp4", "127.0.0.1:"+uiPort)
+	var clientTransport transport.Transport
+	if config.UIPort != "" {
+		udpTransport, err := transport.UDPTransportFactory("127.0.0.1:"+config.UIPort, clk)
+		if err != nil {
+			return nil, err
+		}
+		clientTransport = udpTransport
+	}
+
+	if config.OnionRelays >= ONION_MAX_HOPS {
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: fmt.Sprintf("at most %d onion relays", ONION_MAX_HOPS-1),
+			Where:        "gossiper.go",
+		}
+	}
+
+	id, err := identity.LoadOrGenerate(config.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	keyStore, err := identity.KeyStoreFactory(config.Name)
 	if err != nil {
 		return nil, err
 	}
 
-	udpAddrGossip, err := net.ResolveUDPAddr("udp4", gossipAddr)
+	if !keyStore.Learn(config.Name, id.PublicKey()) {
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: "the keystore contains another key for " + config.Name,
+			Where:        "gossiper.go",
+		}
+	}
+	keyStore.SetEncryptionKey(config.Name, id.EncryptionPublicKey())
+
+	founders, err := parseFounders(config, id)
 	if err != nil {
 		return nil, err
 	}
+	for _, founder := range founders {
+		if founder.PublicKey != nil && !keyStore.Learn(founder.Name, founder.PublicKey) {
+			return nil, &helper.IllegalArgumentError{
+				ErrorMessage: "the keystore contains another key for the founder " + founder.Name,
+				Where:        "gossiper.go",
+			}
+		}
+	}
 
-	udpConnGossip, err := net.ListenUDP("udp4", udpAddrGossip)
+	backend, err := storage.BackendFactory(config.StorageKind, config.Name)
 	if err != nil {
 		return nil, err
 	}
 
-	udpConnClient, err := net.ListenUDP("udp4", udpAddrClient)
+	state := GossiperStateFactory(backend)
+	if err := state.Restore(); err != nil {
+		return nil, err
+	}
+
+	mailbox := MailboxFactory(config.Mailboxes, backend)
+	if err := mailbox.Restore(); err != nil {
+		return nil, err
+	}
+
+	ledgerBackend, err := storage.LedgerBackendFactory(config.StorageKind, config.Name)
 	if err != nil {
 		return nil, err
 	}
 
+	routeMaxAge := time.Duration(config.RTimer*config.RouteExpiry) * time.Second
+	dsdv := routing.DSDVFactory(routeMaxAge)
+	var linkState *routing.LinkState
+	var router routing.Router = dsdv
+	switch config.Routing {
+	case routing.ROUTING_DSDV:
+	case routing.ROUTING_LINK_STATE:
+		if config.RTimer <= 0 {
+			return nil, &helper.IllegalArgumentError{
+				ErrorMessage: "link-state routing needs route rumors (rtimer > 0)",
+				Where:        "gossiper.go",
+			}
+		}
+		linkState = routing.LinkStateFactory(config.Name, config.GossipAddr, routeMaxAge)
+		router = linkState
+	default:
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: "unknown routing scheme " + config.Routing,
+			Where:        "gossiper.go",
+		}
+	}
+
 	pending := PendingACK{
 		ACKS:  make(map[string]map[ACK]chan *packet.StatusPacket),
 		Mutex: sync.RWMutex{},
 	}
 
 	peersSet := PeersSet{
-		Set:   make(map[string]*net.UDPAddr),
+		Set:   make(map[string]net.Addr),
 		Mutex: sync.RWMutex{},
 	}
 
-	for _, addr := range peers {
-		if addr.String() != gossipAddr {
+	for _, peer := range config.Peers {
+		addr, err := gossipTransport.Resolve(peer)
+		helper.LogError(err)
+		if err == nil && addr.String() != config.GossipAddr {
 			peersSet.Add(addr)
 		}
 	}
 
-	return &Gossiper{
-		GossipAddr:  gossipAddr,
-		Name:        name,
-		Peers:       peersSet,
-		simple:      simple,
-		connClient:  udpConnClient,
-		connGossip:  udpConnGossip,
-		State:       GossiperStateFactory(),
-		pendingACK:  pending,
-		counter:     0,
-		antiEntropy: time.Duration(antiEntropy),
-		rtimer:      time.Duration(rtimer),
-		DSDV:        routing.DSDVFactory(),
-		FilesIndex:  fileSharing.FilesIndexFactory(),
-		Requested:   fileSharing.DownloadStateFactory(),
-		DSR:         packet.DSRFactory(),
+	g := &Gossiper{
+		GossipAddr:      config.GossipAddr,
+		Name:            config.Name,
+		Peers:           peersSet,
+		simple:          config.Simple,
+		clientTransport: clientTransport,
+		gossipTransport: gossipTransport,
+		State:           state,
+		pendingACK:      pending,
+		counter:         state.LastID(config.Name),
+		antiEntropy:     time.Duration(config.AntiEntropy),
+		rtimer:          time.Duration(config.RTimer),
+		DSDV:            dsdv,
+		LinkState:       linkState,
+		Router:          router,
+		linkStateSeq:    linkStateSeqStart(clk),
+		Health:          routing.HealthFactory(),
+		probeTimer:      time.Duration(config.ProbeTimer),
+		Onions:          OnionStateFactory(),
+		onionRelays:     config.OnionRelays,
+		Mailbox:         mailbox,
+		mailboxTimer:    time.Duration(config.MailboxTimer),
+		privateCounter:  state.LastPrivateID(config.Name),
+		FilesIndex:      fileSharing.FilesIndexFactory(),
+		Requested:       fileSharing.DownloadStateFactory(config.DownloadWindow),
+		DSR:             packet.DSRFactory(),
 		fullMatches: &FullMatchCounter{
 			Mutex: sync.Mutex{},
 			n:     0,
 		},
 		Matches:         MatchesFactory(),
-		hoplimit:        hoplimit,
-		TLCMajority:     TLCMajorityFactory(N),
-		stubbornTimeout: stubbornTimeout,
-		ackAll:           ackAll,
-	}, nil
+		hoplimit:        config.HopLimit,
+		TLCMajority:     TLCMajorityFactory(founders, config.FaultThreshold),
+		tlcMutex:        sync.Mutex{},
+		stubbornTimeout: config.StubbornTimeout,
+		ackAll:          config.AckAll,
+		Identity:        id,
+		KeyStore:        keyStore,
+		clock:           clk,
+		Blockchain:      blockchain.ChainFactory(),
+		Registry:        blockchain.RegistryFactory(),
+		Miner:           blockchain.MinerFactory(config.Difficulty),
+		LedgerLog:       LedgerLogFactory(ledgerBackend),
+		miners:          config.Miners,
+		randMutex:       sync.Mutex{},
+		random:          rand.New(rand.NewSource(config.Seed)),
+	}
+
+	if err := g.restoreLedger(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+//randomInt draws a random integer in [0, n) from the random source of the gossiper
+func (g *Gossiper) randomInt(n int) int {
+	g.randMutex.Lock()
+	defer g.randMutex.Unlock()
+	return g.random.Intn(n)
+}
+
+//randomPerm draws a random permutation of [0, n) from the random source of the gossiper
+func (g *Gossiper) randomPerm(n int) []int {
+	g.randMutex.Lock()
+	defer g.randMutex.Unlock()
+	return g.random.Perm(n)
+}
+
+//randomFitness draws the fitness of a new proposal from the random source of the gossiper
+func (g *Gossiper) randomFitness() float32 {
+	g.randMutex.Lock()
+	defer g.randMutex.Unlock()
+	return g.random.Float32()
 }
 
 //sendMessage sends the GossipPacket created by the gossiper based on the message received from the client
 //GossipPacket is the packet we want to send
 //dest is the destination address
-func (g *Gossiper) sendMessage(gossipPacket *packet.GossipPacket, dest *net.UDPAddr) {
+func (g *Gossiper) sendMessage(gossipPacket *packet.GossipPacket, dest net.Addr) {
 	packetBytes, err := packet.GetPacketBytes(gossipPacket)
 	helper.LogError(err)
 	if err == nil {
-		_, err := g.connGossip.WriteToUDP(packetBytes, dest)
-		helper.LogError(err)
+		helper.LogError(g.gossipTransport.Send(packetBytes, dest))
 	}
 
 }
 
+//ResolvePeer converts the address of a peer (ip:port) into an address of the transport of g
+func (g *Gossiper) ResolvePeer(address string) (net.Addr, error) {
+	return g.gossipTransport.Resolve(address)
+}
+
 func (g *Gossiper) ClientListener() {
+	if g.clientTransport == nil {
+		return
+	}
 
-	defer g.connClient.Close()
+	defer g.clientTransport.Close()
 
-	buffer := make([]byte, 10000)
 	for {
-		n, _, err := g.connClient.ReadFromUDP(buffer)
+		messageBytes, clientAddr, err := g.clientTransport.Receive()
+		if _, closed := err.(*transport.ClosedError); closed {
+			return
+		}
 		helper.LogError(err)
 
 		if err == nil {
-			message, err := packet.GetMessage(buffer, n)
-			if err == nil {
+			message, err := packet.GetMessage(messageBytes, len(messageBytes))
+			if err == nil && message.Download != nil {
+				go g.replyDownloadCommand(message, clientAddr)
+			} else if err == nil && message.Registry != nil {
+				go g.replyRegistryCommand(*message.Registry, clientAddr)
+			} else if err == nil && message.Membership != nil {
+				go g.ChangeMembership(*message.Membership == "join")
+			} else if err == nil && message.File != nil && (message.Unpublish || message.Rename != nil || message.Transfer != nil) {
+				go g.handleFileCommand(message)
+			} else if err == nil {
 				g.HandleMessage(message)
 			}
 		}
 	}
 }
 
+//replyDownloadCommand executes the download command of the client and sends the result back to it
+func (g *Gossiper) replyDownloadCommand(message *packet.Message, clientAddr net.Addr) {
+	fileName := ""
+	if message.File != nil {
+		fileName = *message.File
+	}
+
+	result := g.HandleDownloadCommand(*message.Download, fileName)
+	fmt.Println(result)
+
+	helper.LogError(g.clientTransport.Send([]byte(result), clientAddr))
+}
+
+//GossiperListener handles the packets received from the other gossipers until the transport is closed
 func (g *Gossiper) GossiperListener() {
-	defer g.connGossip.Close()
+	defer g.gossipTransport.Close()
 
-	buffer := make([]byte, 10000)
 	for {
-		n, peerAddr, err := g.connGossip.ReadFromUDP(buffer)
+		packetBytes, peerAddr, err := g.gossipTransport.Receive()
+		if _, closed := err.(*transport.ClosedError); closed {
+			return
+		}
 		if err == nil {
-			receivedPacket, err := packet.GetGossipPacket(buffer, n)
+			receivedPacket, err := packet.GetGossipPacket(packetBytes, len(packetBytes))
 
 			if err == nil {
 				if peerAddr.String() != g.GossipAddr {
 					g.Peers.Mutex.Lock()
+					known := g.Peers.Contains(peerAddr)
 					g.Peers.Add(peerAddr)
 					g.Peers.Mutex.Unlock()
+
+					//a new link is advertised right away instead of at the next route rumor
+					if !known {
+						go g.advertiseLinks()
+					}
 				}
 
 				g.GossipPacketHandler(receivedPacket, peerAddr)
@@ -180,7 +368,7 @@ func (g *Gossiper) GetNextID(origin string) uint32 {
 //flipped coin tells if the rumor mongering was triggered by a coin flip
 //pasAddr is the address of the node that sent us the message
 //dstAddr is used when we want to send the rumor message to a given address
-func (g *Gossiper) Rumormongering(message *packet.GossipPacket, flippedCoin bool, pastAddr *net.UDPAddr, dstAddr *net.UDPAddr) {
+func (g *Gossiper) Rumormongering(message *packet.GossipPacket, flippedCoin bool, pastAddr net.Addr, dstAddr net.Addr) {
 
 	g.Peers.Mutex.RLock()
 	nbrPeers := len(g.Peers.Set)
@@ -202,14 +390,14 @@ func (g *Gossiper) Rumormongering(message *packet.GossipPacket, flippedCoin bool
 	go g.WaitForAck(message, peerAddr)
 }
 
-func (g *Gossiper) SelectNewPeer(dstAddr *net.UDPAddr, pastAddr *net.UDPAddr) *net.UDPAddr {
+func (g *Gossiper) SelectNewPeer(dstAddr net.Addr, pastAddr net.Addr) net.Addr {
 	peerAddr := dstAddr
 	if dstAddr == nil {
-		peerAddr = g.Peers.Random()
+		peerAddr = g.Peers.Random(g.randomInt)
 
 		if peerAddr == pastAddr {
 			for peerAddr == pastAddr {
-				peerAddr = g.Peers.Random()
+				peerAddr = g.Peers.Random(g.randomInt)
 			}
 		}
 	}
@@ -218,12 +406,12 @@ func (g *Gossiper) SelectNewPeer(dstAddr *net.UDPAddr, pastAddr *net.UDPAddr) *n
 
 //AntiEntropyRoutine sends the anti-entropy Status Packet every g.antiEntropy seconds
 func (g *Gossiper) AntiEntropyRoutine() {
-	ticker := time.NewTicker(g.antiEntropy * time.Second)
+	ticker := g.clock.NewTicker(g.antiEntropy * time.Second)
 	defer ticker.Stop()
 	for {
 		select {
-		case <-ticker.C:
-			peerAddr := g.Peers.Random()
+		case <-ticker.C():
+			peerAddr := g.Peers.Random(g.randomInt)
 
 			if peerAddr != nil {
 				g.State.Mutex.RLock()
@@ -236,9 +424,12 @@ func (g *Gossiper) AntiEntropyRoutine() {
 }
 
 //RouteRumorRoutine sends the route rumor message every g.rtimer seconds.
-//It starts by sending a route rumor message.
+//It starts by sending a route rumor message. The routes that were not refreshed for too long expire at each interval.
+//With link-state routing, the neighbors of the gossiper are advertised along with each route rumor.
 func (g *Gossiper) RouteRumorRoutine() {
 
+	g.advertiseLinks()
+
 	peers := g.Peers.PeersSetAsList()
 
 	routeRumorMessage := g.createNewRouteRumor()
@@ -247,12 +438,18 @@ func (g *Gossiper) RouteRumorRoutine() {
 		g.Rumormongering(routeRumorMessage, false, nil, peer)
 	}
 
-	ticker := time.NewTicker(g.rtimer * time.Second)
+	ticker := g.clock.NewTicker(g.rtimer * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
+			g.DSDV.Expire(g.clock.Now())
+			if g.LinkState != nil {
+				g.LinkState.Expire(g.clock.Now())
+				g.advertiseLinks()
+			}
+
 			routeRumorMessage := g.createNewRouteRumor()
 
 			g.Rumormongering(routeRumorMessage, false, nil, nil)
@@ -263,15 +460,19 @@ func (g *Gossiper) RouteRumorRoutine() {
 
 //createNewRouteRumor creates a new route rumor message
 func (g *Gossiper) createNewRouteRumor() *packet.GossipPacket {
-	atomic.AddUint32(&g.counter, 1)
+	ID := atomic.AddUint32(&g.counter, 1)
 	routeRumorMessage := &packet.RumorMessage{
-		Origin: g.Name,
-		ID:     g.counter,
-		Text:   "",
+		Origin:        g.Name,
+		ID:            ID,
+		Text:          "",
+		EncryptionKey: g.Identity.EncryptionPublicKey(),
 	}
+	helper.LogError(routeRumorMessage.Sign(g.Identity.SigningKey))
 
 	gp := &packet.GossipPacket{Rumor: routeRumorMessage}
 
+	g.State.Mutex.Lock()
 	g.State.UpdateGossiperState(gp)
+	g.State.Mutex.Unlock()
 	return gp
 }
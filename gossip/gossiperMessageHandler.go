@@ -6,14 +6,16 @@ import (
 	"fmt"
 	"github.com/somecookie/Peerster/helper"
 	"github.com/somecookie/Peerster/packet"
-	"math/rand"
 	"net"
 	"time"
 )
 
-var hasher = sha256.New()
+func (g *Gossiper) GossipPacketHandler(receivedPacket *packet.GossipPacket, from net.Addr) {
+	if !g.VerifyPacket(receivedPacket) {
+		packet.PrintInvalidSignature(receivedPacket, from)
+		return
+	}
 
-func (g *Gossiper) GossipPacketHandler(receivedPacket *packet.GossipPacket, from *net.UDPAddr) {
 	if g.simple {
 		if receivedPacket.Simple != nil {
 			go g.SimpleMessageRoutine(receivedPacket.Simple, from)
@@ -24,40 +26,56 @@ func (g *Gossiper) GossipPacketHandler(receivedPacket *packet.GossipPacket, from
 		} else if receivedPacket.Status != nil {
 			go g.StatusPacketRoutine(receivedPacket.Status, from)
 		} else if receivedPacket.Private != nil {
-			go g.PrivateMessageRoutine(receivedPacket.Private)
+			go g.PrivateMessageRoutine(receivedPacket.Private, from)
 		} else if receivedPacket.DataRequest != nil {
-			go g.DataRequestRoutine(receivedPacket.DataRequest)
+			go g.DataRequestRoutine(receivedPacket.DataRequest, from)
 		} else if receivedPacket.DataReply != nil {
-			go g.DataReplyRoutine(receivedPacket.DataReply)
+			go g.DataReplyRoutine(receivedPacket.DataReply, from)
 		} else if receivedPacket.SearchRequest != nil {
 			g.SearchRequestRoutine(receivedPacket.SearchRequest, from)
 		} else if receivedPacket.SearchReply != nil {
-			g.SearchReplyRoutine(receivedPacket.SearchReply)
+			g.SearchReplyRoutine(receivedPacket.SearchReply, from)
 		}else if receivedPacket.Ack != nil{
-			go g.TLCAckRoutine(receivedPacket.Ack)
+			go g.TLCAckRoutine(receivedPacket.Ack, from)
+		} else if receivedPacket.TxPublish != nil {
+			go g.TxPublishRoutine(receivedPacket.TxPublish, from)
+		} else if receivedPacket.Block != nil {
+			go g.BlockRoutine(receivedPacket.Block, from)
+		} else if receivedPacket.CatchUp != nil {
+			go g.CatchUpRequestRoutine(receivedPacket.CatchUp, from)
+		} else if receivedPacket.Certificates != nil {
+			go g.CatchUpReplyRoutine(receivedPacket.Certificates)
+		} else if receivedPacket.LinkState != nil {
+			go g.LinkStateRoutine(receivedPacket.LinkState, from)
+		} else if receivedPacket.Probe != nil {
+			go g.ProbeMessageRoutine(receivedPacket.Probe, from)
+		} else if receivedPacket.Onion != nil {
+			go g.OnionMessageRoutine(receivedPacket.Onion, from)
+		} else if receivedPacket.Delivery != nil {
+			go g.DeliveryAckRoutine(receivedPacket.Delivery, from)
+		} else if receivedPacket.Deposit != nil {
+			go g.MailboxDepositRoutine(receivedPacket.Deposit, from)
 		}
 	}
 
 }
 
-//DataReplyRoutine handles the incoming dataReply.
-func (g *Gossiper) DataReplyRoutine(dataReply *packet.DataReply) {
+//DataReplyRoutine handles the incoming dataReply coming from the peer at address from.
+func (g *Gossiper) DataReplyRoutine(dataReply *packet.DataReply, from net.Addr) {
 	if dataReply.Destination == g.Name {
 
-		hasher.Reset()
-		_, err := hasher.Write(dataReply.Data)
-
-		if err != nil {
-			helper.LogError(err)
-			return
-		}
-		hash := hasher.Sum(nil)
+		//the replies are handled concurrently, so each one is hashed on its own
+		hash := sha256.Sum256(dataReply.Data)
 		receivedHashString := hex.EncodeToString(dataReply.HashValue)
 		g.Requested.Mutex.RLock()
-		if hex.EncodeToString(hash) == receivedHashString || len(dataReply.Data) == 0 || dataReply.Data == nil {
+		if hex.EncodeToString(hash[:]) == receivedHashString || len(dataReply.Data) == 0 || dataReply.Data == nil {
 			if origins, ok := g.Requested.ACKs[dataReply.Origin]; ok {
-				if c, ok := origins[receivedHashString]; ok {
-					c <- dataReply
+				//every request of the same hash from the same peer gets the reply
+				for _, c := range origins[receivedHashString] {
+					select {
+					case c <- dataReply:
+					default:
+					}
 				}
 			}
 		}
@@ -65,19 +83,17 @@ func (g *Gossiper) DataReplyRoutine(dataReply *packet.DataReply) {
 	} else if dataReply.HopLimit > 0 {
 		dataReply.HopLimit -= 1
 
-		g.DSDV.Mutex.RLock()
-		if g.DSDV.Contains(dataReply.Destination) {
-			g.sendMessage(&packet.GossipPacket{DataReply: dataReply}, g.DSDV.NextHop[dataReply.Destination])
+		if nextHop := g.nextHop(dataReply.Destination, from); nextHop != nil {
+			g.sendMessage(&packet.GossipPacket{DataReply: dataReply}, nextHop)
 		}
-		g.DSDV.Mutex.RUnlock()
 	}
 
 }
 
-//DataRequestRoutine handles the incoming request.
+//DataRequestRoutine handles the incoming request coming from the peer at address from.
 //It either discards the packet when the hop-limit is 0,
 //or if the destination is the gossiper, process the packet.
-func (g *Gossiper) DataRequestRoutine(dataRequest *packet.DataRequest) {
+func (g *Gossiper) DataRequestRoutine(dataRequest *packet.DataRequest, from net.Addr) {
 	if dataRequest.Destination == g.Name {
 		g.FilesIndex.Mutex.RLock()
 		chunk := g.FilesIndex.FindChunkFromHash(hex.EncodeToString(dataRequest.HashValue))
@@ -90,27 +106,23 @@ func (g *Gossiper) DataRequestRoutine(dataRequest *packet.DataRequest) {
 			HashValue:   dataRequest.HashValue,
 			Data:        chunk,}
 
-		g.DSDV.Mutex.RLock()
-		if g.DSDV.Contains(dataReply.Destination) {
-			g.sendMessage(&packet.GossipPacket{DataReply: dataReply}, g.DSDV.NextHop[dataReply.Destination])
+		if nextHop := g.nextHop(dataReply.Destination, nil); nextHop != nil {
+			g.sendMessage(&packet.GossipPacket{DataReply: dataReply}, nextHop)
 		}
-		g.DSDV.Mutex.RUnlock()
 
 	} else if dataRequest.HopLimit > 0 {
 		dataRequest.HopLimit -= 1
 
-		g.DSDV.Mutex.RLock()
-		if g.DSDV.Contains(dataRequest.Destination) {
-			g.sendMessage(&packet.GossipPacket{DataRequest: dataRequest}, g.DSDV.NextHop[dataRequest.Destination])
+		if nextHop := g.nextHop(dataRequest.Destination, from); nextHop != nil {
+			g.sendMessage(&packet.GossipPacket{DataRequest: dataRequest}, nextHop)
 		}
-		g.DSDV.Mutex.RUnlock()
 	}
 }
 
 //SimpleMessageRoutine handle the GossipPackets of type SimpleMessage
 //It first prints the message and g's Peers.
 //Finally it forwards message to all g's Peers (except peerAddr)
-func (g *Gossiper) SimpleMessageRoutine(message *packet.SimpleMessage, peerAddr *net.UDPAddr) {
+func (g *Gossiper) SimpleMessageRoutine(message *packet.SimpleMessage, peerAddr net.Addr) {
 	g.Peers.Mutex.RLock()
 	defer g.Peers.Mutex.RUnlock()
 
@@ -123,12 +135,7 @@ func (g *Gossiper) SimpleMessageRoutine(message *packet.SimpleMessage, peerAddr
 		if addr == nil || addrStr == peerAddr.String() {
 			continue
 		}
-		packetBytes, err := packet.GetPacketBytes(&packet.GossipPacket{Simple: message})
-
-		if err == nil {
-			_, err := g.connGossip.WriteToUDP(packetBytes, addr)
-			helper.LogError(err)
-		}
+		g.sendMessage(&packet.GossipPacket{Simple: message}, addr)
 	}
 
 }
@@ -136,7 +143,7 @@ func (g *Gossiper) SimpleMessageRoutine(message *packet.SimpleMessage, peerAddr
 //RumorMessageRoutine handles the RumorMessage.
 //It first prints the message and g's Peers. Then it sends an ack to the peer that send the rumor.
 //Finally, if it is a new Rumor g starts Rumormongering
-func (g *Gossiper) RumorMessageRoutine(gossipPacket *packet.GossipPacket, peerAddr *net.UDPAddr) {
+func (g *Gossiper) RumorMessageRoutine(gossipPacket *packet.GossipPacket, peerAddr net.Addr) {
 
 	origin,ID := gossipPacket.GetOriginAndID()
 
@@ -156,14 +163,25 @@ func (g *Gossiper) RumorMessageRoutine(gossipPacket *packet.GossipPacket, peerAd
 	}
 
 
+	//the TLC messages are handled one at a time, in the order in which they update the vector clock,
+	//so that the rounds of their origin are counted in order
+	if gossipPacket.TLCMessage != nil {
+		g.tlcMutex.Lock()
+		defer g.tlcMutex.Unlock()
+	}
+
+	//a known message can still reveal a shorter route to its origin
+	hops := gossipPacket.AddHop()
+	if origin != g.Name {
+		g.DSDV.Update(ID, origin, text, peerAddr, hops, g.clock.Now())
+	}
+
+	isNew, advanced := false, false
 	g.State.Mutex.Lock()
 	if ID >= g.GetNextID(origin) && origin!= g.Name {
+		advanced = ID == g.GetNextID(origin)
 
-		g.DSDV.Mutex.Lock()
-		g.DSDV.Update(ID, origin,text, peerAddr)
-		g.DSDV.Mutex.Unlock()
-
-		g.State.UpdateGossiperState(gossipPacket)
+		isNew = g.State.UpdateGossiperState(gossipPacket)
 		g.sendStatusPacket(peerAddr)
 
 		g.Rumormongering(gossipPacket, false, peerAddr, nil)
@@ -173,8 +191,12 @@ func (g *Gossiper) RumorMessageRoutine(gossipPacket *packet.GossipPacket, peerAd
 	}
 	g.State.Mutex.Unlock()
 
-	if gossipPacket.TLCMessage != nil && origin != g.Name{
+	//a TLC message is handled only once, even if it is received several times.
+	//A known message received again can still advance the vector clock (it was received before a missing one).
+	if gossipPacket.TLCMessage != nil && isNew {
 		g.HandleTLCMessage(gossipPacket.TLCMessage)
+	} else if advanced {
+		g.RetryFutureMessages()
 	}
 
 }
@@ -184,7 +206,7 @@ func (g *Gossiper) RumorMessageRoutine(gossipPacket *packet.GossipPacket, peerAd
 //Then it compares its own vector clock with the one in the StatusPacket.
 //It either send a packet to the peer if it is missing one or ask for a packet with a StatusPacket.
 //If both peer are in sync, g toss a coin and either stop the rumormongering or continue with a new peer.
-func (g *Gossiper) StatusPacketRoutine(statusPacket *packet.StatusPacket, peerAddr *net.UDPAddr) {
+func (g *Gossiper) StatusPacketRoutine(statusPacket *packet.StatusPacket, peerAddr net.Addr) {
 	packet.PrintStatusPacket(statusPacket, peerAddr)
 
 	g.Peers.Mutex.RLock()
@@ -214,7 +236,7 @@ func (g *Gossiper) StatusPacketRoutine(statusPacket *packet.StatusPacket, peerAd
 
 }
 
-func (g *Gossiper) StatusPacketHandler(peerVector []packet.PeerStatus, peerAddr *net.UDPAddr, gossipPacket *packet.GossipPacket) {
+func (g *Gossiper) StatusPacketHandler(peerVector []packet.PeerStatus, peerAddr net.Addr, gossipPacket *packet.GossipPacket) {
 
 	g.State.Mutex.RLock()
 	//Check if S has messages that R has not seen yet
@@ -239,55 +261,64 @@ func (g *Gossiper) StatusPacketHandler(peerVector []packet.PeerStatus, peerAddr
 		packet.PrintInSync(peerAddr)
 	}
 
-	if rand.Int()%2 == 0 && gossipPacket != nil {
+	if g.randomInt(2) == 0 && gossipPacket != nil {
 		//log.Println("Mongering flipped coin")
 		g.Rumormongering(gossipPacket, true, peerAddr, nil)
 	}
 }
 
 //sendStatusPacket sends a StatusPacket to peerAddr that serves as an ACK to the RumorMessage.
-func (g *Gossiper) sendStatusPacket(peerAddr *net.UDPAddr) {
+func (g *Gossiper) sendStatusPacket(peerAddr net.Addr) {
 	gossipPacket := &packet.GossipPacket{
 		Status: &packet.StatusPacket{Want: g.State.VectorClock},
 	}
 	g.sendMessage(gossipPacket, peerAddr)
 }
 
-//PrivateMessageRoutine handles the private messages.
-func (g *Gossiper) PrivateMessageRoutine(privateMessage *packet.PrivateMessage) {
+//PrivateMessageRoutine handles the private messages coming from the peer at address from.
+func (g *Gossiper) PrivateMessageRoutine(privateMessage *packet.PrivateMessage, from net.Addr) {
 	if privateMessage.Destination == g.Name {
 
-		packet.PrintPrivateMessage(privateMessage)
+		if privateMessage.IsEncrypted() {
+			if err := g.decryptPrivate(privateMessage); err != nil {
+				helper.LogError(err)
+				return
+			}
+		}
 
+		//a message redelivered by a mailbox peer or sent again by its origin is only acknowledged again
 		g.State.Mutex.Lock()
-		g.State.UpdatePrivateQueue(privateMessage.Origin, privateMessage)
+		duplicate := g.State.FindPrivate(privateMessage.Origin, privateMessage.Origin, privateMessage.ID) != nil
+		if !duplicate {
+			packet.PrintPrivateMessage(privateMessage)
+			g.State.UpdatePrivateQueue(privateMessage.Origin, privateMessage, PRIVATE_RECEIVED, privateMessage.IsEncrypted())
+		}
 		g.State.Mutex.Unlock()
 
+		g.acknowledgeDelivery(privateMessage)
+
 	} else if privateMessage.HopLimit > 0 {
 		privateMessage.HopLimit -= 1
 
-		g.DSDV.Mutex.RLock()
-		if g.DSDV.Contains(privateMessage.Destination) {
-			g.sendMessage(&packet.GossipPacket{Private: privateMessage}, g.DSDV.NextHop[privateMessage.Destination])
+		if nextHop := g.nextHop(privateMessage.Destination, from); nextHop != nil {
+			g.sendMessage(&packet.GossipPacket{Private: privateMessage}, nextHop)
 		}
-		g.DSDV.Mutex.RUnlock()
 	}
 }
 
 //SearchRequestRoutine is the routine that handles the SearchRequest
 //sr *packet.SearchRequest is the search we have to handle
-//from *net.UDPAddr is the address of the node from whom we received the SearchRequest. If from is nil, this means
+//from net.Addr is the address of the node from whom we received the SearchRequest. If from is nil, this means
 //that the SearchRequest comes from the client.
-func (g *Gossiper) SearchRequestRoutine(sr *packet.SearchRequest, from *net.UDPAddr) {
+func (g *Gossiper) SearchRequestRoutine(sr *packet.SearchRequest, from net.Addr) {
 	if !g.DSR.Contains(sr) {
 		g.DSR.Add(sr)
 
 		if sr.Origin != g.Name {
 			g.FilesIndex.Mutex.RLock()
-			results := g.FilesIndex.FindMatchingFiles(sr.Keywords)
+			results := g.FilesIndex.FindMatchingFiles(sr.Keywords, g.resolveFile)
 			g.FilesIndex.Mutex.RUnlock()
-			g.DSDV.Mutex.RLock()
-			if len(results) > 0 && g.DSDV.Contains(sr.Origin) {
+			if len(results) > 0 && g.Router.Contains(sr.Origin) {
 				sreply := &packet.SearchReply{
 					Origin:      g.Name,
 					Destination: sr.Origin,
@@ -295,9 +326,8 @@ func (g *Gossiper) SearchRequestRoutine(sr *packet.SearchRequest, from *net.UDPA
 					Results:     results,
 				}
 
-				g.sendMessage(&packet.GossipPacket{SearchReply: sreply}, g.DSDV.NextHop[sr.Origin])
+				g.sendMessage(&packet.GossipPacket{SearchReply: sreply}, g.nextHop(sr.Origin, nil))
 			}
-			g.DSDV.Mutex.RUnlock()
 
 		}
 
@@ -314,10 +344,11 @@ func (g *Gossiper) SearchRequestRoutine(sr *packet.SearchRequest, from *net.UDPA
 //startDuplicateTimer starts a timer of 0.5 seconds and then remove sr from the set of possible duplicate search request
 func (g *Gossiper) startDuplicateTimer(sr *packet.SearchRequest) {
 
-	ticker := time.NewTicker(500 * time.Millisecond)
+	ticker := g.clock.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
 
 	select {
-	case <-ticker.C:
+	case <-ticker.C():
 		g.DSR.Remove(sr)
 	}
 
@@ -326,9 +357,9 @@ func (g *Gossiper) startDuplicateTimer(sr *packet.SearchRequest) {
 //redistributeBudget redistributes the budget of a given SearchRequest sr by forwarding
 //it to other peers with an evenly distributed budget based on the sr's budget.
 //sr *packet.SearchRequest is the search request we need to forward with a redistributed budget.
-//from *net.UDPAddr is the address of the node from whom we received the SearchRequest. If from is nil, this means
+//from net.Addr is the address of the node from whom we received the SearchRequest. If from is nil, this means
 //that the SearchRequest comes from the client.
-func (g *Gossiper) redistributeBudget(sr *packet.SearchRequest, from *net.UDPAddr) {
+func (g *Gossiper) redistributeBudget(sr *packet.SearchRequest, from net.Addr) {
 	remainingBudget := sr.Budget - 1
 	nbrPeers := uint64(len(g.Peers.Set))
 	if from != nil {
@@ -338,11 +369,11 @@ func (g *Gossiper) redistributeBudget(sr *packet.SearchRequest, from *net.UDPAdd
 
 	if nbrPeers > 0 && remainingBudget > 0 && remainingBudget < nbrPeers {
 
-		var randomPeers []*net.UDPAddr
+		var randomPeers []net.Addr
 		if from == nil {
-			randomPeers = g.Peers.NRandom(remainingBudget)
+			randomPeers = g.Peers.NRandom(g.randomPerm, remainingBudget)
 		} else {
-			randomPeers = g.Peers.NRandom(remainingBudget, from)
+			randomPeers = g.Peers.NRandom(g.randomPerm, remainingBudget, from)
 		}
 
 		forwardSR := &packet.SearchRequest{
@@ -382,7 +413,7 @@ func (g *Gossiper) redistributeBudget(sr *packet.SearchRequest, from *net.UDPAdd
 	}
 }
 
-func (g *Gossiper) SearchReplyRoutine(reply *packet.SearchReply) {
+func (g *Gossiper) SearchReplyRoutine(reply *packet.SearchReply, from net.Addr) {
 	g.fullMatches.Lock()
 	if reply.Destination == g.Name{
 		if  g.fullMatches.n < THRESHOLD_MATCHES {
@@ -392,6 +423,13 @@ func (g *Gossiper) SearchReplyRoutine(reply *packet.SearchReply) {
 					continue
 				}
 
+				//the files unpublished in the chain are hidden and the renamed ones are shown under their new name
+				name, published := g.resolveFile(result.FileName, result.MetafileHash)
+				if !published {
+					continue
+				}
+				result.FileName = name
+
 				newResult := g.Matches.AddNewResult(result, reply.Origin)
 
 				if newResult {
@@ -419,11 +457,9 @@ func (g *Gossiper) SearchReplyRoutine(reply *packet.SearchReply) {
 		g.fullMatches.Unlock()
 	} else if reply.HopLimit > 0 {
 		g.fullMatches.Unlock()
-		g.DSDV.Mutex.RLock()
-		defer g.DSDV.Mutex.RUnlock()
 		reply.HopLimit -= 1
-		if g.DSDV.Contains(reply.Destination) {
-			g.sendMessage(&packet.GossipPacket{SearchReply: reply}, g.DSDV.NextHop[reply.Destination])
+		if nextHop := g.nextHop(reply.Destination, from); nextHop != nil {
+			g.sendMessage(&packet.GossipPacket{SearchReply: reply}, nextHop)
 		}
 	}
 }
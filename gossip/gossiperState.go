@@ -1,7 +1,9 @@
 package gossip
 
 import (
+	"github.com/somecookie/Peerster/helper"
 	"github.com/somecookie/Peerster/packet"
+	"github.com/somecookie/Peerster/storage"
 	"sync"
 )
 
@@ -10,29 +12,73 @@ import (
 //VectorClock: The list of all the next rumor messages per origin that the gossiper may receive
 //ArchivedMessages: contains all messages received by all other peers
 //RumorQueue: a queue of all rumor messages received. The purpose of this queue is to be sent to the GUI
-//PrivateQueue: maps each origin to a queue of all the private messages with that origin.
+//PrivateQueue: maps each origin to a queue of all the private messages with that origin, with their delivery status.
+//Backend: the storage where every change of the state is persisted. It is nil if the state is not persisted.
 type GossiperState struct {
 	VectorClock      []packet.PeerStatus
 	ArchivedMessages map[string]map[uint32]packet.GossipPacket
 	RumorQueue       []packet.GossipPacket
-	PrivateQueue     map[string][]packet.PrivateMessage
+	PrivateQueue     map[string][]PrivateEntry
+	Backend          storage.Backend
 	Mutex            sync.RWMutex
 }
 
 //GossiperStateFactory create a new empty state"
-func GossiperStateFactory() *GossiperState{
+func GossiperStateFactory(backend storage.Backend) *GossiperState{
 	return &GossiperState{
 		VectorClock:      make([]packet.PeerStatus,0),
 		ArchivedMessages: make(map[string]map[uint32]packet.GossipPacket),
 		RumorQueue:       make([]packet.GossipPacket,0),
-		PrivateQueue:     make(map[string][]packet.PrivateMessage),
+		PrivateQueue:     make(map[string][]PrivateEntry),
+		Backend:          backend,
 		Mutex:            sync.RWMutex{},
 	}
 
 }
 
-//UpdateGossiperState updates the vector clock and the archives.
-func (gs *GossiperState) UpdateGossiperState(gossipPacket *packet.GossipPacket) {
+//Restore rebuilds the state from the records stored in the backend.
+//It must be called before the gossiper starts handling packets.
+func (gs *GossiperState) Restore() error {
+	if gs.Backend == nil {
+		return nil
+	}
+
+	backend := gs.Backend
+	gs.Backend = nil
+	defer func() { gs.Backend = backend }()
+
+	return backend.Replay(func(record *storage.Record) {
+		if record.Packet != nil {
+			gs.UpdateGossiperState(record.Packet)
+		} else if record.Private != nil {
+			private := record.Private
+			if !gs.SetPrivateStatus(private.Peer, private.Message.Origin, private.Message.ID, private.Status) {
+				gs.UpdatePrivateQueue(private.Peer, &private.Message, private.Status, private.Encrypted)
+			}
+		}
+	})
+}
+
+//LastID returns the highest ID of the archived messages of origin, 0 if there is none.
+func (gs *GossiperState) LastID(origin string) uint32 {
+	last := uint32(0)
+	for id := range gs.ArchivedMessages[origin] {
+		if id > last {
+			last = id
+		}
+	}
+	return last
+}
+
+//persist appends the record to the backend if the state is persisted.
+func (gs *GossiperState) persist(record *storage.Record) {
+	if gs.Backend != nil {
+		helper.LogError(gs.Backend.Append(record))
+	}
+}
+
+//UpdateGossiperState updates the vector clock and the archives. It returns whether or not the message was new.
+func (gs *GossiperState) UpdateGossiperState(gossipPacket *packet.GossipPacket) bool {
 	var ID uint32
 	var origin string
 
@@ -45,24 +91,90 @@ func (gs *GossiperState) UpdateGossiperState(gossipPacket *packet.GossipPacket)
 	}
 
 	gs.updateVectorClock(origin, ID)
-	gs.updateArchive(origin, ID, gossipPacket)
+	if gs.updateArchive(origin, ID, gossipPacket) {
+		gs.persist(&storage.Record{Packet: gossipPacket})
+		return true
+	}
+	return false
 
 }
 
 //UpdatePrivateQueue enqueues the private message to the corresponding queue.
 //The destination parameter is needed when you add you own message to the queue.
+//status is the delivery status of the message and encrypted tells if it is sent encrypted.
 //This method is not thread-safe.
-func (gs *GossiperState) UpdatePrivateQueue(destination string, privateMessage *packet.PrivateMessage){
+func (gs *GossiperState) UpdatePrivateQueue(destination string, privateMessage *packet.PrivateMessage, status string, encrypted bool){
 	_, ok := gs.PrivateQueue[destination]
 
 	if !ok{
-		gs.PrivateQueue[destination] = make([]packet.PrivateMessage, 0, 1)
+		gs.PrivateQueue[destination] = make([]PrivateEntry, 0, 1)
 	}
 
-	gs.PrivateQueue[destination] = append(gs.PrivateQueue[destination], *privateMessage)
+	gs.PrivateQueue[destination] = append(gs.PrivateQueue[destination], PrivateEntry{
+		PrivateMessage: *privateMessage,
+		Status:         status,
+		Encrypted:      encrypted,
+	})
+	gs.persist(&storage.Record{Private: &storage.PrivateRecord{
+		Peer:      destination,
+		Message:   *privateMessage,
+		Status:    status,
+		Encrypted: encrypted,
+	}})
 
 }
 
+//FindPrivate returns the message of origin with the given ID in the conversation with peer, nil if there is none.
+//Messages without ID (0) are never found.
+//This method is not thread-safe.
+func (gs *GossiperState) FindPrivate(peer, origin string, ID uint32) *PrivateEntry {
+	if ID == 0 {
+		return nil
+	}
+
+	for i, entry := range gs.PrivateQueue[peer] {
+		if entry.Origin == origin && entry.ID == ID {
+			return &gs.PrivateQueue[peer][i]
+		}
+	}
+	return nil
+}
+
+//SetPrivateStatus changes the delivery status of the message of origin with the given ID in the conversation with peer.
+//It returns false if there is no such message.
+//This method is not thread-safe.
+func (gs *GossiperState) SetPrivateStatus(peer, origin string, ID uint32, status string) bool {
+	entry := gs.FindPrivate(peer, origin, ID)
+	if entry == nil {
+		return false
+	}
+
+	if entry.Status != status {
+		entry.Status = status
+		gs.persist(&storage.Record{Private: &storage.PrivateRecord{
+			Peer:      peer,
+			Message:   entry.PrivateMessage,
+			Status:    status,
+			Encrypted: entry.Encrypted,
+		}})
+	}
+	return true
+}
+
+//LastPrivateID returns the highest ID of the private messages sent by origin, 0 if there is none.
+//This method is not thread-safe.
+func (gs *GossiperState) LastPrivateID(origin string) uint32 {
+	last := uint32(0)
+	for _, queue := range gs.PrivateQueue {
+		for _, entry := range queue {
+			if entry.Origin == origin && entry.ID > last {
+				last = entry.ID
+			}
+		}
+	}
+	return last
+}
+
 func (gs *GossiperState) updateVectorClock(origin string, id uint32) {
 	inVC := false
 	for i, peerStat := range gs.VectorClock {
@@ -91,7 +203,9 @@ func (gs *GossiperState) updateVectorClock(origin string, id uint32) {
 }
 
 
-func (gs *GossiperState) updateArchive(origin string, id uint32, message *packet.GossipPacket) {
+//updateArchive archives the message if it is not already archived.
+//It returns whether or not the message was new.
+func (gs *GossiperState) updateArchive(origin string, id uint32, message *packet.GossipPacket) bool {
 
 	_, ok := gs.ArchivedMessages[origin]
 
@@ -107,10 +221,10 @@ func (gs *GossiperState) updateArchive(origin string, id uint32, message *packet
 		if (message.Rumor != nil && message.Rumor.Text != "") || (message.TLCMessage != nil && message.TLCMessage.Confirmed != -1){
 			gs.RumorQueue = append(gs.RumorQueue, *message)
 		}
+		return true
 	}
 
-
-
+	return false
 }
 
 /*func (gs *GossiperState) String() string{
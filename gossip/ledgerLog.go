@@ -0,0 +1,372 @@
+package gossip
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/identity"
+	"github.com/somecookie/Peerster/packet"
+	"github.com/somecookie/Peerster/storage"
+	"strings"
+	"sync"
+)
+
+//LEDGER_SNAPSHOT_INTERVAL is the number of blocks appended to the ledger log between two snapshots
+const LEDGER_SNAPSHOT_INTERVAL = 16
+
+//LedgerLog persists what the network agreed on: the confirmed TLC messages and the blocks added to the chain.
+//Every LEDGER_SNAPSHOT_INTERVAL blocks, a snapshot of the registry and of the membership is appended as well,
+//so that the gossiper does not replay all the confirmed messages when it restarts.
+//Backend: the storage of the log. It is nil if the ledger is not persisted.
+type LedgerLog struct {
+	sync.Mutex
+	Backend storage.Backend
+	blocks  int
+}
+
+func LedgerLogFactory(backend storage.Backend) *LedgerLog {
+	return &LedgerLog{
+		Mutex:   sync.Mutex{},
+		Backend: backend,
+		blocks:  0,
+	}
+}
+
+//append appends the record to the backend if the ledger is persisted.
+func (ll *LedgerLog) append(record *storage.Record) {
+	if ll.Backend != nil {
+		helper.LogError(ll.Backend.Append(record))
+	}
+}
+
+//recordConfirmation registers the name of a confirmed TLC message for its origin and appends the message to the ledger log
+func (g *Gossiper) recordConfirmation(msg *packet.TLCMessage) {
+	g.claimName(msg.TxBlock.Transaction, msg.Origin)
+	g.LedgerLog.append(&storage.Record{Confirmed: msg})
+}
+
+//recordBlock appends a block added to the chain (or waiting for its parent) to the ledger log
+func (g *Gossiper) recordBlock(block *storage.BlockRecord) {
+	g.LedgerLog.Lock()
+	defer g.LedgerLog.Unlock()
+
+	g.LedgerLog.append(&storage.Record{Block: block})
+	g.LedgerLog.blocks += 1
+}
+
+//snapshotLedger appends a snapshot of the registry and of the membership to the ledger log if enough blocks were
+//appended since the last one. It is called each time the head of the chain changes, once they are up to date.
+func (g *Gossiper) snapshotLedger() {
+	ll := g.LedgerLog
+	ll.Lock()
+	defer ll.Unlock()
+
+	if ll.Backend == nil || ll.blocks < LEDGER_SNAPSHOT_INTERVAL {
+		return
+	}
+
+	head, _ := g.Blockchain.Head()
+	ll.append(&storage.Record{Snapshot: &storage.Snapshot{
+		Head:          head,
+		Registrations: g.Registry.Registrations(),
+		Membership:    g.TLCMajority.Membership.Snapshot(),
+	}})
+	ll.blocks = 0
+}
+
+//restoreLedger rebuilds the chain from the blocks of the ledger log. The registry is resumed from the last snapshot
+//and from the confirmed messages appended after it, the membership from the last snapshot if it was taken at the
+//head of the chain. Otherwise the membership is rebuilt from the chain.
+//It must be called before the gossiper starts handling packets.
+func (g *Gossiper) restoreLedger() error {
+	ll := g.LedgerLog
+	if ll.Backend == nil {
+		return nil
+	}
+
+	var snapshot *storage.Snapshot
+	confirmed := make([]*packet.TLCMessage, 0)
+	blocks := 0
+	err := ll.Backend.Replay(func(record *storage.Record) {
+		switch {
+		case record.Confirmed != nil:
+			confirmed = append(confirmed, record.Confirmed)
+		case record.Block != nil && record.Block.Linked() != nil:
+			blocks++
+			ll.blocks++
+			switch err := g.Blockchain.Load(record.Block.Linked()); err.(type) {
+			case nil, *blockchain.DuplicateBlockError, *blockchain.UnknownParentError:
+			default:
+				helper.LogError(err)
+			}
+		case record.Snapshot != nil:
+			snapshot = record.Snapshot
+			confirmed = confirmed[:0]
+			ll.blocks = 0
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if snapshot != nil {
+		g.Registry.Restore(snapshot.Registrations)
+	}
+	for _, msg := range confirmed {
+		g.claimName(msg.TxBlock.Transaction, msg.Origin)
+	}
+	g.syncRegistry()
+
+	head, height := g.Blockchain.Head()
+	if snapshot == nil || snapshot.Head != head || !g.TLCMajority.Membership.Restore(snapshot.Membership) {
+		g.TLCMajority.Membership.Sync(g.Blockchain.Blocks())
+	}
+
+	if blocks > 0 || len(confirmed) > 0 || snapshot != nil {
+		fmt.Printf("LEDGER RESTORED blocks %d head %s height %d\n", blocks, blockchain.HashString(head), height)
+	}
+	return nil
+}
+
+//VerifyLedger checks a ledger log offline, record by record: the signatures of the confirmed TLC messages and of their
+//witnesses, the signatures of the transactions, the proof of work of the mined blocks (at least config.Difficulty leading
+//zero bits), the validity of the transactions of each block on the chain it is linked to and the membership of each snapshot.
+//The membership is rebuilt from the founders of config while the blocks are replayed. As for the gossiper, a confirmed
+//message must come from a member and be witnessed by more than the threshold of the members (with config.FaultThreshold),
+//for one of the memberships of the chain so far.
+//As for the gossiper, the key of a name that is not recorded by the membership is trusted on first use: a name signing
+//with another key later is an error.
+//The blocks whose parent is not in the log cannot be linked to the chain. They are counted but they are not an error.
+//It returns a summary of the log, or an InvalidLedgerError for the first invalid record.
+func VerifyLedger(backend storage.Backend, config Config) (string, error) {
+	id, err := identity.LoadOrGenerate(config.Name)
+	if err != nil {
+		return "", err
+	}
+	founders, err := parseFounders(config, id)
+	if err != nil {
+		return "", err
+	}
+
+	lv := &ledgerVerifier{
+		chain:          blockchain.ChainFactory(),
+		keys:           make(map[string][]byte),
+		difficulty:     config.Difficulty,
+		founders:       founders,
+		membership:     blockchain.MembershipFactory(founders),
+		memberships:    make(map[string]blockchain.Members),
+		faultThreshold: config.FaultThreshold,
+	}
+	for _, founder := range founders {
+		if founder.PublicKey != nil {
+			lv.keys[founder.Name] = founder.PublicKey
+		}
+	}
+	lv.addMembers()
+
+	index := 0
+	hashes := make([][32]byte, 0)
+	confirmed, snapshots := 0, 0
+	var invalid error
+	err = backend.Replay(func(record *storage.Record) {
+		index++
+		if invalid != nil {
+			return
+		}
+
+		reason := ""
+		switch {
+		case record.Confirmed != nil:
+			confirmed++
+			reason = lv.verifyConfirmation(record.Confirmed)
+		case record.Block != nil:
+			if linked := record.Block.Linked(); linked != nil {
+				hashes = append(hashes, linked.Hash())
+			}
+			reason = lv.verifyBlock(record.Block)
+		case record.Snapshot != nil:
+			snapshots++
+			reason = lv.verifySnapshot(record.Snapshot)
+		default:
+			reason = "empty record"
+		}
+
+		if reason != "" {
+			invalid = &InvalidLedgerError{Record: index, Reason: reason}
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if invalid != nil {
+		return "", invalid
+	}
+
+	unlinked := 0
+	for _, hash := range hashes {
+		if !lv.chain.Contains(hash) {
+			unlinked++
+		}
+	}
+
+	head, height := lv.chain.Head()
+	return fmt.Sprintf("LEDGER VALID records %d confirmed %d blocks %d unlinked %d snapshots %d head %s height %d",
+		index, confirmed, len(hashes), unlinked, snapshots, blockchain.HashString(head), height), nil
+}
+
+//ledgerVerifier is the state of VerifyLedger: the chain rebuilt from the log, the keys of the names seen so far
+//and the membership of the chain, with all the sets of members it had (by sorted names)
+type ledgerVerifier struct {
+	chain          *blockchain.Chain
+	keys           map[string][]byte
+	difficulty     int
+	founders       []blockchain.Founder
+	membership     *blockchain.Membership
+	memberships    map[string]blockchain.Members
+	faultThreshold int
+}
+
+//addMembers records the current members of the membership
+func (lv *ledgerVerifier) addMembers() {
+	members := lv.membership.Current()
+	lv.memberships[strings.Join(members.Names(), ",")] = members
+}
+
+//keyOf returns the key of the member called origin: the key recorded in members, or the key learned for it otherwise.
+//It returns false if origin is not one of the members or if its key is unknown.
+func (lv *ledgerVerifier) keyOf(members blockchain.Members, origin string) ([]byte, bool) {
+	key, member := members[origin]
+	if !member {
+		return nil, false
+	}
+	if key == nil {
+		key, member = lv.keys[origin]
+	}
+	return key, member
+}
+
+//learn records the key of name if it is the first one, and checks that it is the same key otherwise
+func (lv *ledgerVerifier) learn(name string, key []byte) bool {
+	if known, ok := lv.keys[name]; ok {
+		return bytes.Equal(known, key)
+	}
+	lv.keys[name] = append([]byte(nil), key...)
+	return true
+}
+
+//verifyConfirmation checks the signature of the confirmed message, of its witnesses and of its transaction.
+//It returns the reason why the message is invalid, or an empty string.
+func (lv *ledgerVerifier) verifyConfirmation(msg *packet.TLCMessage) string {
+	if msg.Confirmed == -1 {
+		return fmt.Sprintf("the message of origin %s ID %d is not confirmed", msg.Origin, msg.ID)
+	}
+	if !msg.Verify() || !lv.learn(msg.Origin, msg.PublicKey) {
+		return fmt.Sprintf("invalid signature of the confirmation of origin %s ID %d", msg.Origin, msg.ID)
+	}
+
+	hash := msg.Witnessed()
+	for _, ack := range msg.Witnesses {
+		if ack.Destination != msg.Origin || ack.ID != uint32(msg.Confirmed) || !bytes.Equal(ack.Hash, hash[:]) {
+			return fmt.Sprintf("the witness %s of origin %s ID %d acknowledged another message", ack.Origin, msg.Origin, msg.ID)
+		}
+		if !ack.Verify() || !lv.learn(ack.Origin, ack.PublicKey) {
+			return fmt.Sprintf("invalid signature of the witness %s of origin %s ID %d", ack.Origin, msg.Origin, msg.ID)
+		}
+	}
+
+	if !lv.isWitnessed(msg) {
+		return fmt.Sprintf("the confirmation of origin %s ID %d is not witnessed by a majority of the members", msg.Origin, msg.ID)
+	}
+
+	return lv.verifyTransaction(msg.TxBlock.Transaction)
+}
+
+//isWitnessed checks that msg comes from a member and is witnessed by more than the threshold of the members, with the
+//same rule as the gossiper, for one of the memberships of the chain so far
+func (lv *ledgerVerifier) isWitnessed(msg *packet.TLCMessage) bool {
+	for _, members := range lv.memberships {
+		if key, ok := lv.keyOf(members, msg.Origin); !ok || !bytes.Equal(key, msg.PublicKey) {
+			continue
+		}
+
+		witnesses := countWitnesses(msg, func(origin string) ([]byte, bool) {
+			return lv.keyOf(members, origin)
+		})
+		if witnesses > threshold(len(members), lv.faultThreshold) {
+			return true
+		}
+	}
+	return false
+}
+
+//verifyTransaction checks the signature of the transaction, or of its membership change.
+//It returns the reason why the transaction is invalid, or an empty string.
+func (lv *ledgerVerifier) verifyTransaction(tx blockchain.TxPublish) string {
+	if tx.IsMembershipChange() {
+		if !tx.Membership.Verify() || !lv.learn(tx.Membership.Member, tx.Membership.PublicKey) {
+			return "invalid signature of " + tx.Label()
+		}
+		return ""
+	}
+
+	if !tx.Verify() || !lv.learn(tx.Owner, tx.PublicKey) {
+		return "invalid signature of " + tx.Label()
+	}
+	return ""
+}
+
+//verifyBlock checks the proof of work of a mined block and the signatures of the transactions, then adds the block
+//to the chain, which checks its link to its parent and its transactions.
+//It returns the reason why the block is invalid, or an empty string.
+func (lv *ledgerVerifier) verifyBlock(record *storage.BlockRecord) string {
+	block := record.Linked()
+	if block == nil {
+		return "empty block"
+	}
+	if record.Mined != nil && !record.Mined.CheckPoW(lv.difficulty) {
+		return "not enough proof of work for block " + blockchain.HashString(block.Hash())
+	}
+
+	for _, tx := range block.GetTransactions() {
+		if reason := lv.verifyTransaction(tx); reason != "" {
+			return reason
+		}
+	}
+
+	switch err := lv.chain.Load(block); err.(type) {
+	case nil:
+		lv.membership.Sync(lv.chain.Blocks())
+		lv.addMembers()
+		return ""
+	case *blockchain.DuplicateBlockError, *blockchain.UnknownParentError:
+		return ""
+	default:
+		return err.Error()
+	}
+}
+
+//verifySnapshot checks that the head of the snapshot is in the chain and that its membership is the one of the chain ending there.
+//It returns the reason why the snapshot is invalid, or an empty string.
+func (lv *ledgerVerifier) verifySnapshot(snapshot *storage.Snapshot) string {
+	if snapshot.Head != [32]byte{} && !lv.chain.Contains(snapshot.Head) {
+		return "the head " + blockchain.HashString(snapshot.Head) + " of the snapshot is not in the chain"
+	}
+
+	membership := blockchain.MembershipFactory(lv.founders)
+	membership.Sync(lv.chain.Branch(snapshot.Head))
+	if !membership.Snapshot().Equal(snapshot.Membership) {
+		return "the membership of the snapshot does not match the chain"
+	}
+	return ""
+}
+
+//InvalidLedgerError is returned when a record of the ledger log is not valid.
+type InvalidLedgerError struct {
+	Record int
+	Reason string
+}
+
+func (e *InvalidLedgerError) Error() string {
+	return fmt.Sprintf("invalid record %d of the ledger log: %s", e.Record, e.Reason)
+}
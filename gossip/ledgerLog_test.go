@@ -0,0 +1,144 @@
+package gossip
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/packet"
+	"github.com/somecookie/Peerster/storage"
+)
+
+//TestMain runs the tests in a temporary directory, since the identity of the verifier is stored in the working directory
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "gossip")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+//verifierConfig returns the configuration of a verifier that joined the founders, given with their keys
+func verifierConfig(founders []blockchain.Founder, f int) Config {
+	config := Config{Name: "verifier", N: len(founders), Join: true, Difficulty: 8, FaultThreshold: f}
+	for _, founder := range founders {
+		config.Founders = append(config.Founders, founder.Name+":"+hex.EncodeToString(founder.PublicKey))
+	}
+	return config
+}
+
+//ledger returns a ledger log with the confirmations of round 0, the block published in this round and a snapshot
+func ledger(t *testing.T, founders []blockchain.Founder, confirmations ...packet.TLCMessage) *storage.MemoryStore {
+	backend := storage.MemoryStoreFactory()
+	for i := range confirmations {
+		backend.Append(&storage.Record{Confirmed: &confirmations[i]})
+	}
+
+	block := &blockchain.BlockPublish{Transaction: confirmations[0].TxBlock.Transaction}
+	backend.Append(&storage.Record{Block: &storage.BlockRecord{Published: block}})
+	backend.Append(&storage.Record{Snapshot: &storage.Snapshot{
+		Head:       block.Hash(),
+		Membership: blockchain.MembershipFactory(founders).Snapshot(),
+	}})
+	return backend
+}
+
+//checkLedger verifies the ledger log and checks that the first invalid record is invalid (0 if the log is valid)
+func checkLedger(t *testing.T, backend storage.Backend, config Config, invalid int) {
+	summary, err := VerifyLedger(backend, config)
+	switch err := err.(type) {
+	case nil:
+		if invalid != 0 {
+			t.Fatalf("valid ledger (%s), expected record %d to be invalid", summary, invalid)
+		}
+	case *InvalidLedgerError:
+		if err.Record != invalid {
+			t.Fatalf("%s, expected record %d to be invalid", err, invalid)
+		}
+	default:
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyLedger(t *testing.T) {
+	members := testMembers(t, "A", "B", "C", "D")
+	a, b, c, d := members[0], members[1], members[2], members[3]
+	outsider := testMembers(t, "E")[0]
+	config := verifierConfig(founders(members), 0)
+
+	t.Run("valid", func(t *testing.T) {
+		backend := ledger(t, founders(members), confirmation(t, a, 0, a, b, c), confirmation(t, b, 0, b, c, d))
+		checkLedger(t, backend, config, 0)
+	})
+
+	t.Run("no majority of witnesses", func(t *testing.T) {
+		backend := ledger(t, founders(members), confirmation(t, a, 0, a, b, c), confirmation(t, b, 0, b, c))
+		checkLedger(t, backend, config, 2)
+	})
+
+	t.Run("witnesses that are not members", func(t *testing.T) {
+		backend := ledger(t, founders(members), confirmation(t, a, 0, a, b, outsider))
+		checkLedger(t, backend, config, 1)
+	})
+
+	t.Run("confirmation of an outsider", func(t *testing.T) {
+		backend := ledger(t, founders(members), confirmation(t, outsider, 0, a, b, c))
+		checkLedger(t, backend, config, 1)
+	})
+
+	t.Run("other key of a founder", func(t *testing.T) {
+		impostors := founders(members)
+		impostors[0].PublicKey = outsider.sk.Public().(ed25519.PublicKey)
+		backend := ledger(t, impostors, confirmation(t, a, 0, a, b, c))
+		checkLedger(t, backend, verifierConfig(impostors, 0), 1)
+	})
+
+	t.Run("fault threshold", func(t *testing.T) {
+		backend := ledger(t, founders(members), confirmation(t, a, 0, a, b, c))
+		checkLedger(t, backend, verifierConfig(founders(members), 1), 1)
+
+		backend = ledger(t, founders(members), confirmation(t, a, 0, a, b, c, d))
+		checkLedger(t, backend, verifierConfig(founders(members), 1), 0)
+	})
+
+	t.Run("snapshot of another membership", func(t *testing.T) {
+		backend := ledger(t, founders(members[:3]), confirmation(t, a, 0, a, b, c))
+		checkLedger(t, backend, config, 3)
+	})
+}
+
+func TestVerifyLedgerProofOfWork(t *testing.T) {
+	members := testMembers(t, "A")
+	config := verifierConfig(founders(members), 0)
+
+	tx := blockchain.TxPublish{Name: "mined.txt", Size: 1, MetafileHash: []byte("mined"), Owner: "A"}
+	if err := tx.Sign(members[0].sk); err != nil {
+		t.Fatal(err)
+	}
+
+	mined := &blockchain.Block{Transactions: []blockchain.TxPublish{tx}}
+	if !mined.Mine(config.Difficulty, 1<<20) {
+		t.Fatal("no nonce found")
+	}
+	backend := storage.MemoryStoreFactory()
+	backend.Append(&storage.Record{Block: &storage.BlockRecord{Mined: mined}})
+	checkLedger(t, backend, config, 0)
+
+	//the proof of work of the block does not match a higher difficulty
+	for mined.CheckPoW(config.Difficulty + 1) {
+		if !mined.Mine(config.Difficulty, 1<<20) {
+			t.Fatal("no nonce found")
+		}
+	}
+	config.Difficulty += 1
+	checkLedger(t, backend, config, 1)
+}
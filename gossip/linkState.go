@@ -0,0 +1,58 @@
+package gossip
+
+import (
+	"github.com/somecookie/Peerster/clock"
+	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/packet"
+	"net"
+	"sync/atomic"
+)
+
+//linkStateSeqStart returns the sequence number after which the link-state advertisements of a gossiper starting now
+//are numbered. It is the current time in seconds, so that the advertisements of a restarted gossiper are newer than
+//the ones it sent before the restart (as long as it sent less than one advertisement per second on average).
+func linkStateSeqStart(clk clock.Clock) uint32 {
+	return uint32(clk.Now().Unix())
+}
+
+//advertiseLinks updates the neighbors of the gossiper in the topology with its current peers and floods them to all the peers.
+//It does nothing if the gossiper does not use link-state routing.
+func (g *Gossiper) advertiseLinks() {
+	if g.LinkState == nil {
+		return
+	}
+
+	g.Peers.Mutex.RLock()
+	peers := g.Peers.PeersSetAsList()
+	g.Peers.Mutex.RUnlock()
+
+	g.LinkState.SetNeighbors(peers)
+
+	neighbors := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		neighbors = append(neighbors, peer.String())
+	}
+
+	advertisement := &packet.LinkStateMessage{
+		Origin:    g.Name,
+		Address:   g.GossipAddr,
+		Seq:       atomic.AddUint32(&g.linkStateSeq, 1),
+		Neighbors: neighbors,
+	}
+	helper.LogError(advertisement.Sign(g.Identity.SigningKey))
+
+	g.broadcast(&packet.GossipPacket{LinkState: advertisement}, nil)
+}
+
+//LinkStateRoutine handles a link-state advertisement coming from the peer at address from.
+//A new advertisement updates the topology and is flooded to all the peers except the one it came from.
+//The advertisements are ignored if the gossiper does not use link-state routing.
+func (g *Gossiper) LinkStateRoutine(advertisement *packet.LinkStateMessage, from net.Addr) {
+	if g.LinkState == nil {
+		return
+	}
+
+	if g.LinkState.Update(advertisement.Origin, advertisement.Address, advertisement.Seq, advertisement.Neighbors, g.clock.Now()) {
+		g.broadcast(&packet.GossipPacket{LinkState: advertisement}, from)
+	}
+}
@@ -0,0 +1,363 @@
+package gossip
+
+import (
+	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/packet"
+	"github.com/somecookie/Peerster/storage"
+	"net"
+	"sync"
+	"time"
+)
+
+//The delivery status of a private message of the PrivateQueue.
+//PRIVATE_SENT: the message was sent to its destination and its delivery is not acknowledged yet
+//PRIVATE_QUEUED: the message could not be delivered, it is stored at the gossiper until there is a route to its destination
+//PRIVATE_DEPOSITED: the message was handed to a mailbox peer that redelivers it
+//PRIVATE_DELIVERED: the destination acknowledged the message
+//PRIVATE_RECEIVED: the message was received by the gossiper
+const (
+	PRIVATE_SENT      = "sent"
+	PRIVATE_QUEUED    = "queued"
+	PRIVATE_DEPOSITED = "deposited"
+	PRIVATE_DELIVERED = "delivered"
+	PRIVATE_RECEIVED  = "received"
+)
+
+//MAILBOX_ACK_INTERVALS is the number of mailbox intervals after which a sent or deposited message that is not acknowledged
+//is queued again
+const MAILBOX_ACK_INTERVALS = 2
+
+//PrivateEntry is a private message of a conversation together with its delivery status
+//Status    string is the delivery status of the message
+//Encrypted bool tells if the message is sent encrypted for its destination. The message of the entry is always the plaintext.
+type PrivateEntry struct {
+	packet.PrivateMessage
+	Status    string
+	Encrypted bool
+}
+
+//Mailbox contains the state of the store-and-forward of the private messages. The operations on it are thread-safe.
+//The undelivered messages of the gossiper are stored in its PrivateQueue: they are retried every mailbox interval,
+//or deposited at one of the mailbox peers.
+//Peers    []string are the names of the designated mailbox peers
+//Attempts  map[uint32]time.Time is the time of the last attempt to send or deposit each message of the gossiper, by ID
+//Deposited map[uint32]string is the mailbox peer at which each message of the gossiper was last deposited, by ID
+//Stored    map[string][]packet.PrivateMessage are the messages deposited at the gossiper by other gossipers, by destination.
+//They are kept until their destination acknowledges them.
+//Backend   storage.Backend is the storage where the changes of Stored are persisted. It is nil if they are not persisted.
+type Mailbox struct {
+	sync.Mutex
+	Peers     []string
+	Attempts  map[uint32]time.Time
+	Deposited map[uint32]string
+	Stored    map[string][]packet.PrivateMessage
+	Backend   storage.Backend
+}
+
+func MailboxFactory(peers []string, backend storage.Backend) *Mailbox {
+	return &Mailbox{
+		Mutex:     sync.Mutex{},
+		Peers:     peers,
+		Attempts:  make(map[uint32]time.Time),
+		Deposited: make(map[uint32]string),
+		Stored:    make(map[string][]packet.PrivateMessage),
+		Backend:   backend,
+	}
+}
+
+//Restore rebuilds the messages deposited at the gossiper from the records stored in the backend.
+//It must be called before the gossiper starts handling packets.
+func (m *Mailbox) Restore() error {
+	if m.Backend == nil {
+		return nil
+	}
+
+	return m.Backend.Replay(func(record *storage.Record) {
+		if record.Deposit == nil {
+			return
+		}
+
+		pm := record.Deposit.Message
+		if record.Deposit.Removed {
+			m.remove(pm.Destination, pm.Origin, pm.ID)
+		} else if m.find(pm.Destination, pm.Origin, pm.ID) < 0 {
+			m.Stored[pm.Destination] = append(m.Stored[pm.Destination], pm)
+		}
+	})
+}
+
+//find returns the index of the message of origin with the given ID stored for destination, -1 if there is none.
+//The Mutex must be held.
+func (m *Mailbox) find(destination, origin string, ID uint32) int {
+	for i, pm := range m.Stored[destination] {
+		if pm.Origin == origin && pm.ID == ID {
+			return i
+		}
+	}
+	return -1
+}
+
+//remove removes the message of origin with the given ID stored for destination and returns it, nil if there is none.
+//The Mutex must be held.
+func (m *Mailbox) remove(destination, origin string, ID uint32) *packet.PrivateMessage {
+	i := m.find(destination, origin, ID)
+	if i < 0 {
+		return nil
+	}
+
+	stored := m.Stored[destination]
+	pm := stored[i]
+	m.Stored[destination] = append(stored[:i], stored[i+1:]...)
+	if len(m.Stored[destination]) == 0 {
+		delete(m.Stored, destination)
+	}
+	return &pm
+}
+
+//persist appends the record to the backend if the mailbox is persisted.
+func (m *Mailbox) persist(record *storage.Record) {
+	if m.Backend != nil {
+		helper.LogError(m.Backend.Append(record))
+	}
+}
+
+//outgoing is a message of the gossiper that is not delivered yet
+type outgoing struct {
+	destination string
+	entry       PrivateEntry
+}
+
+//MailboxRoutine retries the undelivered messages of the gossiper and redelivers the messages deposited at the
+//gossiper every g.mailboxTimer seconds.
+func (g *Gossiper) MailboxRoutine() {
+	ticker := g.clock.NewTicker(g.mailboxTimer * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			g.retryPrivates()
+			g.redeliverStored()
+		}
+	}
+}
+
+//retryPrivates queues again the sent and deposited messages whose delivery was not acknowledged in time and tries to
+//deliver the queued messages. A deposited message is lost if its mailbox peer restarts without persisting it, or never
+//reaches its destination.
+func (g *Gossiper) retryPrivates() {
+	g.State.Mutex.RLock()
+	pending := make([]outgoing, 0)
+	for destination, queue := range g.State.PrivateQueue {
+		for _, entry := range queue {
+			if entry.Origin == g.Name && entry.Status != PRIVATE_DELIVERED && entry.Status != PRIVATE_RECEIVED {
+				pending = append(pending, outgoing{destination: destination, entry: entry})
+			}
+		}
+	}
+	g.State.Mutex.RUnlock()
+
+	timeout := MAILBOX_ACK_INTERVALS * g.mailboxTimer * time.Second
+	for _, o := range pending {
+		if o.entry.Status == PRIVATE_SENT || o.entry.Status == PRIVATE_DEPOSITED {
+			g.Mailbox.Lock()
+			attempt, ok := g.Mailbox.Attempts[o.entry.ID]
+			g.Mailbox.Unlock()
+
+			if ok && g.clock.Now().Sub(attempt) <= timeout {
+				continue
+			}
+		}
+
+		g.setDeliveryStatus(o.destination, o.entry.ID, g.deliverPrivate(o.destination, o.entry))
+	}
+}
+
+//setDeliveryStatus changes the delivery status of the message of the gossiper with the given ID sent to destination,
+//unless the message was delivered in the meantime
+func (g *Gossiper) setDeliveryStatus(destination string, ID uint32, status string) {
+	g.State.Mutex.Lock()
+	defer g.State.Mutex.Unlock()
+
+	if entry := g.State.FindPrivate(destination, g.Name, ID); entry != nil && entry.Status != PRIVATE_DELIVERED {
+		g.State.SetPrivateStatus(destination, g.Name, ID, status)
+	}
+}
+
+//deliverPrivate sends a message of the gossiper to its destination if there is a route, or deposits it at a mailbox
+//peer that can be reached. A message deposited again goes to the next mailbox peer after the one it was deposited at.
+//The message is encrypted (if needed) and signed again.
+//It returns the new delivery status of the message.
+func (g *Gossiper) deliverPrivate(destination string, entry PrivateEntry) string {
+	pm := entry.PrivateMessage
+	pm.HopLimit = packet.MaxHops - 1
+
+	sent := &pm
+	if entry.Encrypted {
+		sent = g.encryptPrivate(&pm)
+		if sent == nil {
+			return PRIVATE_QUEUED
+		}
+	}
+	helper.LogError(sent.Sign(g.Identity.SigningKey))
+
+	if nextHop := g.nextHop(destination, nil); nextHop != nil {
+		g.Mailbox.Lock()
+		g.Mailbox.Attempts[pm.ID] = g.clock.Now()
+		g.Mailbox.Unlock()
+
+		g.sendMessage(&packet.GossipPacket{Private: sent}, nextHop)
+		return PRIVATE_SENT
+	}
+
+	g.Mailbox.Lock()
+	previous, deposited := g.Mailbox.Deposited[pm.ID]
+	g.Mailbox.Unlock()
+
+	start := 0
+	for i, mailbox := range g.Mailbox.Peers {
+		if deposited && mailbox == previous {
+			start = i + 1
+		}
+	}
+
+	for i := range g.Mailbox.Peers {
+		mailbox := g.Mailbox.Peers[(start+i)%len(g.Mailbox.Peers)]
+		if mailbox == g.Name || mailbox == destination {
+			continue
+		}
+		if nextHop := g.nextHop(mailbox, nil); nextHop != nil {
+			g.Mailbox.Lock()
+			g.Mailbox.Attempts[pm.ID] = g.clock.Now()
+			g.Mailbox.Deposited[pm.ID] = mailbox
+			g.Mailbox.Unlock()
+
+			deposit := &packet.MailboxDeposit{Mailbox: mailbox, HopLimit: packet.MaxHops - 1, Message: *sent}
+			g.sendMessage(&packet.GossipPacket{Deposit: deposit}, nextHop)
+			if entry.Status != PRIVATE_DEPOSITED || mailbox != previous {
+				packet.PrintMailbox("DEPOSITED at "+mailbox, pm.Origin, destination, pm.ID)
+			}
+			return PRIVATE_DEPOSITED
+		}
+	}
+
+	if entry.Status != PRIVATE_QUEUED {
+		packet.PrintMailbox("QUEUED", pm.Origin, destination, pm.ID)
+	}
+	return PRIVATE_QUEUED
+}
+
+//redeliverStored sends the messages deposited at the gossiper to their destination if there is a route.
+//They are stored (and persisted) until their destination acknowledges them.
+func (g *Gossiper) redeliverStored() {
+	g.Mailbox.Lock()
+	stored := make([]packet.PrivateMessage, 0)
+	for _, messages := range g.Mailbox.Stored {
+		stored = append(stored, messages...)
+	}
+	g.Mailbox.Unlock()
+
+	for _, pm := range stored {
+		if nextHop := g.nextHop(pm.Destination, nil); nextHop != nil {
+			redelivered := pm
+			redelivered.HopLimit = packet.MaxHops - 1
+			redelivered.Mailbox = g.Name
+			g.sendMessage(&packet.GossipPacket{Private: &redelivered}, nextHop)
+		}
+	}
+}
+
+//MailboxDepositRoutine handles the deposits coming from the peer at address from. The gossiper stores the deposits
+//whose mailbox it is and forwards the others like private messages.
+func (g *Gossiper) MailboxDepositRoutine(deposit *packet.MailboxDeposit, from net.Addr) {
+	if deposit.Mailbox != g.Name {
+		if deposit.HopLimit > 0 {
+			deposit.HopLimit -= 1
+			if nextHop := g.nextHop(deposit.Mailbox, from); nextHop != nil {
+				g.sendMessage(&packet.GossipPacket{Deposit: deposit}, nextHop)
+			}
+		}
+		return
+	}
+
+	pm := deposit.Message
+	g.Mailbox.Lock()
+	defer g.Mailbox.Unlock()
+
+	if g.Mailbox.find(pm.Destination, pm.Origin, pm.ID) >= 0 {
+		return
+	}
+	g.Mailbox.Stored[pm.Destination] = append(g.Mailbox.Stored[pm.Destination], pm)
+	g.Mailbox.persist(&storage.Record{Deposit: &storage.DepositRecord{Message: pm}})
+	packet.PrintMailbox("STORED", pm.Origin, pm.Destination, pm.ID)
+}
+
+//acknowledgeDelivery sends a delivery ack for a private message received by the gossiper to its origin, and to the
+//mailbox peer that redelivered it. The messages without ID are not acknowledged.
+func (g *Gossiper) acknowledgeDelivery(pm *packet.PrivateMessage) {
+	if pm.ID == 0 {
+		return
+	}
+
+	recipients := []string{pm.Origin}
+	if pm.Mailbox != "" && pm.Mailbox != pm.Origin {
+		recipients = append(recipients, pm.Mailbox)
+	}
+
+	for _, recipient := range recipients {
+		ack := &packet.DeliveryAck{
+			Origin:      g.Name,
+			Destination: recipient,
+			Sender:      pm.Origin,
+			ID:          pm.ID,
+			HopLimit:    packet.MaxHops - 1,
+		}
+		helper.LogError(ack.Sign(g.Identity.SigningKey))
+
+		if nextHop := g.nextHop(recipient, nil); nextHop != nil {
+			g.sendMessage(&packet.GossipPacket{Delivery: ack}, nextHop)
+		}
+	}
+}
+
+//DeliveryAckRoutine handles the delivery acks coming from the peer at address from.
+//An ack for a message of the gossiper marks it as delivered, an ack for a message deposited at the gossiper removes
+//it from the mailbox. The other acks are forwarded like private messages.
+func (g *Gossiper) DeliveryAckRoutine(ack *packet.DeliveryAck, from net.Addr) {
+	if ack.Destination != g.Name {
+		if ack.HopLimit > 0 {
+			ack.HopLimit -= 1
+			if nextHop := g.nextHop(ack.Destination, from); nextHop != nil {
+				g.sendMessage(&packet.GossipPacket{Delivery: ack}, nextHop)
+			}
+		}
+		return
+	}
+
+	if ack.Sender == g.Name {
+		g.State.Mutex.Lock()
+		entry := g.State.FindPrivate(ack.Origin, g.Name, ack.ID)
+		delivered := entry != nil && entry.Status != PRIVATE_DELIVERED
+		if delivered {
+			g.State.SetPrivateStatus(ack.Origin, g.Name, ack.ID, PRIVATE_DELIVERED)
+		}
+		g.State.Mutex.Unlock()
+
+		g.Mailbox.Lock()
+		delete(g.Mailbox.Attempts, ack.ID)
+		delete(g.Mailbox.Deposited, ack.ID)
+		g.Mailbox.Unlock()
+
+		if delivered {
+			packet.PrintMailbox("DELIVERED", g.Name, ack.Origin, ack.ID)
+		}
+		return
+	}
+
+	g.Mailbox.Lock()
+	defer g.Mailbox.Unlock()
+
+	if pm := g.Mailbox.remove(ack.Origin, ack.Sender, ack.ID); pm != nil {
+		g.Mailbox.persist(&storage.Record{Deposit: &storage.DepositRecord{Message: *pm, Removed: true}})
+		packet.PrintMailbox("REDELIVERED", pm.Origin, pm.Destination, pm.ID)
+	}
+}
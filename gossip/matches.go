@@ -126,3 +126,21 @@ func (ms *Matches) GetOwners(fileName, metahash string, index uint64) []string {
 		return nil
 	}
 }
+
+//GetChunkMap retrieves a copy of the mapping of the index of each chunk to its owners for the given file name and metahash
+func (ms *Matches) GetChunkMap(fileName, metahash string) map[uint64][]string {
+	ms.RLock()
+	defer ms.RUnlock()
+	m := match{
+		FileName: fileName,
+		MetaHash: metahash,
+	}
+
+	chunkMap := make(map[uint64][]string)
+	if info, ok := ms.matches[m]; ok {
+		for index, owners := range info.chunkMap {
+			chunkMap[index] = append([]string{}, owners...)
+		}
+	}
+	return chunkMap
+}
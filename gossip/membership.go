@@ -0,0 +1,195 @@
+package gossip
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/identity"
+	"github.com/somecookie/Peerster/packet"
+	"strings"
+	"time"
+)
+
+//isMember checks if the gossiper is a member of TLC according to the longest chain
+func (g *Gossiper) isMember() bool {
+	return g.TLCMajority.Membership.IsMember(g.Name)
+}
+
+//parseFounders returns the founding members of TLC given in config.
+//Without founders, a gossiper that is the only founding member (N = 1) founds TLC alone. The founders are needed otherwise,
+//unless the files are published by mining, in which case TLC is not used.
+func parseFounders(config Config, id *identity.Identity) ([]blockchain.Founder, error) {
+	if len(config.Founders) == 0 {
+		if config.N == 1 && !config.Join || config.Miners > 0 {
+			return []blockchain.Founder{{Name: config.Name, PublicKey: id.PublicKey()}}, nil
+		}
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: "the founding members of TLC must be given when N > 1 or when joining",
+			Where:        "membership.go",
+		}
+	}
+
+	if len(config.Founders) != config.N {
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: fmt.Sprintf("%d founding members given instead of N = %d", len(config.Founders), config.N),
+			Where:        "membership.go",
+		}
+	}
+
+	founders := make([]blockchain.Founder, 0, len(config.Founders))
+	names := make(map[string]bool)
+	for _, founderStr := range config.Founders {
+		nameKey := strings.SplitN(founderStr, ":", 2)
+		founder := blockchain.Founder{Name: nameKey[0]}
+		if len(nameKey) == 2 {
+			key, err := hex.DecodeString(nameKey[1])
+			if err != nil || len(key) != ed25519.PublicKeySize {
+				return nil, &helper.IllegalArgumentError{
+					ErrorMessage: "invalid public key for the founder " + founder.Name,
+					Where:        "membership.go",
+				}
+			}
+			founder.PublicKey = key
+		}
+		if founder.Name == "" || names[founder.Name] {
+			return nil, &helper.IllegalArgumentError{
+				ErrorMessage: "invalid or duplicate founder " + founderStr,
+				Where:        "membership.go",
+			}
+		}
+		names[founder.Name] = true
+
+		if founder.Name == config.Name {
+			if founder.PublicKey != nil && !bytes.Equal(founder.PublicKey, id.PublicKey()) {
+				return nil, &helper.IllegalArgumentError{
+					ErrorMessage: "the key given for " + config.Name + " is not its own key",
+					Where:        "membership.go",
+				}
+			}
+			founder.PublicKey = id.PublicKey()
+		}
+		founders = append(founders, founder)
+	}
+
+	if names[config.Name] == config.Join {
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: "a joining gossiper cannot be a founder and the other gossipers must be founders",
+			Where:        "membership.go",
+		}
+	}
+	return founders, nil
+}
+
+//headChanged updates the registry and the membership from the longest chain, and takes a snapshot of them if it is time to.
+//It is called each time the head of the chain changes.
+func (g *Gossiper) headChanged() {
+	g.syncRegistry()
+	g.syncMembership()
+	g.snapshotLedger()
+}
+
+//syncMembership rebuilds the members of TLC from the longest chain and prints the membership if its size changed
+func (g *Gossiper) syncMembership() {
+	membership := g.TLCMajority.Membership
+	previous := membership.Size()
+	membership.Sync(g.Blockchain.Blocks())
+
+	if size := membership.Size(); size != previous {
+		joined, founders := membership.Members()
+		fmt.Printf("MEMBERSHIP size %d founders %d joined %s\n", size, founders, strings.Join(joined, ","))
+	}
+}
+
+//checkMembershipChange verifies that the change is signed by the member with the key known for it
+//and that it can be applied to the current membership
+func (g *Gossiper) checkMembershipChange(change *blockchain.MembershipChange) error {
+	if !change.Verify() || !g.KeyStore.Learn(change.Member, change.PublicKey) {
+		return &blockchain.InvalidMembershipChangeError{Change: *change, Reason: "invalid signature"}
+	}
+	return g.TLCMajority.Membership.Check(change)
+}
+
+//addChange adds a membership change or a file operation to the changes waiting to be proposed.
+//A member that stopped advancing proposes it.
+//It returns false if the change was already pending.
+func (g *Gossiper) addChange(tx blockchain.TxPublish) bool {
+	g.TLCMajority.Lock()
+	defer g.TLCMajority.Unlock()
+
+	hash := tx.Hash()
+	for _, pending := range g.TLCMajority.Changes {
+		if pending.Hash() == hash {
+			return false
+		}
+	}
+
+	g.TLCMajority.Changes = append(g.TLCMajority.Changes, tx)
+	g.rejoin()
+	return true
+}
+
+//pendingChange returns the first pending change that is still valid. The changes before it are dropped,
+//since they are already in the chain or can no longer be applied.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) pendingChange() (tx blockchain.TxPublish, ok bool) {
+	tm := g.TLCMajority
+	for len(tm.Changes) > 0 {
+		if g.checkTransaction(tm.Changes[0]) == nil {
+			return tm.Changes[0], true
+		}
+		tm.Changes = tm.Changes[1:]
+	}
+	return
+}
+
+//ChangeMembership asks the members of TLC to add the gossiper (join) or to remove it (leave).
+//The change is signed by the gossiper and gossiped to its peers, so that the members propose it.
+//It is gossiped again every stubbornTimeout seconds until it is in the chain.
+func (g *Gossiper) ChangeMembership(join bool) {
+	if g.ackAll || g.miners > 0 {
+		helper.LogError(&helper.IllegalArgumentError{
+			ErrorMessage: "the membership only changes when the files are published with TLC majorities",
+			Where:        "membership.go",
+		})
+		return
+	}
+
+	if join == g.isMember() {
+		helper.LogError(&helper.IllegalArgumentError{
+			ErrorMessage: "the membership change of " + g.Name + " has no effect",
+			Where:        "membership.go",
+		})
+		return
+	}
+
+	change := &blockchain.MembershipChange{
+		Member:   g.Name,
+		Join:     join,
+		Sequence: g.TLCMajority.Membership.Sequence(g.Name),
+	}
+	if err := g.TLCMajority.Membership.Check(change); err != nil {
+		helper.LogError(err)
+		return
+	}
+	if err := change.Sign(g.Identity.SigningKey); err != nil {
+		helper.LogError(err)
+		return
+	}
+	fmt.Printf("MEMBERSHIP REQUEST %s sequence %d\n", change, change.Sequence)
+
+	tx := blockchain.TxPublish{Membership: change}
+	ticker := g.clock.NewTicker(time.Duration(g.stubbornTimeout) * time.Second)
+	defer ticker.Stop()
+
+	for g.TLCMajority.Membership.Sequence(g.Name) == change.Sequence {
+		g.addChange(tx)
+		g.broadcast(&packet.GossipPacket{TxPublish: &packet.TxPublishMessage{
+			Transaction: tx,
+			HopLimit:    packet.TX_HOP_LIMIT,
+		}}, nil)
+		<-ticker.C()
+	}
+}
@@ -0,0 +1,161 @@
+package gossip
+
+import (
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/fileSharing"
+	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/packet"
+	"github.com/somecookie/Peerster/storage"
+	"net"
+)
+
+//MINING_ATTEMPTS is the number of nonces tried by a miner before checking if its work is stale.
+const MINING_ATTEMPTS = 1 << 12
+
+//StartMining starts the mining goroutines. It does nothing if the gossiper is not in mining mode.
+func (g *Gossiper) StartMining() {
+	for i := 0; i < g.miners; i++ {
+		go g.mineRoutine()
+	}
+}
+
+//mineRoutine mines blocks containing the pending transactions on top of the head of the chain.
+//It restarts each time the pending transactions or the head change, and waits when there is nothing to mine.
+func (g *Gossiper) mineRoutine() {
+	for {
+		txs, changed := g.Miner.Work()
+		head, _ := g.Blockchain.Head()
+
+		valid := g.Blockchain.FilterTransactions(txs)
+
+		if len(valid) == 0 {
+			<-changed
+			continue
+		}
+
+		block := &blockchain.Block{
+			PrevHash:     head,
+			Transactions: valid,
+		}
+
+	mining:
+		for {
+			select {
+			case <-changed:
+				break mining
+			default:
+			}
+
+			if block.Mine(g.Miner.Difficulty, MINING_ATTEMPTS) {
+				g.publishBlock(block)
+				break mining
+			}
+		}
+	}
+}
+
+//publishBlock adds a block mined by the gossiper to its chain and gossips it
+func (g *Gossiper) publishBlock(block *blockchain.Block) {
+	if _, err := g.Blockchain.Add(block); err != nil {
+		helper.LogError(err)
+		return
+	}
+
+	packet.PrintFoundBlock(block.Hash())
+	g.recordBlock(&storage.BlockRecord{Mined: block})
+	g.headChanged()
+	g.Miner.Prune(g.Blockchain.Ledger())
+	g.broadcast(&packet.GossipPacket{Block: &packet.BlockMessage{
+		Block:    *block,
+		HopLimit: packet.BLOCK_HOP_LIMIT,
+	}}, nil)
+}
+
+//publishTransaction adds the transaction of a newly indexed file to the pending transactions and gossips it
+func (g *Gossiper) publishTransaction(metadata *fileSharing.Metadata) {
+	g.submitTransaction(g.newPublish(metadata))
+}
+
+//submitTransaction adds a transaction of the gossiper to the pending transactions and gossips it
+func (g *Gossiper) submitTransaction(tx blockchain.TxPublish) {
+	if g.Miner.AddTransaction(tx) {
+		g.broadcast(&packet.GossipPacket{TxPublish: &packet.TxPublishMessage{
+			Transaction: tx,
+			HopLimit:    packet.TX_HOP_LIMIT,
+		}}, nil)
+	}
+}
+
+//TxPublishRoutine handles the transactions gossiped by the peers.
+//A new and valid transaction is added to the pending transactions (or, with TLC, the membership changes and
+//the file operations are added to the pending changes) and forwarded to the other peers.
+//The transactions that can only be mined are dropped if the gossiper does not mine.
+func (g *Gossiper) TxPublishRoutine(message *packet.TxPublishMessage, from net.Addr) {
+	tx := message.Transaction
+	if g.miners == 0 && !tx.IsMembershipChange() && !tx.IsFileOperation() {
+		return
+	}
+	if g.checkTransaction(tx) != nil {
+		return
+	}
+
+	if tx.IsMembershipChange() || tx.IsFileOperation() && g.miners == 0 {
+		if !g.addChange(tx) {
+			return
+		}
+	} else if !g.Miner.AddTransaction(tx) {
+		return
+	}
+
+	if message.HopLimit > 1 {
+		forwarded := *message
+		forwarded.HopLimit -= 1
+		g.broadcast(&packet.GossipPacket{TxPublish: &forwarded}, from)
+	}
+}
+
+//BlockRoutine handles the blocks gossiped by the peers.
+//A block with a valid proof of work extends the chain and is forwarded to the other peers.
+//The blocks are dropped if the gossiper does not mine: with TLC, the chain only grows with the blocks agreed by QSC.
+func (g *Gossiper) BlockRoutine(message *packet.BlockMessage, from net.Addr) {
+	if g.miners == 0 {
+		return
+	}
+
+	block := message.Block
+	if !block.CheckPoW(g.Miner.Difficulty) {
+		return
+	}
+
+	changed, err := g.Blockchain.Add(&block)
+	switch err.(type) {
+	case nil, *blockchain.UnknownParentError:
+		g.recordBlock(&storage.BlockRecord{Mined: &block})
+	default:
+		return
+	}
+
+	if changed {
+		g.headChanged()
+		g.Miner.Prune(g.Blockchain.Ledger())
+	}
+
+	if message.HopLimit > 1 {
+		forwarded := *message
+		forwarded.HopLimit -= 1
+		g.broadcast(&packet.GossipPacket{Block: &forwarded}, from)
+	}
+}
+
+//broadcast sends gossipPacket to all the peers except the peer at address except (which can be nil)
+func (g *Gossiper) broadcast(gossipPacket *packet.GossipPacket, except net.Addr) {
+	g.Peers.Mutex.RLock()
+	peers := g.Peers.PeersSetAsList()
+	g.Peers.Mutex.RUnlock()
+
+	for _, peer := range peers {
+		if except == nil || peer.String() != except.String() {
+			g.sendMessage(gossipPacket, peer)
+		}
+	}
+}
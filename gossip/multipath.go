@@ -0,0 +1,62 @@
+package gossip
+
+import (
+	"fmt"
+	"github.com/somecookie/Peerster/packet"
+	"net"
+	"time"
+)
+
+//nextHop returns the peer to which the point-to-point packets for destination are sent: the best candidate of the
+//router that is reachable according to the probes. It returns nil if there is no route to destination.
+//A forwarded packet is never sent back to the peer it came from, so from is not a candidate. from is nil for the
+//packets created by the gossiper.
+func (g *Gossiper) nextHop(destination string, from net.Addr) net.Addr {
+	candidates := make([]net.Addr, 0)
+	for _, candidate := range g.Router.Candidates(destination) {
+		if from == nil || candidate.String() != from.String() {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	nextHop := g.Health.Select(candidates)
+	if nextHop != nil && nextHop.String() != candidates[0].String() {
+		PrintFailover(destination, candidates[0], nextHop)
+	}
+	return nextHop
+}
+
+//ProbeRoutine probes every peer every g.probeTimer seconds. A probe that is still unanswered when the next one is
+//sent lowers the health score of the peer.
+func (g *Gossiper) ProbeRoutine() {
+	ticker := g.clock.NewTicker(g.probeTimer * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			g.Peers.Mutex.RLock()
+			peers := g.Peers.PeersSetAsList()
+			g.Peers.Mutex.RUnlock()
+
+			for _, peer := range peers {
+				probe := &packet.Probe{ID: g.Health.Probe(peer), Reply: false}
+				g.sendMessage(&packet.GossipPacket{Probe: probe}, peer)
+			}
+		}
+	}
+}
+
+//ProbeMessageRoutine answers the probes of the peers and records their answers to the probes of the gossiper
+func (g *Gossiper) ProbeMessageRoutine(probe *packet.Probe, from net.Addr) {
+	if probe.Reply {
+		g.Health.Answer(from, probe.ID)
+	} else {
+		g.sendMessage(&packet.GossipPacket{Probe: &packet.Probe{ID: probe.ID, Reply: true}}, from)
+	}
+}
+
+//PrintFailover prints the message "FAILOVER <peer_name> from <ip:port> to <ip:port>" when a packet is not sent to
+//the best next hop of its destination because it is unreachable
+func PrintFailover(destination string, best, nextHop net.Addr) {
+	fmt.Printf("FAILOVER %s from %s to %s\n", destination, best.String(), nextHop.String())
+}
@@ -0,0 +1,550 @@
+package gossip
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/identity"
+	"github.com/somecookie/Peerster/packet"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+//REPLY_ID_SIZE is the number of random bytes of the identifier of a reply block
+const REPLY_ID_SIZE = 8
+
+//ONION_MAX_HOPS is the maximal number of gossipers of a path (relays and end). The header has one slot per hop, so
+//that its size reveals neither the length of the path nor the position of a hop on it.
+//ONION_LAYER_SIZE is the size of the padded plaintext of a layer of the header
+//ONION_SLOT_SIZE is the size of an encrypted layer: the ephemeral key, the nonce and the ciphertext
+//ONION_HEADER_SIZE is the size of every header
+//ONION_PAYLOAD_SIZE is the size of the padded plaintext of every payload, so that all the payloads have the same size
+//ONION_CELL_SIZE is the size of every payload once sealed
+const (
+	ONION_MAX_HOPS     = 8
+	ONION_LAYER_SIZE   = 256
+	ONION_SLOT_SIZE    = identity.X25519_KEY_SIZE + identity.NONCE_SIZE + ONION_LAYER_SIZE + identity.TAG_SIZE
+	ONION_HEADER_SIZE  = ONION_MAX_HOPS * ONION_SLOT_SIZE
+	ONION_PAYLOAD_SIZE = 4096
+	ONION_CELL_SIZE    = identity.NONCE_SIZE + ONION_PAYLOAD_SIZE + identity.TAG_SIZE
+)
+
+//REPLY_TTL is the time after which an unused reply block is dropped
+//ONION_REPLAY_CACHE is the number of processed onion headers remembered to drop the replayed onions
+const (
+	REPLY_TTL          = 10 * time.Minute
+	ONION_REPLAY_CACHE = 4096
+)
+
+//OnionState contains the single-use reply blocks of the anonymous messages and the headers of the processed onions.
+//The operations on it are thread-safe.
+//Created  map[string]*CreatedReply are the reply blocks attached by the gossiper to its anonymous messages, by ID
+//Received map[string]*ReceivedReply are the reply blocks attached to the anonymous messages received by the gossiper,
+//by ID. The client answers an anonymous message with the ID of its reply block.
+//Seen     map[string]bool are the ephemeral keys of the processed headers, so that a replayed onion cannot be followed
+//along its path. Only the last ONION_REPLAY_CACHE keys are kept, in the order of Processed.
+type OnionState struct {
+	sync.Mutex
+	Created   map[string]*CreatedReply
+	Received  map[string]*ReceivedReply
+	Seen      map[string]bool
+	Processed []string
+}
+
+//CreatedReply is what the creator of a reply block needs to read the reply
+//Destination string is the name of the gossiper to which the reply block was sent
+//Keys        [][]byte are the keys with which the relays of the reply path scramble the reply
+//Key         []byte is the key with which the reply is sealed
+//Expires     time.Time is the time after which the reply is not expected anymore
+type CreatedReply struct {
+	Destination string
+	Keys        [][]byte
+	Key         []byte
+	Expires     time.Time
+}
+
+//ReceivedReply is a reply block attached to an anonymous message received by the gossiper
+//Block   *packet.ReplyBlock is the reply block
+//Expires time.Time is the time after which the reply block cannot be used anymore
+type ReceivedReply struct {
+	Block   *packet.ReplyBlock
+	Expires time.Time
+}
+
+func OnionStateFactory() *OnionState {
+	return &OnionState{
+		Mutex:     sync.Mutex{},
+		Created:   make(map[string]*CreatedReply),
+		Received:  make(map[string]*ReceivedReply),
+		Seen:      make(map[string]bool),
+		Processed: make([]string, 0, ONION_REPLAY_CACHE),
+	}
+}
+
+//FirstSeen records the ephemeral key of a header and returns false if it was already processed.
+//The oldest key is forgotten when the cache is full.
+func (o *OnionState) FirstSeen(ephemeralKey []byte) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	key := hex.EncodeToString(ephemeralKey)
+	if o.Seen[key] {
+		return false
+	}
+
+	if len(o.Processed) >= ONION_REPLAY_CACHE {
+		delete(o.Seen, o.Processed[0])
+		o.Processed = o.Processed[1:]
+	}
+	o.Seen[key] = true
+	o.Processed = append(o.Processed, key)
+	return true
+}
+
+//Expire drops the reply blocks that expired before now
+func (o *OnionState) Expire(now time.Time) {
+	o.Lock()
+	defer o.Unlock()
+
+	for ID, created := range o.Created {
+		if now.After(created.Expires) {
+			delete(o.Created, ID)
+		}
+	}
+	for ID, received := range o.Received {
+		if now.After(received.Expires) {
+			delete(o.Received, ID)
+		}
+	}
+}
+
+//pickRelays picks at random up to g.onionRelays (and less than ONION_MAX_HOPS) known origins whose encryption key is
+//known, except the gossiper and the given destination. The relays of a path are all different.
+func (g *Gossiper) pickRelays(destination string) []string {
+	candidates := make([]string, 0)
+	for _, origin := range g.Router.GetOrigins() {
+		if _, ok := g.KeyStore.GetEncryptionKey(origin); ok && origin != g.Name && origin != destination {
+			candidates = append(candidates, origin)
+		}
+	}
+
+	sort.Strings(candidates)
+	relays := make([]string, 0, g.onionRelays)
+	for _, i := range g.randomPerm(len(candidates)) {
+		if len(relays) == g.onionRelays || len(relays) == ONION_MAX_HOPS-1 {
+			break
+		}
+		relays = append(relays, candidates[i])
+	}
+	return relays
+}
+
+//wrapHeader builds the header of an onion going through relays, then to end. Each relay learns the next gossiper
+//of the path, the key with which it scrambles the payload and the key with which it scrambles the rest of the header.
+//last is the layer of end.
+//The header received by a hop is its slot followed by the header of the next hop scrambled with the header key of the
+//hop, without its last slot. The hop drops its slot and appends a random one (see forwardHeader), so the header keeps
+//the same size along the path. It is built from the end, whose slot is followed by random bytes.
+//It returns the header for the first relay (or for end if there is no relay) and the keys of the relays.
+func (g *Gossiper) wrapHeader(relays []string, end string, last *packet.OnionLayer) ([]byte, [][]byte, error) {
+	if len(relays)+1 > ONION_MAX_HOPS {
+		return nil, nil, &helper.IllegalArgumentError{
+			ErrorMessage: "too many relays for an onion path",
+			Where:        "onion.go",
+		}
+	}
+
+	slot, err := g.encryptSlot(end, last)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := make([]byte, ONION_HEADER_SIZE)
+	if _, err := rand.Read(header[ONION_SLOT_SIZE:]); err != nil {
+		return nil, nil, err
+	}
+	copy(header, slot)
+
+	keys := make([][]byte, len(relays))
+	for i := len(relays) - 1; i >= 0; i-- {
+		next := end
+		if i < len(relays)-1 {
+			next = relays[i+1]
+		}
+
+		keys[i], err = identity.NewSymmetricKey()
+		if err != nil {
+			return nil, nil, err
+		}
+		headerKey, err := identity.NewSymmetricKey()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		slot, err := g.encryptSlot(relays[i], &packet.OnionLayer{Next: next, Key: keys[i], HeaderKey: headerKey})
+		if err != nil {
+			return nil, nil, err
+		}
+		scrambled, err := identity.Scramble(headerKey, header)
+		if err != nil {
+			return nil, nil, err
+		}
+		header = append(slot, scrambled[:ONION_HEADER_SIZE-ONION_SLOT_SIZE]...)
+	}
+	return header, keys, nil
+}
+
+//forwardHeader returns the header for the next gossiper of the path: the slot of the gossiper is dropped, a random slot
+//is appended and the result is scrambled with headerKey
+func forwardHeader(headerKey, header []byte) ([]byte, error) {
+	next := make([]byte, ONION_HEADER_SIZE)
+	copy(next, header[ONION_SLOT_SIZE:])
+	if _, err := rand.Read(next[ONION_HEADER_SIZE-ONION_SLOT_SIZE:]); err != nil {
+		return nil, err
+	}
+	return identity.Scramble(headerKey, next)
+}
+
+//encryptSlot pads the layer to ONION_LAYER_SIZE and encrypts it for the gossiper called name
+func (g *Gossiper) encryptSlot(name string, layer *packet.OnionLayer) ([]byte, error) {
+	key, ok := g.KeyStore.GetEncryptionKey(name)
+	if !ok {
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: "unknown encryption key for " + name,
+			Where:        "onion.go",
+		}
+	}
+
+	layerBytes, err := packet.GetPacketBytes(layer)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := padCell(layerBytes, ONION_LAYER_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, nonce, ciphertext, err := identity.Encrypt(key, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	slot := append(append(ephemeral, nonce...), ciphertext...)
+	if len(slot) != ONION_SLOT_SIZE {
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: "unexpected size of an onion slot",
+			Where:        "onion.go",
+		}
+	}
+	return slot, nil
+}
+
+//decryptSlot decrypts the first slot of the header, which is the layer of the gossiper
+func (g *Gossiper) decryptSlot(header []byte) (*packet.OnionLayer, error) {
+	ephemeral := header[:identity.X25519_KEY_SIZE]
+	nonce := header[identity.X25519_KEY_SIZE : identity.X25519_KEY_SIZE+identity.NONCE_SIZE]
+	ciphertext := header[identity.X25519_KEY_SIZE+identity.NONCE_SIZE : ONION_SLOT_SIZE]
+
+	plaintext, err := g.Identity.Decrypt(ephemeral, nonce, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	layerBytes, err := unpadCell(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return packet.GetOnionLayer(layerBytes)
+}
+
+//padCell prefixes data with its length on 4 bytes (big endian) and pads it with zeros to size bytes
+func padCell(data []byte, size int) ([]byte, error) {
+	if len(data)+4 > size {
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: fmt.Sprintf("%d bytes do not fit in an onion cell of %d bytes", len(data), size),
+			Where:        "onion.go",
+		}
+	}
+
+	padded := make([]byte, size)
+	binary.BigEndian.PutUint32(padded, uint32(len(data)))
+	copy(padded[4:], data)
+	return padded, nil
+}
+
+//unpadCell returns the data padded by padCell
+func unpadCell(padded []byte) ([]byte, error) {
+	if len(padded) < 4 || uint64(binary.BigEndian.Uint32(padded)) > uint64(len(padded)-4) {
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: "malformed onion cell",
+			Where:        "onion.go",
+		}
+	}
+	return padded[4 : 4+binary.BigEndian.Uint32(padded)], nil
+}
+
+//createReplyBlock creates a single-use reply block through a new path of relays back to the gossiper, for the
+//anonymous message sent to destination
+func (g *Gossiper) createReplyBlock(destination string) (*packet.ReplyBlock, error) {
+	relays := g.pickRelays(destination)
+	if len(relays) == 0 {
+		return nil, &NoRelayError{Destination: destination}
+	}
+
+	idBytes := make([]byte, REPLY_ID_SIZE)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	ID := hex.EncodeToString(idBytes)
+
+	header, keys, err := g.wrapHeader(relays, g.Name, &packet.OnionLayer{ReplyID: ID})
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := identity.NewSymmetricKey()
+	if err != nil {
+		return nil, err
+	}
+
+	g.Onions.Lock()
+	g.Onions.Created[ID] = &CreatedReply{Destination: destination, Keys: keys, Key: key, Expires: g.clock.Now().Add(REPLY_TTL)}
+	g.Onions.Unlock()
+
+	return &packet.ReplyBlock{First: relays[0], Header: header, Key: key}, nil
+}
+
+//startAnonymous sends the text of the message to message.Destination through a path of relays, with a reply block
+//through another path. The destination does not learn who the sender is and each relay only learns the previous
+//and the next gossipers of the path.
+func (g *Gossiper) startAnonymous(message *packet.Message) {
+	destination := *message.Destination
+
+	relays := g.pickRelays(destination)
+	if len(relays) == 0 {
+		helper.LogError(&NoRelayError{Destination: destination})
+		return
+	}
+
+	reply, err := g.createReplyBlock(destination)
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
+
+	key, err := identity.NewSymmetricKey()
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
+
+	header, keys, err := g.wrapHeader(relays, destination, &packet.OnionLayer{Key: key})
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
+
+	payload, err := sealAnonymous(key, &packet.AnonymousMessage{Text: message.Text, Reply: reply})
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
+	for _, relayKey := range keys {
+		if payload, err = identity.Scramble(relayKey, payload); err != nil {
+			helper.LogError(err)
+			return
+		}
+	}
+
+	g.sendOnion(&packet.OnionMessage{
+		Destination: relays[0],
+		HopLimit:    packet.MaxHops - 1,
+		Header:      header,
+		Payload:     payload,
+	}, nil)
+}
+
+//replyAnonymous answers the anonymous message whose reply block has the ID message.Reply. The reply block is
+//deleted: it can only be used once.
+func (g *Gossiper) replyAnonymous(message *packet.Message) {
+	g.Onions.Expire(g.clock.Now())
+
+	g.Onions.Lock()
+	received, ok := g.Onions.Received[*message.Reply]
+	delete(g.Onions.Received, *message.Reply)
+	g.Onions.Unlock()
+
+	if !ok {
+		helper.LogError(&helper.IllegalArgumentError{
+			ErrorMessage: "unknown, expired or already used reply block " + *message.Reply,
+			Where:        "onion.go",
+		})
+		return
+	}
+	reply := received.Block
+
+	payload, err := sealAnonymous(reply.Key, &packet.AnonymousMessage{Text: message.Text})
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
+
+	g.sendOnion(&packet.OnionMessage{
+		Destination: reply.First,
+		HopLimit:    packet.MaxHops - 1,
+		Header:      reply.Header,
+		Payload:     payload,
+	}, nil)
+}
+
+//sealAnonymous serializes the message, pads it to ONION_PAYLOAD_SIZE and seals it with key
+func sealAnonymous(key []byte, message *packet.AnonymousMessage) ([]byte, error) {
+	messageBytes, err := packet.GetPacketBytes(message)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := padCell(messageBytes, ONION_PAYLOAD_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	return identity.Seal(key, plaintext)
+}
+
+//sendOnion sends the onion message to the next hop towards its destination.
+//from is the peer from which the message arrived, nil if the gossiper is on the path.
+func (g *Gossiper) sendOnion(onion *packet.OnionMessage, from net.Addr) {
+	if nextHop := g.nextHop(onion.Destination, from); nextHop != nil {
+		g.sendMessage(&packet.GossipPacket{Onion: onion}, nextHop)
+	}
+}
+
+//OnionMessageRoutine handles the onion messages coming from the peer at address from.
+//If the gossiper is not the destination, the message is forwarded like a private message. Otherwise the gossiper
+//decrypts its layer of the header: a relay scrambles the payload with its key and sends the message to the next
+//gossiper of the path, the end of the path opens the payload. A header that was already processed is dropped, so that
+//a replayed onion cannot be followed hop by hop. The headers and the payloads that do not have the fixed sizes are dropped.
+func (g *Gossiper) OnionMessageRoutine(onion *packet.OnionMessage, from net.Addr) {
+	if onion.Destination != g.Name {
+		if onion.HopLimit > 0 {
+			onion.HopLimit -= 1
+			g.sendOnion(onion, from)
+		}
+		return
+	}
+
+	if len(onion.Header) != ONION_HEADER_SIZE || len(onion.Payload) != ONION_CELL_SIZE {
+		return
+	}
+	layer, err := g.decryptSlot(onion.Header)
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
+	if !g.Onions.FirstSeen(onion.Header[:identity.X25519_KEY_SIZE]) {
+		return
+	}
+
+	if layer.Next != "" {
+		header, err := forwardHeader(layer.HeaderKey, onion.Header)
+		if err != nil {
+			helper.LogError(err)
+			return
+		}
+		payload, err := identity.Scramble(layer.Key, onion.Payload)
+		if err != nil {
+			helper.LogError(err)
+			return
+		}
+
+		packet.PrintOnionRelay(from, layer.Next)
+		g.sendOnion(&packet.OnionMessage{
+			Destination: layer.Next,
+			HopLimit:    packet.MaxHops - 1,
+			Header:      header,
+			Payload:     payload,
+		}, nil)
+	} else if layer.ReplyID != "" {
+		g.receiveReply(layer.ReplyID, onion.Payload)
+	} else {
+		g.receiveAnonymous(layer.Key, onion.Payload)
+	}
+}
+
+//receiveAnonymous opens an anonymous message whose destination is the gossiper and keeps its reply block for REPLY_TTL
+func (g *Gossiper) receiveAnonymous(key, payload []byte) {
+	g.Onions.Expire(g.clock.Now())
+
+	message, err := openAnonymous(key, payload)
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
+
+	replyID := ""
+	if message.Reply != nil {
+		idBytes := make([]byte, REPLY_ID_SIZE)
+		if _, err := rand.Read(idBytes); err != nil {
+			helper.LogError(err)
+			return
+		}
+		replyID = hex.EncodeToString(idBytes)
+
+		g.Onions.Lock()
+		g.Onions.Received[replyID] = &ReceivedReply{Block: message.Reply, Expires: g.clock.Now().Add(REPLY_TTL)}
+		g.Onions.Unlock()
+	}
+
+	packet.PrintAnonymousMessage(message.Text, replyID)
+}
+
+//receiveReply opens the reply to an anonymous message of the gossiper. The reply block is deleted: a second reply
+//with the same block is dropped, and so is a reply arriving after REPLY_TTL.
+func (g *Gossiper) receiveReply(ID string, payload []byte) {
+	g.Onions.Expire(g.clock.Now())
+	g.Onions.Lock()
+	created, ok := g.Onions.Created[ID]
+	delete(g.Onions.Created, ID)
+	g.Onions.Unlock()
+
+	if !ok {
+		return
+	}
+
+	var err error
+	for _, key := range created.Keys {
+		if payload, err = identity.Scramble(key, payload); err != nil {
+			helper.LogError(err)
+			return
+		}
+	}
+
+	message, err := openAnonymous(created.Key, payload)
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
+	packet.PrintAnonymousReply(created.Destination, message.Text)
+}
+
+//openAnonymous opens the payload sealed with key and removes its padding
+func openAnonymous(key, payload []byte) (*packet.AnonymousMessage, error) {
+	plaintext, err := identity.Open(key, payload)
+	if err != nil {
+		return nil, err
+	}
+	messageBytes, err := unpadCell(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return packet.GetAnonymousMessage(messageBytes)
+}
+
+//NoRelayError is returned when an anonymous message cannot be sent because no relay is known
+type NoRelayError struct {
+	Destination string
+}
+
+func (e *NoRelayError) Error() string {
+	return fmt.Sprintf("no relay to send an anonymous message to %s", e.Destination)
+}
@@ -2,15 +2,15 @@ package gossip
 
 import (
 	"fmt"
-	"math/rand"
 	"net"
+	"sort"
 	"sync"
 )
 
-//PeerSet is a set of *net.UDPAddr that corresponds to the addresses of known peers of some gossiper.
+//PeerSet is a set of net.Addr that corresponds to the addresses of known peers of some gossiper.
 //All methods on the PeerSet are not thread-safe. You should use the Mutex that is link to it.
 type PeersSet struct {
-	Set   map[string]*net.UDPAddr
+	Set   map[string]net.Addr
 	Mutex sync.RWMutex
 }
 
@@ -29,53 +29,55 @@ func (ps *PeersSet) String() string {
 }
 
 //Contains checks if an address is in the set
-func (ps *PeersSet) Contains(peerAddr *net.UDPAddr) bool {
+func (ps *PeersSet) Contains(peerAddr net.Addr) bool {
 	_, ok := ps.Set[peerAddr.String()]
 	return ok
 }
 
 //Add adds new address to the set
-func (ps *PeersSet) Add(peerAddr *net.UDPAddr) {
+func (ps *PeersSet) Add(peerAddr net.Addr) {
 	ps.Set[peerAddr.String()] = peerAddr
 }
 
 //selectPeerAtRandom selects a peer from the Peers map.
+//random func(n int) int draws a random integer in [0, n)
 //It returns the key and the value
-func (ps *PeersSet) Random() *net.UDPAddr {
+func (ps *PeersSet) Random(random func(n int) int) net.Addr {
 
 	if len(ps.Set) == 0 {
 		return nil
 	}
 
-	r := rand.Intn(len(ps.Set))
+	return ps.sorted()[random(len(ps.Set))]
+}
 
-	for _,addr := range ps.Set {
-		if r == 0 {
-			return addr
-		} else {
-			r -= 1
-		}
+//sorted returns the peers sorted by address, so that the random choices only depend on the random source
+func (ps *PeersSet) sorted() []net.Addr {
+	peers := make([]net.Addr, 0, len(ps.Set))
+	for _, addr := range ps.Set {
+		peers = append(peers, addr)
 	}
-	return nil
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].String() < peers[j].String()
+	})
+	return peers
 }
 
 //NRandom chooses n random peers from the list of peers
+//permutation func(n int) []int draws a random permutation of [0, n)
 //n uint64 is the number of peers selected at random
-//exceptions ... *net.UDPAddr contains the peers that should not be chosen
+//exceptions ... net.Addr contains the peers that should not be chosen
 //returns n randomly drawn peers, if the total number of peers is bigger than n, it returns all peers
-func (ps *PeersSet)NRandom(n uint64, exceptions ... *net.UDPAddr) []*net.UDPAddr{
+func (ps *PeersSet)NRandom(permutation func(n int) []int, n uint64, exceptions ... net.Addr) []net.Addr{
 
-	subset := make([]*net.UDPAddr, 0, len(ps.Set))
-	for _,v := range ps.Set{
-		subset = append(subset, v)
-	}
+	subset := ps.sorted()
 
 	if n > uint64(len(ps.Set)){
 		return subset
 	}
 
-	perm := rand.Perm(len(ps.Set))
-	result := make([]*net.UDPAddr,0,n)
+	perm := permutation(len(ps.Set))
+	result := make([]net.Addr,0,n)
 
 	j := uint64(1)
 	for _, i := range perm{
@@ -93,9 +95,9 @@ func (ps *PeersSet)NRandom(n uint64, exceptions ... *net.UDPAddr) []*net.UDPAddr
 	return result
 }
 //isExcepted is an helper function used to know if addr is in the list of exception
-//addr *net.UDPAddr is the address of the peer
-//exceptions []*net.UDPAddr is the list of exceptions
-func isExcepted(addr *net.UDPAddr, exceptions []*net.UDPAddr) bool{
+//addr net.Addr is the address of the peer
+//exceptions []net.Addr is the list of exceptions
+func isExcepted(addr net.Addr, exceptions []net.Addr) bool{
 	for _, ex := range exceptions{
 		if addr.String() == ex.String(){
 			return true
@@ -106,9 +108,9 @@ func isExcepted(addr *net.UDPAddr, exceptions []*net.UDPAddr) bool{
 }
 
 
-//PeersSetAsList returns the values of the PeersSet as a list of *net.UDPAddr
-func (ps* PeersSet) PeersSetAsList() []*net.UDPAddr{
-	ls := make([]*net.UDPAddr, 0, len(ps.Set))
+//PeersSetAsList returns the values of the PeersSet as a list of net.Addr
+func (ps* PeersSet) PeersSetAsList() []net.Addr{
+	ls := make([]net.Addr, 0, len(ps.Set))
 	for _,addr := range ps.Set{
 		ls = append(ls, addr)
 	}
@@ -0,0 +1,207 @@
+package gossip
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/helper"
+)
+
+//QSC_STEPS is the number of TLC rounds of one instance of Que Sera Consensus.
+//In the first round s each node proposes a block with a random fitness. In the rounds s+1 and s+2,
+//each node proposes again the best block it has seen so far.
+const QSC_STEPS = 3
+
+//better checks if the proposal c has a higher fitness than other.
+//On a tie, the block with the smallest hash wins so that all the nodes rank the proposals in the same order.
+func (c Confirmation) better(other Confirmation) bool {
+	if c.Fitness != other.Fitness {
+		return c.Fitness > other.Fitness
+	}
+	h, otherH := c.TxBlock.Hash(), other.TxBlock.Hash()
+	return bytes.Compare(h[:], otherH[:]) < 0
+}
+
+//same checks if c and other propose the same block with the same fitness
+func (c Confirmation) same(other Confirmation) bool {
+	return c.Fitness == other.Fitness && c.TxBlock.Hash() == other.TxBlock.Hash()
+}
+
+//bestOf returns the best proposal among the confirmed messages of a round. ok is false if there are none.
+func bestOf(confirmed []Confirmation) (best Confirmation, ok bool) {
+	for _, c := range confirmed {
+		if !ok || c.better(best) {
+			best, ok = c, true
+		}
+	}
+	return
+}
+
+//qscStep is called when the gossiper leaves the given round. It must be called with the lock of TLCMajority held.
+//In the first two rounds of an instance, the best proposal seen so far is proposed again.
+//After the last round, the gossiper starts the next instance.
+func (g *Gossiper) qscStep(round uint32) {
+	if best, ok := g.qscRecord(round); ok && round%QSC_STEPS != QSC_STEPS-1 {
+		g.broadcastTLC(best.TxBlock, best.Fitness)
+	} else {
+		g.proposeNext()
+	}
+}
+
+//qscRecord records the best proposal seen during the instance when the gossiper leaves the given round.
+//After the last round of an instance, the gossiper decides. It must be called with the lock of TLCMajority held.
+func (g *Gossiper) qscRecord(round uint32) (best Confirmation, ok bool) {
+	tm := g.TLCMajority
+
+	best, ok = bestOf(tm.Confirmed[round])
+	if round > 0 && round%QSC_STEPS != 0 {
+		if previous, seen := tm.Best[round-1]; seen && (!ok || previous.better(best)) {
+			best, ok = previous, true
+		}
+	}
+	if ok {
+		tm.Best[round] = best
+	}
+
+	if round%QSC_STEPS == QSC_STEPS-1 {
+		g.decide(round + 1 - QSC_STEPS)
+	}
+	return
+}
+
+//decide ends the instance that started at round s. The best proposal seen during the instance is adopted:
+//its block is added to the chain so that the next proposals build upon it.
+//The block is committed if it is confirmed (a majority witnessed it in round s+1) and unique (it was already
+//the best proposal in round s, so no better proposal could be adopted by another node).
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) decide(s uint32) {
+	tm := g.TLCMajority
+
+	best, ok := tm.Best[s+QSC_STEPS-1]
+	if !ok {
+		return
+	}
+	g.adoptBlock(best.TxBlock)
+
+	first, ok := tm.Best[s]
+	if !ok || !first.same(best) {
+		return
+	}
+
+	confirmed := false
+	for _, c := range tm.Confirmed[s+1] {
+		if c.same(best) {
+			confirmed = true
+		}
+	}
+	if !confirmed {
+		return
+	}
+
+	fmt.Printf("CONSENSUS ON QSC round %d message origin %s ID %d file names %s size %d metahash %s\n",
+		s, best.Origin, best.ID, best.TxBlock.Transaction.Name, best.TxBlock.Transaction.Size,
+		hex.EncodeToString(best.TxBlock.Transaction.MetafileHash))
+}
+
+//proposeNext starts a new instance with the proposal of the gossiper if it is not committed yet,
+//otherwise with the next file of the queue. If the gossiper has nothing to publish, it proposes a pending membership change or file operation
+//or it helps the other nodes by proposing again a transaction of a confirmed message that is not in the chain yet,
+//so that a majority keeps advancing.
+//If there is nothing to propose or if the gossiper is not a member anymore, the gossiper stops advancing.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) proposeNext() {
+	tm := g.TLCMajority
+
+	if !g.isMember() {
+		tm.ReicvCommand = false
+		return
+	}
+
+	//files indexed while the gossiper was helping the other nodes are waiting in the queue
+	if tm.Proposal == nil {
+		tm.Proposal = tm.Queue.Dequeue()
+	}
+
+	for tm.Proposal != nil {
+		if err := g.checkTransaction(g.newPublish(tm.Proposal)); err == nil {
+			g.BroadcastNewFile(tm.Proposal)
+			return
+		} else if ledger := g.Blockchain.Ledger(); !bytes.Equal(ledger[tm.Proposal.Name].MetafileHash, tm.Proposal.MetaHash) {
+			helper.LogError(err)
+		}
+		tm.Proposal = tm.Queue.Dequeue()
+	}
+
+	tx, ok := g.pendingChange()
+	if !ok {
+		tx, ok = g.uncommitted()
+	}
+	if ok {
+		head, _ := g.Blockchain.Head()
+		g.broadcastTLC(blockchain.BlockPublish{PrevHash: head, Transaction: tx}, g.randomFitness())
+		return
+	}
+	tm.ReicvCommand = false
+}
+
+//rejoin makes a member that stopped advancing propose again if it has files waiting in the queue,
+//if a membership change is pending or if a confirmed transaction is not in the chain yet.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) rejoin() {
+	tm := g.TLCMajority
+	if tm.ReicvCommand || !g.isMember() {
+		return
+	}
+
+	_, pending := g.pendingChange()
+	_, confirmed := g.uncommitted()
+	if tm.Proposal != nil || tm.Queue.Size() > 0 || pending || confirmed {
+		g.TLCMajority.ReicvCommand = true
+		g.proposeNext()
+	}
+}
+
+//uncommitted returns a transaction of the confirmed messages that is not in the chain yet.
+//The transaction with the smallest hash is chosen, so that the helping nodes propose the same one.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) uncommitted() (tx blockchain.TxPublish, ok bool) {
+	var smallest [32]byte
+	for _, block := range g.TLCMajority.Candidates {
+		if g.checkTransaction(block.Transaction) != nil {
+			continue
+		}
+
+		hash := block.Transaction.Hash()
+		if !ok || bytes.Compare(hash[:], smallest[:]) < 0 {
+			tx, smallest, ok = block.Transaction, hash, true
+		}
+	}
+	return
+}
+
+//adoptBlock adds the block to the chain, after the candidate blocks it builds upon.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) adoptBlock(block blockchain.BlockPublish) {
+	g.adoptAncestors(block.PrevHash)
+	g.addBlock(block)
+}
+
+//adoptAncestors adds to the chain the candidate blocks that are missing to reach the block with the given hash.
+//A node that did not see the end of an instance learns the adopted block when the proposals of the
+//next instances are built upon it. It must be called with the lock of TLCMajority held.
+func (g *Gossiper) adoptAncestors(hash [32]byte) {
+	missing := make([]blockchain.BlockPublish, 0)
+	for hash != [32]byte{} && !g.Blockchain.Contains(hash) {
+		block, ok := g.TLCMajority.Candidates[hash]
+		if !ok {
+			return
+		}
+		missing = append(missing, block)
+		hash = block.PrevHash
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		g.addBlock(missing[i])
+	}
+}
@@ -0,0 +1,103 @@
+package gossip
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/fileSharing"
+	"github.com/somecookie/Peerster/helper"
+	"net"
+	"strings"
+)
+
+//claimName registers the name of the transaction of a confirmed TLC message for its origin.
+//A claim of a name that is already registered for another file is reported and ignored.
+//Membership changes claim no name, and the operations on published files only change the registry once they are in the chain.
+func (g *Gossiper) claimName(tx blockchain.TxPublish, origin string) {
+	if tx.IsMembershipChange() || tx.IsFileOperation() {
+		return
+	}
+	helper.LogError(g.Registry.Claim(tx, origin))
+}
+
+//syncRegistry registers the names of the longest chain. It is called each time the head of the chain changes.
+func (g *Gossiper) syncRegistry() {
+	g.Registry.Sync(g.Blockchain.Ledger())
+}
+
+//checkTransaction verifies that the transaction can be applied to the ledger of the chain, that it is signed with the key
+//known for its owner and that it does not use a name claimed for another file.
+//A membership change is checked against the membership instead.
+func (g *Gossiper) checkTransaction(tx blockchain.TxPublish) error {
+	if tx.IsMembershipChange() {
+		return g.checkMembershipChange(tx.Membership)
+	}
+	if err := g.Blockchain.CheckTransaction(tx); err != nil {
+		return err
+	}
+	if !g.KeyStore.Learn(tx.Owner, tx.PublicKey) {
+		return &blockchain.InvalidTransactionError{Tx: tx, Reason: "another key is known for " + tx.Owner}
+	}
+	if key, ok := g.KeyStore.Get(tx.NewOwner); tx.Type == blockchain.TX_TRANSFER && ok && !bytes.Equal(key, tx.NewOwnerKey) {
+		return &blockchain.InvalidTransactionError{Tx: tx, Reason: "another key is known for " + tx.NewOwner}
+	}
+	return g.Registry.Check(tx)
+}
+
+//newPublish creates the transaction publishing the file, signed by the gossiper.
+//It follows the last transaction on the name if the name was already used in the chain.
+func (g *Gossiper) newPublish(metadata *fileSharing.Metadata) blockchain.TxPublish {
+	tx := blockchain.TxPublish{
+		Name:         metadata.Name,
+		Size:         int64(metadata.Size),
+		MetafileHash: metadata.MetaHash,
+		Type:         blockchain.TX_PUBLISH,
+		Owner:        g.Name,
+	}
+	if record, ok := g.Blockchain.Lookup(metadata.Name); ok {
+		tx.Previous = record.Last
+	}
+	helper.LogError(tx.Sign(g.Identity.SigningKey))
+	return tx
+}
+
+//resolveFile returns the current name of the file called name with the given metahash, following its renames in the chain.
+//ok is false if the file was unpublished. A file that is not in the chain keeps its name.
+func (g *Gossiper) resolveFile(name string, metaHash []byte) (string, bool) {
+	record, ok := g.Blockchain.Resolve(name)
+	if !ok || !bytes.Equal(record.MetafileHash, metaHash) {
+		return name, true
+	}
+	return record.Name, !record.Unpublished
+}
+
+//HandleRegistryCommand returns the registrations of the given names (comma separated), or all the registrations if
+//names is "all". There is one line per registration in the format of blockchain.Registration.
+func (g *Gossiper) HandleRegistryCommand(names string) string {
+	lines := make([]string, 0)
+	if names == "all" {
+		for _, registration := range g.Registry.Registrations() {
+			lines = append(lines, registration.String())
+		}
+		if len(lines) == 0 {
+			return "NO REGISTRATION"
+		}
+	} else {
+		for _, name := range strings.Split(names, ",") {
+			if registration, ok := g.Registry.Lookup(name); ok {
+				lines = append(lines, registration.String())
+			} else {
+				lines = append(lines, "NOT REGISTERED "+name)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+//replyRegistryCommand answers the registry query of the client
+func (g *Gossiper) replyRegistryCommand(names string, clientAddr net.Addr) {
+	result := g.HandleRegistryCommand(names)
+	fmt.Println(result)
+
+	helper.LogError(g.clientTransport.Send([]byte(result), clientAddr))
+}
@@ -0,0 +1,211 @@
+package gossip
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/somecookie/Peerster/fileSharing"
+	"github.com/somecookie/Peerster/helper"
+	"sort"
+	"strings"
+)
+
+//ResumeDownloads detects the partial downloads left in fileSharing.PATH_DOWNLOADS by a previous run
+//and resumes them from their missing chunks. Paused downloads are loaded but not resumed.
+func (g *Gossiper) ResumeDownloads() {
+	downloads, err := fileSharing.LoadPartialDownloads()
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
+
+	for _, download := range downloads {
+		metadata := g.metadataFromPartialDownload(download)
+		if metadata == nil {
+			continue
+		}
+
+		g.FilesIndex.Mutex.Lock()
+		g.FilesIndex.Store(metadata)
+		g.FilesIndex.Mutex.Unlock()
+
+		g.Requested.Mutex.Lock()
+		if _, ok := g.Requested.State[download.Name]; !ok {
+			g.Requested.State[download.Name] = make(map[string]uint64)
+		}
+		g.Requested.State[download.Name][download.MetaHash] = uint64(len(metadata.Chunks))
+		g.Requested.Downloads[download.MetaHash] = download
+		g.Requested.Mutex.Unlock()
+
+		fmt.Printf("RESUMING download of %s %d/%d chunks\n", download.Name, len(download.ReceivedChunks()), download.NbrChunks())
+		if download.Paused {
+			continue
+		}
+
+		if metadata.Metafile == nil {
+			g.requestMetafile(download)
+		} else {
+			go g.startChunksDownload(metadata, download)
+		}
+	}
+}
+
+//metadataFromPartialDownload rebuilds the metadata of a partial download.
+//The chunks already written in the part file are read back and checked against their hash;
+//the chunks that do not match are downloaded again.
+func (g *Gossiper) metadataFromPartialDownload(download *fileSharing.PartialDownload) *fileSharing.Metadata {
+	metaHash, err := hex.DecodeString(download.MetaHash)
+	if err != nil {
+		helper.LogError(err)
+		return nil
+	}
+
+	metadata := &fileSharing.Metadata{
+		Name:      download.Name,
+		MetaHash:  metaHash,
+		Metafile:  download.MetafileBytes(),
+		Chunks:    make(map[string]fileSharing.ChunkLocation),
+		NbrChunks: download.NbrChunks(),
+		Path:      download.PartPath(),
+	}
+
+	for _, index := range download.ReceivedChunks() {
+		chunk, err := download.ReadChunk(index)
+		hash := hex.EncodeToString(metadata.ChunkHash(index))
+
+		if chunkHash := sha256.Sum256(chunk); err == nil && hex.EncodeToString(chunkHash[:]) == hash {
+			metadata.Chunks[hash] = fileSharing.ChunkLocation{
+				Index: index,
+				Size:  len(chunk),
+			}
+		} else {
+			download.Forget(index)
+		}
+	}
+
+	return metadata
+}
+
+//isDownloadActive tells if the download of the file with the given metahash is neither paused nor cancelled
+func (g *Gossiper) isDownloadActive(metaHashStr string) bool {
+	g.Requested.Mutex.RLock()
+	defer g.Requested.Mutex.RUnlock()
+
+	download, ok := g.Requested.Downloads[metaHashStr]
+	return ok && !download.Paused
+}
+
+//HandleDownloadCommand executes the download command sent by the client and returns its result.
+//command string is either "list", "pause", "resume" or "cancel"
+//fileName string is the name of the download targeted by the command, it is ignored by "list"
+func (g *Gossiper) HandleDownloadCommand(command, fileName string) string {
+	if command == "list" {
+		return g.listDownloads()
+	}
+
+	metaHashStr, ok := g.findDownload(fileName)
+	if !ok {
+		return fmt.Sprintf("NO DOWNLOAD of %s", fileName)
+	}
+
+	switch command {
+	case "pause":
+		g.Requested.Mutex.Lock()
+		download := g.Requested.Downloads[metaHashStr]
+		download.Paused = true
+		helper.LogError(download.Save())
+		g.Requested.Mutex.Unlock()
+		return fmt.Sprintf("PAUSED download of %s", fileName)
+	case "resume":
+		g.resumeDownload(metaHashStr)
+		return fmt.Sprintf("RESUMED download of %s", fileName)
+	case "cancel":
+		g.cancelDownload(metaHashStr)
+		return fmt.Sprintf("CANCELLED download of %s", fileName)
+	default:
+		return fmt.Sprintf("UNKNOWN download command %s", command)
+	}
+}
+
+//listDownloads returns one line per ongoing download
+func (g *Gossiper) listDownloads() string {
+	g.Requested.Mutex.RLock()
+	defer g.Requested.Mutex.RUnlock()
+
+	lines := make([]string, 0, len(g.Requested.Downloads))
+	for _, download := range g.Requested.Downloads {
+		lines = append(lines, download.String())
+	}
+	sort.Strings(lines)
+
+	if len(lines) == 0 {
+		return "NO DOWNLOAD"
+	}
+	return strings.Join(lines, "\n")
+}
+
+//findDownload returns the metahash of the download stored under the given file name
+func (g *Gossiper) findDownload(fileName string) (string, bool) {
+	g.Requested.Mutex.RLock()
+	defer g.Requested.Mutex.RUnlock()
+
+	for metaHashStr, download := range g.Requested.Downloads {
+		if download.Name == fileName {
+			return metaHashStr, true
+		}
+	}
+	return "", false
+}
+
+//resumeDownload resumes a paused download where it stopped
+func (g *Gossiper) resumeDownload(metaHashStr string) {
+	g.Requested.Mutex.Lock()
+	download, ok := g.Requested.Downloads[metaHashStr]
+	if !ok {
+		g.Requested.Mutex.Unlock()
+		return
+	}
+	wasPaused := download.Paused
+	download.Paused = false
+	helper.LogError(download.Save())
+	scheduler := g.Requested.Schedulers[metaHashStr]
+	g.Requested.Mutex.Unlock()
+
+	if !wasPaused {
+		return
+	}
+
+	g.FilesIndex.Mutex.RLock()
+	metadata := g.FilesIndex.Index[metaHashStr]
+	g.FilesIndex.Mutex.RUnlock()
+
+	if metadata == nil {
+		return
+	}
+
+	if metadata.Metafile == nil {
+		g.requestMetafile(download)
+	} else if scheduler == nil {
+		g.startChunksDownload(metadata, download)
+	} else {
+		g.scheduleChunks(metadata, scheduler)
+	}
+}
+
+//cancelDownload stops the download and removes the partially downloaded file
+func (g *Gossiper) cancelDownload(metaHashStr string) {
+	g.Requested.Mutex.Lock()
+	download, ok := g.Requested.Downloads[metaHashStr]
+	delete(g.Requested.Downloads, metaHashStr)
+	delete(g.Requested.Schedulers, metaHashStr)
+	g.Requested.Mutex.Unlock()
+
+	if !ok {
+		return
+	}
+	download.Remove()
+
+	g.FilesIndex.Mutex.Lock()
+	delete(g.FilesIndex.Index, metaHashStr)
+	g.FilesIndex.Mutex.Unlock()
+}
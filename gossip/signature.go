@@ -0,0 +1,83 @@
+package gossip
+
+import (
+	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/identity"
+	"github.com/somecookie/Peerster/packet"
+)
+
+//VerifyPacket checks the signature of the signed messages contained in gossipPacket.
+//The public key of an origin is learned the first time a message from this origin is seen (trust-on-first-use),
+//then every message of this origin must be signed with the same key.
+//A packet carrying more than one message is invalid, since only one of them would be handled.
+//Packets without signed messages are always valid.
+//The encryption key announced in a valid rumor is recorded in the KeyStore.
+func (g *Gossiper) VerifyPacket(gossipPacket *packet.GossipPacket) bool {
+	if gossipPacket.Payloads() > 1 {
+		return false
+	}
+
+	if rumor := gossipPacket.Rumor; rumor != nil {
+		if !rumor.Verify() || !g.KeyStore.Learn(rumor.Origin, rumor.PublicKey) {
+			return false
+		}
+
+		if len(rumor.EncryptionKey) > 0 {
+			g.KeyStore.SetEncryptionKey(rumor.Origin, rumor.EncryptionKey)
+		}
+	}
+	if tlc := gossipPacket.TLCMessage; tlc != nil && !(tlc.Verify() && g.KeyStore.Learn(tlc.Origin, tlc.PublicKey)) {
+		return false
+	}
+	if pm := gossipPacket.Private; pm != nil && !(pm.Verify() && g.KeyStore.Learn(pm.Origin, pm.PublicKey)) {
+		return false
+	}
+	if ack := gossipPacket.Ack; ack != nil && !(ack.Verify() && g.KeyStore.Learn(ack.Origin, ack.PublicKey)) {
+		return false
+	}
+	if lsm := gossipPacket.LinkState; lsm != nil && !(lsm.Verify() && g.KeyStore.Learn(lsm.Origin, lsm.PublicKey)) {
+		return false
+	}
+	if da := gossipPacket.Delivery; da != nil && !(da.Verify() && g.KeyStore.Learn(da.Origin, da.PublicKey)) {
+		return false
+	}
+	if deposit := gossipPacket.Deposit; deposit != nil && !(deposit.Message.Verify() && g.KeyStore.Learn(deposit.Message.Origin, deposit.Message.PublicKey)) {
+		return false
+	}
+
+	return true
+}
+
+//encryptPrivate returns a copy of pm whose text is encrypted for the destination.
+//It returns nil if the encryption key of the destination is not known yet.
+func (g *Gossiper) encryptPrivate(pm *packet.PrivateMessage) *packet.PrivateMessage {
+	key, ok := g.KeyStore.GetEncryptionKey(pm.Destination)
+	if !ok {
+		packet.PrintUnknownEncryptionKey(pm.Destination)
+		return nil
+	}
+
+	ephemeral, nonce, ciphertext, err := identity.Encrypt(key, []byte(pm.Text))
+	if err != nil {
+		helper.LogError(err)
+		return nil
+	}
+
+	encrypted := *pm
+	encrypted.Text = ""
+	encrypted.EphemeralKey = ephemeral
+	encrypted.Nonce = nonce
+	encrypted.Ciphertext = ciphertext
+	return &encrypted
+}
+
+//decryptPrivate decrypts the text of a private message whose destination is the gossiper.
+func (g *Gossiper) decryptPrivate(pm *packet.PrivateMessage) error {
+	plaintext, err := g.Identity.Decrypt(pm.EphemeralKey, pm.Nonce, pm.Ciphertext)
+	if err != nil {
+		return err
+	}
+
+	pm.Text = string(plaintext)
+	return nil
+}
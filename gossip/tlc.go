@@ -1,10 +1,14 @@
 package gossip
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/somecookie/Peerster/blockchain"
 	"github.com/somecookie/Peerster/fileSharing"
+	"github.com/somecookie/Peerster/helper"
 	"github.com/somecookie/Peerster/packet"
+	"github.com/somecookie/Peerster/storage"
+	"net"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -12,85 +16,171 @@ import (
 )
 
 //TLCMajority represents the state for the majorities. All operations on this structure are thread safe.
-//Acks         map[uint32][]string is the mapping between an ID and all the peers that have already acknowledged the TLCMessage with this ID
+//Acks         map[uint32][]packet.TLCAck is the mapping between an ID and the signed acks of the peers that have already acknowledged the TLCMessage with this ID
+//Hashes       map[uint32][32]byte is the hash the witnesses sign for each TLCMessage of the gossiper, by ID
 //AcksChannels map[uint32]chan bool is the mapping an ID of a TLCMessage and its associated channel
-//Total        int is the total number of Peers in the network. Therefore, the majority is Total/2.
+//Membership   *blockchain.Membership is the set of the members of TLC agreed in the chain
+//Members      map[uint32]blockchain.Members are the members in each round. Therefore, the majority of a round is len(Members[round])/2.
+//Only the acks and the confirmations of the members of a round are counted.
+//FaultThreshold int is the number f of faulty members tolerated: 0 disables it. Otherwise a majority also needs more than 2f+1 witnesses or confirmations.
 //MyRound      uint32
+//OtherRounds  map[string]uint32 is the round following the last round in which each origin sent a message
 //Queue        *fileSharing.MetadataQueue is the FIFO used to get the indexing command.
 //Confirmed    map[uint32][]string  is a mapping from a round to the gossiper who sent a confirmed message.
 //FutureMsg    []packet.TLCMessage are the messages that were not accepted because of their vector clock.
 //LastID       uint32 is the last ID used by the gossiper owning the TLCMajority
+//Proposal     *fileSharing.Metadata is the file the gossiper is trying to publish with QSC (nil if there is none)
+//Best         map[uint32]Confirmation is the best proposal seen by the gossiper in each round of the QSC instances
+//Candidates   map[[32]byte]blockchain.BlockPublish are the blocks of the confirmed messages indexed by their hash
+//Changes      []blockchain.TxPublish are the membership changes and the file operations waiting to be proposed
+//CatchingUp   bool is true while a catch-up request waits for its replies
 type TLCMajority struct {
 	sync.RWMutex
-	Acks         map[uint32][]string
-	AcksChannels map[uint32]chan bool
-	Total        int
-	MyRound      uint32
-	OtherRounds  map[string]uint32
-	Queue        *fileSharing.MetadataQueue
-	Confirmed    map[uint32][]Confirmation
-	FutureMsg    []*packet.TLCMessage
-	LastID       uint32
-	ReicvCommand bool
+	Acks           map[uint32][]packet.TLCAck
+	Hashes         map[uint32][32]byte
+	AcksChannels   map[uint32]chan bool
+	Membership     *blockchain.Membership
+	Members        map[uint32]blockchain.Members
+	FaultThreshold int
+	MyRound        uint32
+	OtherRounds    map[string]uint32
+	Queue          *fileSharing.MetadataQueue
+	Confirmed      map[uint32][]Confirmation
+	FutureMsg      []*packet.TLCMessage
+	LastID         uint32
+	ReicvCommand   bool
+	Proposal       *fileSharing.Metadata
+	Best           map[uint32]Confirmation
+	Candidates     map[[32]byte]blockchain.BlockPublish
+	Changes        []blockchain.TxPublish
+	CatchingUp     bool
 }
 
+//Confirmation is a confirmed TLC message: the block it proposes and its fitness are used by QSC.
+//The signed message is kept to build the round certificates sent to the lagging nodes.
 type Confirmation struct {
-	Origin string
-	ID     uint32
+	Origin  string
+	ID      uint32
+	TxBlock blockchain.BlockPublish
+	Fitness float32
+	Message *packet.TLCMessage
 }
 
-func TLCMajorityFactory(N int) *TLCMajority {
+//TLCMajorityFactory creates the state for the majorities of a network founded by the given gossipers, f of which may be faulty
+func TLCMajorityFactory(founders []blockchain.Founder, f int) *TLCMajority {
 	return &TLCMajority{
-		RWMutex:      sync.RWMutex{},
-		Acks:         make(map[uint32][]string),
-		AcksChannels: make(map[uint32]chan bool),
-		Total:        N,
-		MyRound:      0,
-		OtherRounds:  make(map[string]uint32),
-		Queue:        fileSharing.MetadataQueueFactory(),
-		Confirmed:    make(map[uint32][]Confirmation),
-		FutureMsg:    make([]*packet.TLCMessage, 0),
-		LastID:       0,
-		ReicvCommand: false,
+		RWMutex:        sync.RWMutex{},
+		Acks:           make(map[uint32][]packet.TLCAck),
+		Hashes:         make(map[uint32][32]byte),
+		AcksChannels:   make(map[uint32]chan bool),
+		Membership:     blockchain.MembershipFactory(founders),
+		Members:        make(map[uint32]blockchain.Members),
+		FaultThreshold: f,
+		MyRound:        0,
+		OtherRounds:    make(map[string]uint32),
+		Queue:          fileSharing.MetadataQueueFactory(),
+		Confirmed:      make(map[uint32][]Confirmation),
+		FutureMsg:      make([]*packet.TLCMessage, 0),
+		LastID:         0,
+		ReicvCommand:   false,
+		Proposal:       nil,
+		Best:           make(map[uint32]Confirmation),
+		Candidates:     make(map[[32]byte]blockchain.BlockPublish),
+		Changes:        make([]blockchain.TxPublish, 0),
 	}
 }
 
 //SelfAdd allows the gossiper to add itself when it creates a new TLCMessage.
-//ID uint32 is the ID of the newly created TLCMessage
-//selfName string is the name of the gossiper
-func (tm *TLCMajority) SelfAdd(ID uint32, selfName string) {
+//msg *packet.TLCMessage is the newly created TLCMessage
+//ack packet.TLCAck is the ack of the gossiper for its own message
+func (tm *TLCMajority) SelfAdd(msg *packet.TLCMessage, ack packet.TLCAck) {
+
+	tm.Acks[msg.ID] = make([]packet.TLCAck, 0, tm.majority(tm.MyRound)+1)
+	tm.Acks[msg.ID] = append(tm.Acks[msg.ID], ack)
+	tm.Hashes[msg.ID] = msg.Witnessed()
+	tm.AcksChannels[msg.ID] = make(chan bool, 1)
+}
+
+//majority returns the number of acks or confirmations that must be exceeded to reach a majority in the given round.
+//The members are recorded the first time the round is used, so that all the majorities of a round
+//are computed from the same membership and a change agreed during the round only applies from the next one.
+func (tm *TLCMajority) majority(round uint32) int {
+	members, ok := tm.Members[round]
+	if !ok {
+		members = tm.Membership.Current()
+		tm.Members[round] = members
+	}
+	return tm.threshold(len(members))
+}
 
-	tm.Acks[ID] = make([]string, 0, tm.Total/2+1)
-	tm.Acks[ID] = append(tm.Acks[ID], selfName)
-	tm.AcksChannels[ID] = make(chan bool)
+//membersOf returns the members recorded for the given round, or the current members if the round was not used yet
+func (tm *TLCMajority) membersOf(round uint32) blockchain.Members {
+	if members, ok := tm.Members[round]; ok {
+		return members
+	}
+	return tm.Membership.Current()
+}
+
+//threshold returns the number of witnesses or confirmations that must be exceeded with the given number of members:
+//there must be more than half of the members and, if faults are tolerated, more than 2f+1 of them.
+func (tm *TLCMajority) threshold(members int) int {
+	return threshold(members, tm.FaultThreshold)
+}
+
+//threshold is the implementation of TLCMajority.threshold for f tolerated faulty members
+func threshold(members, f int) int {
+	if f > 0 && 2*f+1 > members/2 {
+		return 2*f + 1
+	}
+	return members / 2
+}
+
+//signal tells the stubborn goroutine of the TLCMessage with the given ID if the majority was reached.
+//The channel is buffered and used only once, so that the caller never blocks while holding the lock.
+func (tm *TLCMajority) signal(ID uint32, majority bool) {
+	if c, ok := tm.AcksChannels[ID]; ok {
+		delete(tm.AcksChannels, ID)
+		c <- majority
+	}
 }
 
 //AddNewAck adds a new ack to the list of peers that acknowledged the TLCMessage being acknowledged by ack.
-//ack *packet.TLCAck is the new ack
+//ack *packet.TLCAck is the new ack. Its signature must have been verified. An ack for another content than the message
+//sent by the gossiper with this ID, or from a gossiper that is not a member in the round, is discarded. If the membership
+//records the key of the member, the ack must be signed with it.
 //returns a boolean that tells if the majority was reached (for the first time).
 //Notice that the majority can only be reached by one ack, the next ack will simply be discarded.
 //If the majority, the channel associated to the ack allows to stop the stubborn timer and continue the process.
 func (tm *TLCMajority) AddNewAck(ack *packet.TLCAck) bool {
 
-	if len(tm.Acks[ack.ID]) > tm.Total/2 {
+	majority := tm.majority(tm.MyRound)
+	if len(tm.Acks[ack.ID]) > majority {
 		return false
 	}
 
 	if acks, ok := tm.Acks[ack.ID]; ok {
+		if hash := tm.Hashes[ack.ID]; !bytes.Equal(ack.Hash, hash[:]) {
+			return false
+		}
+
+		key, member := tm.Members[tm.MyRound][ack.Origin]
+		if !member || key != nil && !bytes.Equal(key, ack.PublicKey) {
+			return false
+		}
+
 		contains := false
-		for _, name := range acks {
-			if name == ack.Origin {
+		for _, witness := range acks {
+			if witness.Origin == ack.Origin {
 				contains = true
 			}
 		}
 
 		if !contains {
-			tm.Acks[ack.ID] = append(tm.Acks[ack.ID], ack.Origin)
+			tm.Acks[ack.ID] = append(tm.Acks[ack.ID], *ack)
 		}
 
-		if len(tm.Acks[ack.ID]) > tm.Total/2 {
-			c := tm.AcksChannels[ack.ID]
-			c <- true
+		if len(tm.Acks[ack.ID]) > majority {
+			tm.signal(ack.ID, true)
 			return true
 		}
 	}
@@ -99,11 +189,39 @@ func (tm *TLCMajority) AddNewAck(ack *packet.TLCAck) bool {
 }
 
 func (tm *TLCMajority) PrintReBroadcast(confirmed *packet.TLCMessage) {
-	peersList := tm.Acks[uint32(confirmed.Confirmed)]
+	peersList := make([]string, 0, len(confirmed.Witnesses))
+	for _, ack := range confirmed.Witnesses {
+		peersList = append(peersList, ack.Origin)
+	}
 	witnesses := strings.Join(peersList, ",")
 	fmt.Printf("RE-BROADCAST ID %d WITNESSES %s\n", confirmed.Confirmed, witnesses)
 }
 
+//seen records that origin sent a message in the given round.
+//It returns true if origin is more than one round ahead of the gossiper, i.e. the gossiper is lagging.
+func (tm *TLCMajority) seen(origin string, round uint32) bool {
+	if round+1 > tm.OtherRounds[origin] {
+		tm.OtherRounds[origin] = round + 1
+	}
+	return round > tm.MyRound+1
+}
+
+//hasConfirmation checks if the confirmed message of origin with the given ID is already counted in the round
+func (tm *TLCMajority) hasConfirmation(round uint32, origin string, ID uint32) bool {
+	for _, c := range tm.Confirmed[round] {
+		if c.Origin == origin && c.ID == ID {
+			return true
+		}
+	}
+	return false
+}
+
+//AddConfirmation adds a confirmed message to the given round and records the block it proposes.
+func (tm *TLCMajority) AddConfirmation(round uint32, confirmation Confirmation) {
+	tm.Confirmed[round] = append(tm.Confirmed[round], confirmation)
+	tm.Candidates[confirmation.TxBlock.Hash()] = confirmation.TxBlock
+}
+
 //GetChannel allows you to get the channel corresponding to the given id. It returns nil if the ID is not valid.
 func (tm *TLCMajority) GetChannel(ID uint32) chan bool {
 	if c, ok := tm.AcksChannels[ID]; ok {
@@ -113,11 +231,12 @@ func (tm *TLCMajority) GetChannel(ID uint32) chan bool {
 	}
 }
 
+//RemoveFromFuture removes the message from FutureMsg. The order of the other messages is kept,
+//so that the messages of an origin are handled in the order in which they were sent.
 func (tm *TLCMajority) RemoveFromFuture(message *packet.TLCMessage) {
 	for i, msg := range tm.FutureMsg {
 		if message == msg {
-			tm.FutureMsg[i], tm.FutureMsg[len(tm.FutureMsg)-1] = tm.FutureMsg[len(tm.FutureMsg)-1], tm.FutureMsg[i]
-			tm.FutureMsg = tm.FutureMsg[:len(tm.FutureMsg)-1]
+			tm.FutureMsg = append(tm.FutureMsg[:i], tm.FutureMsg[i+1:]...)
 			return
 		}
 	}
@@ -126,94 +245,120 @@ func (tm *TLCMajority) RemoveFromFuture(message *packet.TLCMessage) {
 //Stubborn stubbornly rebroadcast the tlcMessage after a timeout or, if a majority is reached before the timeout
 //re-broadcasts the new confirmed message.
 //tlcMessage *packet.TLCMessage is the new TLC message broadcast in the first place.
-func (g *Gossiper) Stubborn(tlcMessage *packet.TLCMessage) {
-	ticker := time.NewTicker(time.Duration(g.stubbornTimeout) * time.Second)
-
-	g.TLCMajority.RLock()
-	ackChannel := g.TLCMajority.GetChannel(tlcMessage.ID)
-	g.TLCMajority.RUnlock()
-
+//ackChannel chan bool is the channel of the message, obtained with GetChannel when the message is created.
+func (g *Gossiper) Stubborn(tlcMessage *packet.TLCMessage, ackChannel chan bool) {
 	if ackChannel == nil {
 		return
 	}
 
+	ticker := g.clock.NewTicker(time.Duration(g.stubbornTimeout) * time.Second)
+
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			g.Rumormongering(&packet.GossipPacket{TLCMessage: tlcMessage}, false, nil, nil)
+
+			//the message may be ignored because the gossiper is lagging behind the others
+			if !g.ackAll {
+				g.TLCMajority.Lock()
+				g.requestCatchUp()
+				g.TLCMajority.Unlock()
+			}
 		case majority := <-ackChannel:
 			ticker.Stop()
 			close(ackChannel)
 			if majority {
-				atomic.AddUint32(&g.counter, 1)
+				g.TLCMajority.Lock()
+				defer g.TLCMajority.Unlock()
+
+				//the gossiper already advanced to the next round without this message:
+				//its confirmation would be counted in the wrong round by the other nodes
+				if !g.ackAll && (g.TLCMajority.LastID != tlcMessage.ID || g.TLCMajority.MyRound != tlcMessage.Round) {
+					return
+				}
+
+				ID := atomic.AddUint32(&g.counter, 1)
 				confirmation := &packet.TLCMessage{
 					Origin:      g.Name,
-					ID:          g.counter,
+					ID:          ID,
 					Confirmed:   int(tlcMessage.ID),
 					TxBlock:     tlcMessage.TxBlock,
 					VectorClock: tlcMessage.VectorClock,
 					Fitness:     tlcMessage.Fitness,
+					Round:       tlcMessage.Round,
+					Witnesses:   append([]packet.TLCAck(nil), g.TLCMajority.Acks[tlcMessage.ID]...),
 				}
-				g.TLCMajority.RLock()
+				helper.LogError(confirmation.Sign(g.Identity.SigningKey))
 				g.TLCMajority.PrintReBroadcast(confirmation)
-				g.TLCMajority.RUnlock()
+				g.recordConfirmation(confirmation)
 
 				gp := &packet.GossipPacket{TLCMessage: confirmation}
+				g.State.Mutex.Lock()
 				g.State.UpdateGossiperState(gp)
+				g.State.Mutex.Unlock()
 				g.Rumormongering(gp, false, nil, nil)
 
-				if !g.ackAll{
-					g.TLCMajority.Lock()
-					g.TLCMajority.Confirmed[g.TLCMajority.MyRound] = append(g.TLCMajority.Confirmed[g.TLCMajority.MyRound], Confirmation{
-						Origin: g.Name,
-						ID:     confirmation.ID,
-					})
+				g.TLCMajority.AddConfirmation(tlcMessage.Round, Confirmation{
+					Origin:  g.Name,
+					ID:      confirmation.ID,
+					TxBlock: confirmation.TxBlock,
+					Fitness: confirmation.Fitness,
+					Message: confirmation,
+				})
+				if g.ackAll {
+					g.addBlock(tlcMessage.TxBlock)
+				} else {
 					g.TryNextRound()
-					g.TLCMajority.Unlock()
 				}
-
-
 			}
 			return
 		}
 	}
 }
 
-//BroadcastNewFile gossips a newly indexed file
+//BroadcastNewFile gossips a newly indexed file. The block is proposed with a random fitness.
 //metadata *fileSharing.Metadata is the metadata of the new file
 func (g *Gossiper) BroadcastNewFile(metadata *fileSharing.Metadata) {
+	head, _ := g.Blockchain.Head()
 	bp := blockchain.BlockPublish{
-		PrevHash: [32]byte{},
-		Transaction: blockchain.TxPublish{
-			Name:         metadata.Name,
-			Size:         int64(metadata.Size),
-			MetafileHash: metadata.MetaHash,
-		},
+		PrevHash: head,
+		Transaction: g.newPublish(metadata),
 	}
+	g.broadcastTLC(bp, g.randomFitness())
+}
 
+//broadcastTLC gossips a new unconfirmed TLC message proposing the block bp with the given fitness
+//and stubbornly waits for a majority of acks.
+func (g *Gossiper) broadcastTLC(bp blockchain.BlockPublish, fitness float32) {
+
+	//the vector clock is copied since the state is updated in place and the message is signed
 	g.State.Mutex.RLock()
 	vc := &packet.StatusPacket{
-		Want: g.State.VectorClock,
+		Want: append([]packet.PeerStatus(nil), g.State.VectorClock...),
 	}
 	g.State.Mutex.RUnlock()
 
-	atomic.AddUint32(&g.counter, 1)
-	g.TLCMajority.LastID = g.counter
+	ID := atomic.AddUint32(&g.counter, 1)
+	g.TLCMajority.LastID = ID
 	tlcMsg := &packet.TLCMessage{
 		Origin:      g.Name,
-		ID:          g.counter,
+		ID:          ID,
 		Confirmed:   -1,
 		TxBlock:     bp,
 		VectorClock: vc,
-		Fitness:     0,
+		Fitness:     fitness,
+		Round:       g.TLCMajority.MyRound,
 	}
+	helper.LogError(tlcMsg.Sign(g.Identity.SigningKey))
 	gp := &packet.GossipPacket{TLCMessage: tlcMsg}
-	g.TLCMajority.SelfAdd(tlcMsg.ID, g.Name)
+	g.TLCMajority.SelfAdd(tlcMsg, *g.newAck(tlcMsg))
+	g.State.Mutex.Lock()
 	g.State.UpdateGossiperState(gp)
+	g.State.Mutex.Unlock()
 
 	packet.PrintUnconfirmedMessage(tlcMsg)
 	g.Rumormongering(gp, false, nil, nil)
-	go g.Stubborn(tlcMsg)
+	go g.Stubborn(tlcMsg, g.TLCMajority.GetChannel(tlcMsg.ID))
 }
 
 //This function handles the TLCMessage when they are received by the gossiper
@@ -224,17 +369,32 @@ func (g *Gossiper) HandleTLCMessage(tlcMessage *packet.TLCMessage) {
 		if tlcMessage.Confirmed == -1 {
 			packet.PrintUnconfirmedMessage(tlcMessage)
 
-			ack := &packet.TLCAck{
-				Origin:      g.Name,
-				ID:          tlcMessage.ID,
-				Destination: tlcMessage.Origin,
-				HopLimit:    uint32(g.hoplimit),
+			if !g.isFromMember(tlcMessage, g.TLCMajority.Membership.Current()) || !g.isValidBlock(&tlcMessage.TxBlock) {
+				return
 			}
+
+			ack := g.newAck(tlcMessage)
 			packet.PrintSendingTLCAck(ack)
-			g.TLCAckRoutine(ack)
+			g.TLCAckRoutine(ack, nil)
 
 		} else {
+			members := g.TLCMajority.Membership.Current()
+			if !g.isFromMember(tlcMessage, members) || !g.isWitnessed(tlcMessage, members) {
+				return
+			}
+
 			packet.PrintConfirmedMessage(tlcMessage)
+			g.recordConfirmation(tlcMessage)
+			g.TLCMajority.Lock()
+			g.TLCMajority.AddConfirmation(tlcMessage.Round, Confirmation{
+				Origin:  tlcMessage.Origin,
+				ID:      tlcMessage.ID,
+				TxBlock: tlcMessage.TxBlock,
+				Fitness: tlcMessage.Fitness,
+				Message: tlcMessage,
+			})
+			g.TLCMajority.Unlock()
+			g.addBlock(tlcMessage.TxBlock)
 		}
 		return
 	}
@@ -244,49 +404,111 @@ func (g *Gossiper) HandleTLCMessage(tlcMessage *packet.TLCMessage) {
 	defer g.TLCMajority.Unlock()
 
 	g.TLCMajority.FutureMsg = append(g.TLCMajority.FutureMsg, tlcMessage)
+	g.handleFutureMessages()
+}
 
-	for _, msg := range g.TLCMajority.FutureMsg {
-		if g.SatisfyVC(msg) {
-			g.TLCMajority.RemoveFromFuture(msg)
-			if msg.Confirmed == -1 {
-				packet.PrintUnconfirmedMessage(tlcMessage)
+//RetryFutureMessages handles the TLC messages whose vector clock is satisfied since the vector clock of the gossiper advanced.
+//It is called when a rumor fills a gap in the vector clock without being new.
+func (g *Gossiper) RetryFutureMessages() {
+	if g.ackAll {
+		return
+	}
 
-				if _, ok := g.TLCMajority.OtherRounds[msg.Origin]; !ok {
-					g.TLCMajority.OtherRounds[msg.Origin] = 1
-				} else {
-					g.TLCMajority.OtherRounds[msg.Origin] += 1
-				}
+	g.TLCMajority.Lock()
+	defer g.TLCMajority.Unlock()
+	g.handleFutureMessages()
+}
 
-				if round, ok := g.TLCMajority.OtherRounds[tlcMessage.Origin]; !ok {
-					if g.TLCMajority.MyRound != 0 {
-						return
-					}
-				} else if round-1 < g.TLCMajority.MyRound {
-					return
-				}
+//handleFutureMessages handles the messages of FutureMsg whose vector clock is satisfied.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) handleFutureMessages() {
+	//the loop iterates over a copy since the messages are removed from FutureMsg.
+	//It starts again as long as messages are handled, since a message may satisfy its vector clock thanks to another one.
+	for progress := true; progress; {
+		progress = false
+		for _, msg := range append([]*packet.TLCMessage(nil), g.TLCMajority.FutureMsg...) {
+			if g.SatisfyVC(msg) {
+				g.TLCMajority.RemoveFromFuture(msg)
+				g.handleReadyTLCMessage(msg)
+				progress = true
+			}
+		}
+	}
+}
 
-				ack := &packet.TLCAck{
-					Origin:      g.Name,
-					ID:          msg.ID,
-					Destination: msg.Origin,
-					HopLimit:    uint32(g.hoplimit),
-				}
+//handleReadyTLCMessage handles a TLC message whose vector clock is satisfied.
+//An unconfirmed message is acknowledged if it is not from a previous round, and a confirmed message is counted in the
+//round of the message it confirms. A message more than one round ahead makes the gossiper catch up.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) handleReadyTLCMessage(msg *packet.TLCMessage) {
+	if g.TLCMajority.seen(msg.Origin, msg.Round) {
+		g.requestCatchUp()
+	}
 
-				packet.PrintSendingTLCAck(ack)
-				g.TLCAckRoutine(ack)
+	if msg.Confirmed == -1 {
+		packet.PrintUnconfirmedMessage(msg)
 
-			} else {
-				packet.PrintConfirmedMessage(msg)
-				r := g.TLCMajority.OtherRounds[msg.Origin] - 1
-				g.TLCMajority.Confirmed[r] = append(g.TLCMajority.Confirmed[r], Confirmation{
-					Origin: msg.Origin,
-					ID:     msg.ID,
-				})
-				g.TryNextRound()
-			}
+		if msg.Round < g.TLCMajority.MyRound {
+			return
 		}
+
+		//only the members of TLC witness the messages, and only the messages of the members
+		if !g.isFromMember(msg, g.TLCMajority.membersOf(msg.Round)) || !g.isMember() || !g.isValidBlock(&msg.TxBlock) {
+			return
+		}
+
+		ack := g.newAck(msg)
+		packet.PrintSendingTLCAck(ack)
+		g.TLCAckRoutine(ack, nil)
+
+	} else {
+		//the confirmation is only counted if it comes from a member and enough members witnessed the message,
+		//according to the membership of its round
+		members := g.TLCMajority.membersOf(msg.Round)
+		if !g.isFromMember(msg, members) || !g.isWitnessed(msg, members) {
+			return
+		}
+
+		packet.PrintConfirmedMessage(msg)
+		g.recordConfirmation(msg)
+		g.TLCMajority.AddConfirmation(msg.Round, Confirmation{
+			Origin:  msg.Origin,
+			ID:      msg.ID,
+			TxBlock: msg.TxBlock,
+			Fitness: msg.Fitness,
+			Message: msg,
+		})
+		g.adoptAncestors(msg.TxBlock.PrevHash)
+		g.rejoin()
+		g.TryNextRound()
 	}
+}
+
+//isValidBlock checks that the transaction of the block does not reuse the name of a file published in the chain
+//or claimed for another file, or that its membership change is valid
+func (g *Gossiper) isValidBlock(block *blockchain.BlockPublish) bool {
+	if err := g.checkTransaction(block.Transaction); err != nil {
+		helper.LogError(err)
+		return false
+	}
+	return true
+}
 
+//addBlock adds a confirmed block to the chain and updates the registry and the membership if its head changed.
+//Blocks received twice and blocks waiting for their parent are expected and not reported.
+//The new blocks are appended to the ledger log, including the ones waiting for their parent.
+func (g *Gossiper) addBlock(block blockchain.BlockPublish) {
+	changed, err := g.Blockchain.Add(&block)
+	switch err.(type) {
+	case nil, *blockchain.UnknownParentError:
+		g.recordBlock(&storage.BlockRecord{Published: &block})
+	case *blockchain.DuplicateBlockError:
+	default:
+		helper.LogError(err)
+	}
+	if changed {
+		g.headChanged()
+	}
 }
 
 func (g *Gossiper) SatisfyVC(msg *packet.TLCMessage) bool {
@@ -317,22 +539,106 @@ func (g *Gossiper) SatisfyVC(msg *packet.TLCMessage) bool {
 	return true
 }
 
+//newAck creates the signed ack of the gossiper for the unconfirmed message msg
+func (g *Gossiper) newAck(msg *packet.TLCMessage) *packet.TLCAck {
+	hash := msg.Witnessed()
+	ack := &packet.TLCAck{
+		Origin:      g.Name,
+		ID:          msg.ID,
+		Destination: msg.Origin,
+		HopLimit:    uint32(g.hoplimit),
+		Hash:        hash[:],
+	}
+	helper.LogError(ack.Sign(g.Identity.SigningKey))
+	return ack
+}
+
+//isWitnessed verifies the certificate of the confirmed message msg: its witnesses must be more than the threshold of
+//the given members, and each of them must be one of the members and have signed an ack for the confirmed message with
+//the key of the member. No key is learned from the certificate.
+//An invalid certificate is reported.
+func (g *Gossiper) isWitnessed(msg *packet.TLCMessage, members blockchain.Members) bool {
+	witnesses := countWitnesses(msg, func(origin string) ([]byte, bool) {
+		return g.memberKey(members, origin)
+	})
+
+	if witnesses <= g.TLCMajority.threshold(len(members)) {
+		packet.PrintInvalidCertificate(msg, witnesses)
+		return false
+	}
+	return true
+}
+
+//isFromMember checks that msg was sent by one of the members: it must carry the key of the member and be signed with it
+func (g *Gossiper) isFromMember(msg *packet.TLCMessage, members blockchain.Members) bool {
+	key, ok := g.memberKey(members, msg.Origin)
+	return ok && bytes.Equal(key, msg.PublicKey) && msg.Verify()
+}
+
+//memberKey returns the key of the member called origin: the key recorded in the membership, or the key known for it
+//if the membership does not record any. It returns false if origin is not one of the members or if its key is unknown.
+func (g *Gossiper) memberKey(members blockchain.Members, origin string) ([]byte, bool) {
+	key, member := members[origin]
+	if !member {
+		return nil, false
+	}
+	if key == nil {
+		return g.KeyStore.Get(origin)
+	}
+	return key, true
+}
+
+//CountedWitnesses returns the names of the witnesses of the confirmed message msg that count for the given round:
+//the members of the round that signed an ack for it with their key.
+//It must be called with the lock of TLCMajority held.
+func (g *Gossiper) CountedWitnesses(msg *packet.TLCMessage, round uint32) []string {
+	members := g.TLCMajority.membersOf(round)
+	return witnessesOf(msg, func(origin string) ([]byte, bool) {
+		return g.memberKey(members, origin)
+	})
+}
+
+//countWitnesses returns the number of distinct witnesses of the confirmed message msg that signed an ack for it with
+//the key returned by keyOf. keyOf returns false for the gossipers that are not members, whose acks are not counted.
+func countWitnesses(msg *packet.TLCMessage, keyOf func(origin string) ([]byte, bool)) int {
+	return len(witnessesOf(msg, keyOf))
+}
+
+//witnessesOf returns the names of the witnesses counted by countWitnesses, in the order of their acks
+func witnessesOf(msg *packet.TLCMessage, keyOf func(origin string) ([]byte, bool)) []string {
+	hash := msg.Witnessed()
+	witnesses := make([]string, 0, len(msg.Witnesses))
+	counted := make(map[string]bool)
+	for i := range msg.Witnesses {
+		ack := &msg.Witnesses[i]
+		if counted[ack.Origin] || ack.Destination != msg.Origin || ack.ID != uint32(msg.Confirmed) || !bytes.Equal(ack.Hash, hash[:]) {
+			continue
+		}
+
+		if key, ok := keyOf(ack.Origin); ok && bytes.Equal(key, ack.PublicKey) && ack.Verify() {
+			counted[ack.Origin] = true
+			witnesses = append(witnesses, ack.Origin)
+		}
+	}
+	return witnesses
+}
+
 //TLCAckRoutine handles the incoming acks, either by updating the majority counter if
 //the gossiper is the destination or by forwarding the ack to the next hop
 //ack *packet.TLCAck is the received ack
-func (g *Gossiper) TLCAckRoutine(ack *packet.TLCAck) {
+//from net.Addr is the peer from which the ack arrived, nil if the ack was created by the gossiper
+func (g *Gossiper) TLCAckRoutine(ack *packet.TLCAck, from net.Addr) {
 	if ack.Destination == g.Name {
+		g.TLCMajority.Lock()
 		g.TLCMajority.AddNewAck(ack)
+		g.TLCMajority.Unlock()
 	} else if ack.HopLimit > 0 {
 		ack.HopLimit -= 1
 
-		g.DSDV.Mutex.RLock()
-		if g.DSDV.Contains(ack.Destination) {
-			nextHop := g.DSDV.NextHop[ack.Destination]
+		if nextHop := g.nextHop(ack.Destination, from); nextHop != nil {
 			gossipPacket := &packet.GossipPacket{Ack: ack}
 			g.sendMessage(gossipPacket, nextHop)
 		}
-		g.DSDV.Mutex.RUnlock()
 
 	}
 }
@@ -340,22 +646,20 @@ func (g *Gossiper) TLCAckRoutine(ack *packet.TLCAck) {
 func (g *Gossiper) TryNextRound() {
 	tm := g.TLCMajority
 	confirmed := tm.Confirmed[tm.MyRound]
-	if len(confirmed) > tm.Total/2 && tm.ReicvCommand {
+	majority := tm.majority(tm.MyRound)
+	if len(confirmed) > majority && tm.ReicvCommand {
 
+		round := tm.MyRound
 		tm.MyRound += 1
 		g.PrintNextRound(tm.MyRound, confirmed)
 
 
 
-		if len(tm.Acks[tm.LastID]) <= tm.Total/2 {
-			tm.AcksChannels[tm.LastID] <- false
+		if len(tm.Acks[tm.LastID]) <= majority {
+			tm.signal(tm.LastID, false)
 		}
 
-		if metdata := tm.Queue.Dequeue(); metdata != nil {
-			g.BroadcastNewFile(metdata)
-		} else {
-			tm.ReicvCommand = false
-		}
+		g.qscStep(round)
 
 	}
 }
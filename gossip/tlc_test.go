@@ -0,0 +1,94 @@
+package gossip
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/packet"
+)
+
+//membersOf returns the members of the founders, with their keys
+func membersOf(founders []blockchain.Founder) blockchain.Members {
+	members := make(blockchain.Members)
+	for _, founder := range founders {
+		members[founder.Name] = founder.PublicKey
+	}
+	return members
+}
+
+func TestCountWitnesses(t *testing.T) {
+	members := testMembers(t, "A", "B", "C", "D")
+	a, b, c, d := members[0], members[1], members[2], members[3]
+	outsider := testMembers(t, "E")[0]
+	impostor := testMember{name: "B", sk: outsider.sk}
+
+	g := testGossiper(founders(members), 0)
+	keyOf := func(origin string) ([]byte, bool) {
+		return g.memberKey(membersOf(founders(members)), origin)
+	}
+
+	tampered := confirmation(t, a, 0, b)
+	tampered.Witnesses[0].ID += 1
+	otherMessage := confirmation(t, a, 0, c)
+	otherMessage.Fitness = 0.9
+	otherDestination := confirmation(t, a, 0, d)
+	otherDestination.Origin = "C"
+
+	cases := map[string]struct {
+		msg       packet.TLCMessage
+		witnesses int
+	}{
+		"members":           {confirmation(t, a, 0, a, b, c, d), 4},
+		"duplicated":        {confirmation(t, a, 0, b, b, c), 2},
+		"outsider":          {confirmation(t, a, 0, b, outsider), 1},
+		"impostor":          {confirmation(t, a, 0, impostor, c), 1},
+		"tampered ack":      {tampered, 0},
+		"other message":     {otherMessage, 0},
+		"other destination": {otherDestination, 0},
+	}
+
+	for name, tc := range cases {
+		msg := tc.msg
+		if n := countWitnesses(&msg, keyOf); n != tc.witnesses {
+			t.Errorf("%s: %d witnesses, expected %d", name, n, tc.witnesses)
+		}
+	}
+}
+
+func TestIsFromMember(t *testing.T) {
+	members := testMembers(t, "A", "B", "C")
+	a, b := members[0], members[1]
+	outsider := testMembers(t, "D")[0]
+
+	g := testGossiper(founders(members), 0)
+	current := membersOf(founders(members))
+
+	msg := confirmation(t, a, 0, a, b)
+	if !g.isFromMember(&msg, current) {
+		t.Fatal("confirmation of a member rejected")
+	}
+
+	modified := confirmation(t, a, 0, a, b)
+	modified.Fitness = 0.9
+	if g.isFromMember(&modified, current) {
+		t.Fatal("modified confirmation accepted")
+	}
+
+	impostor := confirmation(t, testMember{name: "A", sk: outsider.sk}, 0, a, b)
+	if g.isFromMember(&impostor, current) {
+		t.Fatal("confirmation signed with another key accepted")
+	}
+
+	fromOutsider := confirmation(t, outsider, 0, a, b)
+	if g.isFromMember(&fromOutsider, current) {
+		t.Fatal("confirmation of an outsider accepted")
+	}
+
+	//the key attached to the message must be the key of the member, even if the signature matches it
+	forged := confirmation(t, a, 0, a, b)
+	forged.PublicKey = outsider.sk.Public().(ed25519.PublicKey)
+	if g.isFromMember(&forged, current) {
+		t.Fatal("confirmation carrying another key accepted")
+	}
+}
@@ -0,0 +1,162 @@
+package identity
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+const X25519_KEY_SIZE = 32
+
+//NONCE_SIZE and TAG_SIZE are the sizes of the AES-GCM nonce and authentication tag: a ciphertext of Encrypt is TAG_SIZE
+//bytes longer than its plaintext, and a ciphertext of Seal is NONCE_SIZE+TAG_SIZE bytes longer.
+const (
+	NONCE_SIZE = 12
+	TAG_SIZE   = 16
+)
+
+var ErrDecryption = errors.New("unable to decrypt the message")
+
+//Encrypt encrypts plaintext for the owner of the X25519 public key recipient.
+//A fresh ephemeral key is used for each message, the symmetric key is derived from the shared secret
+//and the message is sealed with AES-256-GCM.
+//It returns the ephemeral public key, the nonce and the ciphertext.
+func Encrypt(recipient, plaintext []byte) ([]byte, []byte, []byte, error) {
+	recipientKey, err := ecdh.X25519().NewPublicKey(recipient)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	shared, err := ephemeral.ECDH(recipientKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	aead, err := newAEAD(shared, ephemeral.PublicKey().Bytes(), recipient)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return ephemeral.PublicKey().Bytes(), nonce, aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+//Decrypt decrypts a ciphertext produced by Encrypt for this identity.
+func (id *Identity) Decrypt(ephemeral, nonce, ciphertext []byte) ([]byte, error) {
+	ephemeralKey, err := ecdh.X25519().NewPublicKey(ephemeral)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	shared, err := id.EncryptionKey.ECDH(ephemeralKey)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	aead, err := newAEAD(shared, ephemeral, id.EncryptionPublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrDecryption
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return plaintext, nil
+}
+
+//newAEAD derives the symmetric key from the shared secret and both public keys and returns the AES-GCM cipher.
+func newAEAD(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	hasher := sha256.New()
+	hasher.Write(shared)
+	hasher.Write(ephemeral)
+	hasher.Write(recipient)
+
+	block, err := aes.NewCipher(hasher.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//SYMMETRIC_KEY_SIZE is the size of the keys used by Seal, Open and Scramble (AES-256)
+const SYMMETRIC_KEY_SIZE = 32
+
+//NewSymmetricKey returns a fresh random key for Seal, Open and Scramble.
+func NewSymmetricKey() ([]byte, error) {
+	key := make([]byte, SYMMETRIC_KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+//Seal encrypts and authenticates plaintext with the symmetric key using AES-256-GCM.
+//The random nonce is prepended to the ciphertext.
+func Seal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+//Open decrypts a ciphertext produced by Seal with the same key.
+func Open(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecryption
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return plaintext, nil
+}
+
+//Scramble XORs data with the AES-256-CTR key stream of key and returns the result. Scrambling twice with the same key
+//gives the original data back and the key streams of different keys commute, so the layers can be removed in any order.
+//A key must only be used for one message since the IV is always zero.
+func Scramble(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	scrambled := make([]byte, len(data))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(scrambled, data)
+	return scrambled, nil
+}
@@ -0,0 +1,91 @@
+package identity
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const PATH_KEYS = "./_Keys/"
+
+//Identity is the cryptographic identity of a gossiper.
+//Name       string is the name of the gossiper
+//SigningKey ed25519.PrivateKey is the private key used to sign every message originated by the gossiper
+//EncryptionKey *ecdh.PrivateKey is the X25519 private key used to decrypt the messages sent to the gossiper.
+//It is derived from the seed of the signing key.
+type Identity struct {
+	Name          string
+	SigningKey    ed25519.PrivateKey
+	EncryptionKey *ecdh.PrivateKey
+}
+
+//LoadOrGenerate loads the identity of the gossiper called name from PATH_KEYS.
+//If the gossiper has no key yet, a new keypair is generated and its seed is stored in PATH_KEYS/<name>.key
+func LoadOrGenerate(name string) (*Identity, error) {
+	path := PATH_KEYS + name + ".key"
+
+	content, err := ioutil.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, &KeyFormatError{Path: path}
+		}
+		return identityFromKey(name, ed25519.NewKeyFromSeed(seed))
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, sk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(PATH_KEYS, 0700); err != nil {
+		return nil, err
+	}
+
+	if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(sk.Seed())+"\n"), 0600); err != nil {
+		return nil, err
+	}
+
+	return identityFromKey(name, sk)
+}
+
+//identityFromKey builds the identity from the signing key and derives the encryption key from its seed.
+func identityFromKey(name string, sk ed25519.PrivateKey) (*Identity, error) {
+	derived := sha256.Sum256(append(sk.Seed(), []byte("encryption")...))
+	encryptionKey, err := ecdh.X25519().NewPrivateKey(derived[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Name:          name,
+		SigningKey:    sk,
+		EncryptionKey: encryptionKey,
+	}, nil
+}
+
+//PublicKey returns the public key of the identity
+func (id *Identity) PublicKey() ed25519.PublicKey {
+	return id.SigningKey.Public().(ed25519.PublicKey)
+}
+
+//EncryptionPublicKey returns the X25519 public key that the other gossipers use to encrypt messages for this identity
+func (id *Identity) EncryptionPublicKey() []byte {
+	return id.EncryptionKey.PublicKey().Bytes()
+}
+
+//KeyFormatError is returned when a key file cannot be parsed.
+type KeyFormatError struct {
+	Path string
+}
+
+func (e *KeyFormatError) Error() string {
+	return "malformed key file " + e.Path
+}
@@ -0,0 +1,141 @@
+package identity
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"github.com/somecookie/Peerster/helper"
+	"os"
+	"strings"
+	"sync"
+)
+
+//KeyStore maps the name of each known origin to its public key.
+//Keys are learned trust-on-first-use: the first key seen for an origin is kept and every
+//later message of that origin must verify against it.
+//All operations on the KeyStore are thread-safe.
+//keys map[string]ed25519.PublicKey is the mapping between an origin and its public key
+//encryptionKeys map[string][]byte is the mapping between an origin and its X25519 public key
+//path string is the file where the learned keys are appended
+type KeyStore struct {
+	sync.RWMutex
+	keys           map[string]ed25519.PublicKey
+	encryptionKeys map[string][]byte
+	path           string
+}
+
+//KeyStoreFactory creates the KeyStore of the gossiper called name and loads the keys
+//it has already learned from PATH_KEYS/<name>.known
+func KeyStoreFactory(name string) (*KeyStore, error) {
+	ks := &KeyStore{
+		RWMutex:        sync.RWMutex{},
+		keys:           make(map[string]ed25519.PublicKey),
+		encryptionKeys: make(map[string][]byte),
+		path:           PATH_KEYS + name + ".known",
+	}
+
+	file, err := os.Open(ks.path)
+	if os.IsNotExist(err) {
+		return ks, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, &KeyFormatError{Path: ks.path}
+		}
+		ks.keys[fields[0]] = key
+	}
+
+	return ks, scanner.Err()
+}
+
+//Get returns the public key of origin if it is known
+func (ks *KeyStore) Get(origin string) (ed25519.PublicKey, bool) {
+	ks.RLock()
+	defer ks.RUnlock()
+
+	key, ok := ks.keys[origin]
+	return key, ok
+}
+
+//Learn binds key to origin if origin is not known yet.
+//It returns false if origin is already bound to a different key.
+func (ks *KeyStore) Learn(origin string, key []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+
+	ks.Lock()
+	defer ks.Unlock()
+
+	if known, ok := ks.keys[origin]; ok {
+		return known.Equal(ed25519.PublicKey(key))
+	}
+
+	ks.keys[origin] = append(ed25519.PublicKey{}, key...)
+	ks.persist(origin, key)
+	return true
+}
+
+//GetEncryptionKey returns the X25519 public key of origin if it has already been announced
+func (ks *KeyStore) GetEncryptionKey(origin string) ([]byte, bool) {
+	ks.RLock()
+	defer ks.RUnlock()
+
+	key, ok := ks.encryptionKeys[origin]
+	return key, ok
+}
+
+//SetEncryptionKey records the X25519 public key announced by origin.
+//The announcement must come from a message whose signature has already been verified.
+func (ks *KeyStore) SetEncryptionKey(origin string, key []byte) {
+	if len(key) != X25519_KEY_SIZE {
+		return
+	}
+
+	ks.Lock()
+	defer ks.Unlock()
+
+	ks.encryptionKeys[origin] = append([]byte{}, key...)
+}
+
+//persist appends a newly learned key to the keystore file.
+func (ks *KeyStore) persist(origin string, key []byte) {
+	if err := os.MkdirAll(PATH_KEYS, 0700); err != nil {
+		helper.LogError(err)
+		return
+	}
+
+	file, err := os.OpenFile(ks.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		helper.LogError(err)
+		return
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s %s\n", origin, hex.EncodeToString(key))
+	helper.LogError(err)
+}
+
+//Origins returns the names of all the origins whose key is known
+func (ks *KeyStore) Origins() []string {
+	ks.RLock()
+	defer ks.RUnlock()
+
+	origins := make([]string, 0, len(ks.keys))
+	for origin := range ks.keys {
+		origins = append(origins, origin)
+	}
+	return origins
+}
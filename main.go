@@ -2,13 +2,19 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"github.com/somecookie/Peerster/clock"
 	"github.com/somecookie/Peerster/gossip"
 	"github.com/somecookie/Peerster/helper"
-	"net"
+	"github.com/somecookie/Peerster/storage"
+	"github.com/somecookie/Peerster/transport"
+	"os"
 	"strings"
+	"time"
 )
 
 var g *gossip.Gossiper
+var faults *transport.FaultyTransport
 var guiPort string
 var runGUI bool
 var rtimer int
@@ -19,6 +25,24 @@ var stubbornTimeout int
 var ackAll bool
 var hw3ex2 bool
 var hw3ex3 bool
+var storageKind string
+var downloadWindow int
+var transportKind string
+var faultsSpec string
+var partitionsSpec string
+var mining bool
+var miners int
+var difficulty int
+var join bool
+var faultThreshold int
+var verifyLedger bool
+var routeExpiry int
+var routingKind string
+var probeTimer int
+var onionRelays int
+var mailboxesStr string
+var mailboxTimer int
+var foundersStr string
 
 func init() {
 	uiPort := flag.String("UIPort", "8080", "port for the UI client (default \"8080\")")
@@ -29,30 +53,129 @@ func init() {
 	simple := flag.Bool("simple", false, "run gossip in simple broadcast mode")
 	flag.IntVar(&antiEntropy,"antiEntropy", 10, "time in seconds for the anti-entropy (default 10 seconds)")
 	flag.IntVar(&rtimer,"rtimer", 0, "Timeout in seconds to send route rumors. 0 (default) means disable sending route rumors")
+	flag.IntVar(&routeExpiry, "routeExpiry", 3, "number of route rumor intervals after which a route that was not refreshed expires. 0 means that the routes never expire")
+	flag.StringVar(&routingKind, "routing", "dsdv", "routing scheme used to forward the point-to-point messages: dsdv or linkstate (needs -rtimer > 0)")
+	flag.IntVar(&probeTimer, "probeTimer", 5, "time in seconds between two probes of the peers used as next hops. 0 disables the probes")
+	flag.IntVar(&onionRelays, "onionRelays", 3, "number of relays of the path of an anonymous message and of its reply")
+	flag.StringVar(&mailboxesStr, "mailboxes", "", "comma separated list of the names of the peers at which the undeliverable private messages are deposited")
+	flag.IntVar(&mailboxTimer, "mailboxTimer", 5, "time in seconds between two attempts to deliver the undelivered private messages. 0 disables the retries")
 	flag.IntVar(&hoplimit,"hoplimit", 10, "Hoplimit for the TLCMessage")
 	flag.IntVar(&N,"N", 1, "Number of gossipers of the network")
+	flag.StringVar(&foundersStr, "founders", "", "comma separated list of the N founding members of TLC, of the form name or name:key (hex encoded public key). Needed when N > 1")
+	flag.BoolVar(&join, "join", false, "the gossiper is not one of the N founding members of TLC and asks to join them")
+	flag.IntVar(&faultThreshold, "faultThreshold", 0, "maximal number f of faulty members tolerated by TLC: the majorities need more than 2f+1 witnesses")
 	flag.IntVar(&stubbornTimeout,"stubbornTimeout", 5, "Time in seconds before a gossiper stubbornly resend a TLCMessage")
 	flag.BoolVar(&runGUI, "runGUI", false, "allow to access a gui from this gossiper")
 	flag.BoolVar(&ackAll, "ackAll", false, "run as in hmw3ex2")
 	flag.BoolVar(&hw3ex2, "hw3ex2", false, "???")
 	flag.BoolVar(&hw3ex3, "hw3ex3", false, "???")
+	flag.IntVar(&downloadWindow, "downloadWindow", 4, "maximal number of chunk requests in flight per download")
+	flag.StringVar(&transportKind, "transport", "udp", "transport used to communicate with the other gossipers: udp, tcp or memory")
+	flag.StringVar(&faultsSpec, "faults", "", "faults injected on the links, e.g. \"drop=0.1,latency=50ms;peer=127.0.0.1:5001,drop=1\" (keys: peer, drop, latency, jitter, reorder, duplicate)")
+	flag.StringVar(&partitionsSpec, "partitions", "", "scheduled partitions of the form start/duration/peer1,peer2 separated by semicolons, e.g. \"10s/30s/127.0.0.1:5001\"")
+	flag.BoolVar(&mining, "mining", false, "publish the files by mining blocks instead of using TLC")
+	flag.IntVar(&miners, "miners", 1, "number of mining goroutines in mining mode")
+	flag.IntVar(&difficulty, "difficulty", 16, "number of leading zero bits of the hash of a mined block")
+	flag.StringVar(&storageKind, "storage", "none", "storage backend used to persist the state: none, memory or file (stored in ./_State/)")
+	flag.BoolVar(&verifyLedger, "verifyLedger", false, "verify offline the ledger log of the gossiper stored in ./_State/<name>.ledger and exit (mined blocks are checked with -difficulty, the confirmations with -N, -founders and -faultThreshold)")
 	flag.Parse()
-	handleFlags(*peersStr, *gossipAddr, *uiPort, *name, *simple)
+
+	if !mining {
+		miners = 0
+	}
+
+	config := getConfig(getPeersAddr(*peersStr, *gossipAddr), *gossipAddr, *uiPort, *name, *simple)
+	if verifyLedger {
+		handleVerifyLedger(config)
+	}
+	handleFlags(config)
 
 
 }
 
-func handleFlags(peersStr string, gossipAddr string, uiPort string, name string, simple bool) {
-	peers := getPeersAddr(peersStr, gossipAddr)
-	var err error
-	g, err = gossip.GossiperFactory(gossipAddr, uiPort, name, peers, simple, ackAll, antiEntropy, rtimer, hoplimit, N,stubbornTimeout)
+func handleFlags(config gossip.Config) {
+	clk := clock.RealClockFactory()
+	gossipTransport, err := transport.TransportFactory(transportKind, config.GossipAddr, clk)
+	helper.HandleCrashingErr(err)
+
+	faults = transport.FaultyTransportFactory(gossipTransport, clk, time.Now().UnixNano())
+	handleFaults(faultsSpec, partitionsSpec)
+
+	g, err = gossip.GossiperFactory(config, faults, clk)
 	helper.HandleCrashingErr(err)
 }
 
-func getPeersAddr(peersStr, gossipAddr string) []*net.UDPAddr {
+//getConfig returns the configuration of the gossiper given by the flags
+func getConfig(peers []string, gossipAddr string, uiPort string, name string, simple bool) gossip.Config {
+	return gossip.Config{
+		GossipAddr:      gossipAddr,
+		UIPort:          uiPort,
+		Name:            name,
+		Peers:           peers,
+		Simple:          simple,
+		AckAll:          ackAll,
+		AntiEntropy:     antiEntropy,
+		RTimer:          rtimer,
+		HopLimit:        hoplimit,
+		N:               N,
+		Founders:        getNames(foundersStr),
+		StubbornTimeout: stubbornTimeout,
+		StorageKind:     storageKind,
+		DownloadWindow:  downloadWindow,
+		Miners:          miners,
+		Difficulty:      difficulty,
+		Join:            join,
+		FaultThreshold:  faultThreshold,
+		RouteExpiry:     routeExpiry,
+		Routing:         routingKind,
+		ProbeTimer:      probeTimer,
+		OnionRelays:     onionRelays,
+		Mailboxes:       getNames(mailboxesStr),
+		MailboxTimer:    mailboxTimer,
+		Seed:            time.Now().UnixNano(),
+	}
+}
+
+//handleVerifyLedger checks the ledger log of the gossiper of config without starting it, prints the result and exits
+func handleVerifyLedger(config gossip.Config) {
+	if _, err := os.Stat(storage.LedgerPath(config.Name)); err != nil {
+		fmt.Println("LEDGER INVALID " + err.Error())
+		os.Exit(1)
+	}
+
+	backend, err := storage.LedgerBackendFactory("file", config.Name)
+	helper.HandleCrashingErr(err)
+
+	summary, err := gossip.VerifyLedger(backend, config)
+	if err != nil {
+		fmt.Println("LEDGER INVALID " + err.Error())
+		backend.Close()
+		os.Exit(1)
+	}
+	fmt.Println(summary)
+	backend.Close()
+	os.Exit(0)
+}
+
+//handleFaults configures the faults injected on the gossip transport from the flags
+func handleFaults(faultsSpec, partitionsSpec string) {
+	links, err := transport.ParseFaults(faultsSpec)
+	helper.HandleCrashingErr(err)
+	for peer, linkFaults := range links {
+		faults.SetLink(peer, linkFaults)
+	}
+
+	partitions, err := transport.ParsePartitions(partitionsSpec)
+	helper.HandleCrashingErr(err)
+	for _, partition := range partitions {
+		faults.SchedulePartition(partition)
+	}
+}
+
+func getPeersAddr(peersStr, gossipAddr string) []string {
 	tab := strings.Split(peersStr, ",")
 
-	peers := make([]*net.UDPAddr, 0)
+	peers := make([]string, 0)
 	if len(tab) == 1 && tab[0] == "" {
 		return peers
 	}
@@ -61,17 +184,27 @@ func getPeersAddr(peersStr, gossipAddr string) []*net.UDPAddr {
 		if addr == gossipAddr {
 			continue
 		}
-		udpAddr, err := net.ResolveUDPAddr("udp4", addr)
-		if err == nil {
-			peers = append(peers, udpAddr)
-		}
+		peers = append(peers, addr)
 	}
 
 	return peers
 }
 
+//getNames parses a comma separated list of names (of the mailbox peers or of the founders)
+func getNames(namesStr string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(namesStr, ",") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
 func main() {
 
+	g.ResumeDownloads()
 	go g.GossiperListener()
 
 	if antiEntropy > 0{
@@ -82,6 +215,20 @@ func main() {
 		go g.RouteRumorRoutine()
 	}
 
+	if probeTimer > 0 {
+		go g.ProbeRoutine()
+	}
+
+	if mailboxTimer > 0 {
+		go g.MailboxRoutine()
+	}
+
+	g.StartMining()
+
+	if join {
+		go g.ChangeMembership(true)
+	}
+
 
 	if runGUI {
 		go HandleServerGUI()
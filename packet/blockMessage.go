@@ -0,0 +1,30 @@
+package packet
+
+import (
+	"fmt"
+	"github.com/somecookie/Peerster/blockchain"
+)
+
+const TX_HOP_LIMIT = 10
+const BLOCK_HOP_LIMIT = 20
+
+//TxPublishMessage gossips a transaction to the miners
+//Transaction blockchain.TxPublish is the published file
+//HopLimit    uint32 is the TTL of this packet
+type TxPublishMessage struct {
+	Transaction blockchain.TxPublish
+	HopLimit    uint32
+}
+
+//BlockMessage gossips a mined block
+//Block    blockchain.Block is the mined block
+//HopLimit uint32 is the TTL of this packet
+type BlockMessage struct {
+	Block    blockchain.Block
+	HopLimit uint32
+}
+
+//PrintFoundBlock prints the message "FOUND-BLOCK <hash>" when the gossiper mines a new block
+func PrintFoundBlock(hash [32]byte) {
+	fmt.Printf("FOUND-BLOCK %s\n", blockchain.HashString(hash))
+}
@@ -0,0 +1,40 @@
+package packet
+
+import "fmt"
+
+//CATCHUP_MAX_ROUNDS is the maximal number of round certificates sent in one TLCCatchUpReply.
+//A node that is further behind asks again once it applied them.
+const CATCHUP_MAX_ROUNDS = 10
+
+//TLCCatchUpRequest is sent by a lagging node to its peers to get the certificates of the rounds it missed
+//Origin string is the name of the lagging node
+//Round  uint32 is the round the lagging node is in
+type TLCCatchUpRequest struct {
+	Origin string
+	Round  uint32
+}
+
+//RoundCertificate proves that a round ended: the confirmations are signed by more than a majority of the members
+//Round         uint32
+//Members       []string are the names of the members in the round
+//Confirmations []TLCMessage are the confirmed messages of the round
+type RoundCertificate struct {
+	Round         uint32
+	Members       []string
+	Confirmations []TLCMessage
+}
+
+//TLCCatchUpReply answers a TLCCatchUpRequest with the certificates of the consecutive rounds starting at the requested round
+//Origin       string is the name of the node answering
+//Round        uint32 is the round the answering node is in
+//Certificates []RoundCertificate
+type TLCCatchUpReply struct {
+	Origin       string
+	Round        uint32
+	Certificates []RoundCertificate
+}
+
+//PrintCaughtUp prints the message "CAUGHT UP TO <round> round WITH <origin>" when the gossiper fast-forwarded its round
+func PrintCaughtUp(round uint32, origin string) {
+	fmt.Printf("CAUGHT UP TO %d round WITH %s\n", round, origin)
+}
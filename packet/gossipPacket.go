@@ -1,8 +1,10 @@
 package packet
 
 import (
+	"fmt"
 	"github.com/dedis/protobuf"
 	"github.com/somecookie/Peerster/helper"
+	"net"
 )
 
 
@@ -19,6 +21,30 @@ type GossipPacket struct {
 	SearchReply   *SearchReply
 	TLCMessage    *TLCMessage
 	Ack           *TLCAck
+	TxPublish     *TxPublishMessage
+	Block         *BlockMessage
+	CatchUp       *TLCCatchUpRequest
+	Certificates  *TLCCatchUpReply
+	LinkState     *LinkStateMessage
+	Probe         *Probe
+	Onion         *OnionMessage
+	Delivery      *DeliveryAck
+	Deposit       *MailboxDeposit
+}
+
+//Payloads returns the number of messages carried by the packet. A valid packet carries exactly one of them.
+func (gp *GossipPacket) Payloads() int {
+	payloads := 0
+	for _, present := range []bool{gp.Simple != nil, gp.Rumor != nil, gp.Status != nil, gp.Private != nil,
+		gp.DataRequest != nil, gp.DataReply != nil, gp.SearchRequest != nil, gp.SearchReply != nil,
+		gp.TLCMessage != nil, gp.Ack != nil, gp.TxPublish != nil, gp.Block != nil, gp.CatchUp != nil,
+		gp.Certificates != nil, gp.LinkState != nil, gp.Probe != nil, gp.Onion != nil, gp.Delivery != nil,
+		gp.Deposit != nil} {
+		if present {
+			payloads += 1
+		}
+	}
+	return payloads
 }
 
 //GetPacketBytes serializes the GossipPacket message
@@ -50,4 +76,34 @@ func (gp *GossipPacket) GetOriginAndID() (string, uint32){
 	}else{
 		return "",0
 	}
-}
\ No newline at end of file
+}
+
+//AddHop counts the hop made by the rumor or TLC message to reach the gossiper.
+//It returns the number of hops made by the message from its origin.
+func (gp *GossipPacket) AddHop() uint32 {
+	if gp.Rumor != nil {
+		gp.Rumor.HopCount += 1
+		return gp.Rumor.HopCount
+	} else if gp.TLCMessage != nil {
+		gp.TLCMessage.HopCount += 1
+		return gp.TLCMessage.HopCount
+	}
+	return 0
+}
+
+//PrintInvalidSignature prints the required message when a packet is dropped because of its signature
+func PrintInvalidSignature(gp *GossipPacket, peerAddr net.Addr) {
+	origin, ID := gp.GetOriginAndID()
+	if gp.Private != nil {
+		origin = gp.Private.Origin
+	} else if gp.Ack != nil {
+		origin, ID = gp.Ack.Origin, gp.Ack.ID
+	} else if gp.LinkState != nil {
+		origin, ID = gp.LinkState.Origin, gp.LinkState.Seq
+	} else if gp.Delivery != nil {
+		origin, ID = gp.Delivery.Origin, gp.Delivery.ID
+	} else if gp.Deposit != nil {
+		origin, ID = gp.Deposit.Message.Origin, gp.Deposit.Message.ID
+	}
+	fmt.Printf("INVALID SIGNATURE origin %s ID %d from %s\n", origin, ID, peerAddr.String())
+}
@@ -0,0 +1,17 @@
+package packet
+
+//LinkStateMessage is flooded by every gossiper in link-state routing mode to advertise its neighbors
+//Origin    string is the name of the gossiper
+//Address   string is the address (ip:port) of the gossiper
+//Seq       uint32 is incremented by the origin with each advertisement. Only the advertisement with the highest Seq is kept.
+//Neighbors []string are the addresses (ip:port) of the peers of the gossiper
+//PublicKey []byte is the ed25519 public key of the origin
+//Signature []byte is the signature of the origin over all the other fields
+type LinkStateMessage struct {
+	Origin    string
+	Address   string
+	Seq       uint32
+	Neighbors []string
+	PublicKey []byte
+	Signature []byte
+}
@@ -0,0 +1,39 @@
+package packet
+
+import "fmt"
+
+//DeliveryAck is sent by the destination of a private message to acknowledge its delivery, to the origin of the message
+//and to the mailbox peer that redelivered it
+//Origin      string is the name of the destination of the acknowledged message
+//Destination string is the name of the gossiper receiving the ack
+//Sender      string is the origin of the acknowledged message
+//ID          uint32 is the ID of the acknowledged message
+//HopLimit    uint32 denotes the number of nodes that can be reached before the ack is discarded
+//PublicKey   []byte is the ed25519 public key of Origin
+//Signature   []byte is the signature of Origin over all the other fields except the HopLimit
+type DeliveryAck struct {
+	Origin      string
+	Destination string
+	Sender      string
+	ID          uint32
+	HopLimit    uint32
+	PublicKey   []byte
+	Signature   []byte
+}
+
+//MailboxDeposit hands a private message that cannot be delivered to a mailbox peer. The mailbox peer stores it and
+//redelivers it when it has a route to its destination.
+//Mailbox  string is the name of the mailbox peer
+//HopLimit uint32 denotes the number of nodes that can be reached before the deposit is discarded
+//Message  PrivateMessage is the signed private message, possibly encrypted for its destination
+type MailboxDeposit struct {
+	Mailbox  string
+	HopLimit uint32
+	Message  PrivateMessage
+}
+
+//PrintMailbox prints the message "MAILBOX <event> origin <peer_name> destination <peer_name> ID <id>" when the
+//delivery status of a private message changes
+func PrintMailbox(event, origin, destination string, ID uint32) {
+	fmt.Printf("MAILBOX %s origin %s destination %s ID %d\n", event, origin, destination, ID)
+}
@@ -13,6 +13,15 @@ type Message struct {
 	Request     *[]byte
 	Keywords    *string
 	Budget      *uint64
+	Encrypt     bool
+	Download    *string
+	Registry    *string
+	Membership  *string
+	Unpublish   bool
+	Rename      *string
+	Transfer    *string
+	Anonymous   bool
+	Reply       *string
 }
 
 //GetMessage deserialize the n first bytes of buffer to get a GetMessage
@@ -27,8 +36,14 @@ func GetMessage(buffer []byte, n int) (*Message, error) {
 }
 
 func PrintClientMessage(message *Message) {
-	if message.Destination == nil {
+	if message.Reply != nil {
+		fmt.Printf("CLIENT MESSAGE %s reply %s\n", message.Text, *message.Reply)
+	} else if message.Destination == nil {
 		fmt.Printf("CLIENT MESSAGE %s\n", message.Text)
+	} else if message.Anonymous {
+		fmt.Printf("CLIENT MESSAGE %s dest %s anonymous\n", message.Text, *message.Destination)
+	} else if message.Encrypt {
+		fmt.Printf("CLIENT MESSAGE %s dest %s encrypted\n", message.Text, *message.Destination)
 	} else {
 		fmt.Printf("CLIENT MESSAGE %s dest %s\n", message.Text, *message.Destination)
 	}
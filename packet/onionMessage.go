@@ -0,0 +1,92 @@
+package packet
+
+import (
+	"fmt"
+	"github.com/dedis/protobuf"
+	"net"
+)
+
+//OnionMessage carries an anonymous message, or the reply to one, between two consecutive gossipers of its path.
+//It is routed like a private message, so the gossipers in between only learn the name of the next gossiper of the path.
+//Destination string is the name of the next gossiper of the path
+//HopLimit    uint32 denotes the number of nodes that can be reached before the message is discarded
+//Header      []byte is the header of the path. It has the same size at every hop: its first slot is the layer for
+//Destination, encrypted with identity.Encrypt, and the other slots are the layers of the next gossipers or random bytes.
+//Payload     []byte is the padded and sealed AnonymousMessage, scrambled with the key of each relay that did not handle
+//it yet (forward path) or that already handled it (reply path). It has the same size for every message.
+type OnionMessage struct {
+	Destination string
+	HopLimit    uint32
+	Header      []byte
+	Payload     []byte
+}
+
+//OnionLayer is what a gossiper of the path learns when it decrypts its layer of the header
+//Next      string is the name of the next gossiper of the path, empty if the gossiper is the end of the path
+//Key       []byte is the key with which a relay scrambles the payload, or the key with which the payload is sealed
+//for the destination of an anonymous message
+//HeaderKey []byte is the key with which a relay scrambles the rest of the header before sending it to Next
+//ReplyID   string identifies the reply block at the end of a reply path, which is its creator
+type OnionLayer struct {
+	Next      string
+	Key       []byte
+	HeaderKey []byte
+	ReplyID   string
+}
+
+//AnonymousMessage is the content of an OnionMessage, sealed for the end of the path
+//Text  string is the text of the message
+//Reply *ReplyBlock allows the destination to answer once, nil for a reply
+type AnonymousMessage struct {
+	Text  string
+	Reply *ReplyBlock
+}
+
+//ReplyBlock is a single-use reply block. It lets the destination of an anonymous message answer through a path
+//chosen by the sender, without learning who the sender is.
+//First  string is the name of the first relay of the reply path
+//Header []byte is the header of the reply path, whose first slot is the layer for First
+//Key    []byte is the key with which the reply is sealed
+type ReplyBlock struct {
+	First  string
+	Header []byte
+	Key    []byte
+}
+
+//GetOnionLayer deserializes a decrypted layer of an onion header
+func GetOnionLayer(buffer []byte) (*OnionLayer, error) {
+	layer := &OnionLayer{}
+	if err := protobuf.Decode(buffer, layer); err != nil {
+		return nil, err
+	}
+	return layer, nil
+}
+
+//GetAnonymousMessage deserializes the opened payload of an onion message
+func GetAnonymousMessage(buffer []byte) (*AnonymousMessage, error) {
+	message := &AnonymousMessage{}
+	if err := protobuf.Decode(buffer, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+//PrintAnonymousMessage prints the message "ANONYMOUS contents <text> reply <id>" when the gossiper receives an anonymous
+//message. The id of the reply block is "-" if the sender did not attach one.
+func PrintAnonymousMessage(text, replyID string) {
+	if replyID == "" {
+		replyID = "-"
+	}
+	fmt.Printf("ANONYMOUS contents %s reply %s\n", text, replyID)
+}
+
+//PrintAnonymousReply prints the message "ANONYMOUS REPLY from <peer_name> contents <text>" when the gossiper receives
+//the reply to one of its anonymous messages
+func PrintAnonymousReply(destination, text string) {
+	fmt.Printf("ANONYMOUS REPLY from %s contents %s\n", destination, text)
+}
+
+//PrintOnionRelay prints the message "ONION RELAY from <ip:port> to <peer_name>" when the gossiper relays an onion message
+func PrintOnionRelay(from net.Addr, next string) {
+	fmt.Printf("ONION RELAY from %s to %s\n", from.String(), next)
+}
@@ -4,20 +4,41 @@ import "fmt"
 
 //PrivateMessage represents the messages sent privately by the nodes using their names.
 //Origin (string) contains the identifier of the node sending the packet.
-//ID (uint32) is always set to which denotes no sequencing.
+//ID (uint32) numbers the private messages of the origin, so that their delivery can be acknowledged. 0 denotes no sequencing.
 //Text (string) contains the text of the private message
 //Destination (string) is the destination node identifier of the message
 //HopLimit (uint32) denotes the number of nodes that can be reached before the message is discarded
+//PublicKey ([]byte) is the ed25519 public key of the origin
+//EphemeralKey ([]byte) is the X25519 ephemeral public key of an encrypted message
+//Nonce ([]byte) is the AES-GCM nonce of an encrypted message
+//Ciphertext ([]byte) is the encrypted text. When it is set, Text is empty.
+//Mailbox (string) is the name of the mailbox peer redelivering the message, empty if the origin sent it
+//Signature ([]byte) is the signature of the origin over all the other fields except the HopLimit and the Mailbox
 type PrivateMessage struct {
-	Origin      string
-	ID          uint32
-	Text        string
-	Destination string
-	HopLimit    uint32
+	Origin       string
+	ID           uint32
+	Text         string
+	Destination  string
+	HopLimit     uint32
+	PublicKey    []byte
+	EphemeralKey []byte
+	Nonce        []byte
+	Ciphertext   []byte
+	Mailbox      string
+	Signature    []byte
 }
 
 var MaxHops uint32 = 10
 
+//IsEncrypted tells if the text of the private message is encrypted for its destination
+func (pm *PrivateMessage) IsEncrypted() bool {
+	return len(pm.Ciphertext) > 0
+}
+
 func PrintPrivateMessage(pm *PrivateMessage){
 	fmt.Printf("PRIVATE origin %s hop-limit %d contents %s\n", pm.Origin, pm.HopLimit, pm.Text)
 }
+
+func PrintUnknownEncryptionKey(destination string) {
+	fmt.Printf("UNKNOWN ENCRYPTION KEY for %s\n", destination)
+}
@@ -0,0 +1,9 @@
+package packet
+
+//Probe is sent to a peer to check that it can be used as next hop. The peer answers with the same ID and Reply set.
+//ID    uint32 identifies the probe
+//Reply bool is true for the answer to a probe
+type Probe struct {
+	ID    uint32
+	Reply bool
+}
@@ -6,20 +6,24 @@ import (
 )
 
 type RumorMessage struct {
-	Origin string //message's original sender
-	ID     uint32 //monotonically increasing sequence number assigned by the original sender
-	Text   string //content of the message
+	Origin        string //message's original sender
+	ID            uint32 //monotonically increasing sequence number assigned by the original sender
+	Text          string //content of the message
+	PublicKey     []byte //ed25519 public key of the origin
+	EncryptionKey []byte //X25519 public key used to encrypt private messages for the origin
+	Signature     []byte //signature of the origin over all the other fields except the HopCount
+	HopCount      uint32 //number of hops made by the message from its origin to the gossiper that archived it
 }
 
 func (rm *RumorMessage) String() string {
 	return fmt.Sprintf("Origin: %s\nID: %d\nText: %s", rm.Origin, rm.ID, rm.Text)
 }
 
-func PrintRumorMessage(message *RumorMessage, peerAddr *net.UDPAddr) {
+func PrintRumorMessage(message *RumorMessage, peerAddr net.Addr) {
 	fmt.Printf("RUMOR origin %s from %s ID %d contents %s\n", message.Origin, peerAddr.String(), message.ID, message.Text)
 }
 
-func PrintMongering(addr *net.UDPAddr) {
+func PrintMongering(addr net.Addr) {
 	fmt.Printf("MONGERING with %s\n", addr.String())
 }
 
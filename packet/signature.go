@@ -0,0 +1,182 @@
+package packet
+
+import (
+	"crypto/ed25519"
+	"github.com/dedis/protobuf"
+)
+
+//Sign signs the rumor message with the private key of its origin.
+//The public key of the origin is attached to the message so that it can be learned by the other peers.
+//The HopCount is not signed since it is incremented by every hop.
+func (rm *RumorMessage) Sign(sk ed25519.PrivateKey) error {
+	rm.PublicKey = sk.Public().(ed25519.PublicKey)
+
+	unsigned := *rm
+	unsigned.HopCount = 0
+	unsigned.Signature = nil
+
+	toSign, err := protobuf.Encode(&unsigned)
+	if err != nil {
+		return err
+	}
+
+	rm.Signature = ed25519.Sign(sk, toSign)
+	return nil
+}
+
+//Verify checks that the signature of the rumor message matches its attached public key.
+func (rm *RumorMessage) Verify() bool {
+	unsigned := *rm
+	unsigned.HopCount = 0
+	unsigned.Signature = nil
+
+	return verify(&unsigned, rm.PublicKey, rm.Signature)
+}
+
+//Sign signs the private message with the private key of its origin.
+//The HopLimit is not signed since it is decremented by every hop, nor the Mailbox since it is set by the mailbox peer.
+func (pm *PrivateMessage) Sign(sk ed25519.PrivateKey) error {
+	pm.PublicKey = sk.Public().(ed25519.PublicKey)
+
+	unsigned := *pm
+	unsigned.HopLimit = 0
+	unsigned.Mailbox = ""
+	unsigned.Signature = nil
+
+	toSign, err := protobuf.Encode(&unsigned)
+	if err != nil {
+		return err
+	}
+
+	pm.Signature = ed25519.Sign(sk, toSign)
+	return nil
+}
+
+//Verify checks that the signature of the private message matches its attached public key.
+func (pm *PrivateMessage) Verify() bool {
+	unsigned := *pm
+	unsigned.HopLimit = 0
+	unsigned.Mailbox = ""
+	unsigned.Signature = nil
+
+	return verify(&unsigned, pm.PublicKey, pm.Signature)
+}
+
+//Sign signs the TLC message with the private key of its origin.
+//The HopCount is not signed since it is incremented by every hop.
+func (tlc *TLCMessage) Sign(sk ed25519.PrivateKey) error {
+	tlc.PublicKey = sk.Public().(ed25519.PublicKey)
+
+	unsigned := *tlc
+	unsigned.HopCount = 0
+	unsigned.Signature = nil
+
+	toSign, err := protobuf.Encode(&unsigned)
+	if err != nil {
+		return err
+	}
+
+	tlc.Signature = ed25519.Sign(sk, toSign)
+	return nil
+}
+
+//Verify checks that the signature of the TLC message matches its attached public key.
+func (tlc *TLCMessage) Verify() bool {
+	unsigned := *tlc
+	unsigned.HopCount = 0
+	unsigned.Signature = nil
+
+	return verify(&unsigned, tlc.PublicKey, tlc.Signature)
+}
+
+//Sign signs the ack with the private key of the witness.
+//The HopLimit is not signed since it is decremented by every hop.
+func (ack *TLCAck) Sign(sk ed25519.PrivateKey) error {
+	ack.PublicKey = sk.Public().(ed25519.PublicKey)
+
+	unsigned := *ack
+	unsigned.HopLimit = 0
+	unsigned.Signature = nil
+
+	toSign, err := protobuf.Encode(&unsigned)
+	if err != nil {
+		return err
+	}
+
+	ack.Signature = ed25519.Sign(sk, toSign)
+	return nil
+}
+
+//Verify checks that the signature of the ack matches its attached public key.
+func (ack *TLCAck) Verify() bool {
+	unsigned := *ack
+	unsigned.HopLimit = 0
+	unsigned.Signature = nil
+
+	return verify(&unsigned, ack.PublicKey, ack.Signature)
+}
+
+//Sign signs the delivery ack with the private key of the destination of the acknowledged message.
+//The HopLimit is not signed since it is decremented by every hop.
+func (da *DeliveryAck) Sign(sk ed25519.PrivateKey) error {
+	da.PublicKey = sk.Public().(ed25519.PublicKey)
+
+	unsigned := *da
+	unsigned.HopLimit = 0
+	unsigned.Signature = nil
+
+	toSign, err := protobuf.Encode(&unsigned)
+	if err != nil {
+		return err
+	}
+
+	da.Signature = ed25519.Sign(sk, toSign)
+	return nil
+}
+
+//Verify checks that the signature of the delivery ack matches its attached public key.
+func (da *DeliveryAck) Verify() bool {
+	unsigned := *da
+	unsigned.HopLimit = 0
+	unsigned.Signature = nil
+
+	return verify(&unsigned, da.PublicKey, da.Signature)
+}
+
+//Sign signs the link-state advertisement with the private key of its origin.
+func (lsm *LinkStateMessage) Sign(sk ed25519.PrivateKey) error {
+	lsm.PublicKey = sk.Public().(ed25519.PublicKey)
+
+	unsigned := *lsm
+	unsigned.Signature = nil
+
+	toSign, err := protobuf.Encode(&unsigned)
+	if err != nil {
+		return err
+	}
+
+	lsm.Signature = ed25519.Sign(sk, toSign)
+	return nil
+}
+
+//Verify checks that the signature of the link-state advertisement matches its attached public key.
+func (lsm *LinkStateMessage) Verify() bool {
+	unsigned := *lsm
+	unsigned.Signature = nil
+
+	return verify(&unsigned, lsm.PublicKey, lsm.Signature)
+}
+
+//verify is an helper function that serializes the unsigned message and verifies the signature against key.
+func verify(unsigned interface{}, key, signature []byte) bool {
+	if len(key) != ed25519.PublicKeySize || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+
+	signed, err := protobuf.Encode(unsigned)
+	if err != nil {
+		return false
+	}
+
+	return ed25519.Verify(key, signed, signature)
+}
@@ -18,7 +18,7 @@ func (p PeerStatus) String() string {
 	return fmt.Sprintf("Identifier %s with ID %d", p.Identifier, p.NextID)
 }
 
-func PrintStatusPacket(packet *StatusPacket, peerAddr *net.UDPAddr) {
+func PrintStatusPacket(packet *StatusPacket, peerAddr net.Addr) {
 	s := fmt.Sprintf("STATUS from %s", peerAddr.String())
 	for _, peerStatus := range packet.Want {
 		//peer %s nextID %d
@@ -27,10 +27,10 @@ func PrintStatusPacket(packet *StatusPacket, peerAddr *net.UDPAddr) {
 	fmt.Println(s)
 }
 
-func PrintInSync(peerAddr *net.UDPAddr) {
+func PrintInSync(peerAddr net.Addr) {
 	fmt.Printf("IN SYNC WITH %s\n", peerAddr.String())
 }
 
-func PrintFlippedCoin(peerAddr *net.UDPAddr) {
+func PrintFlippedCoin(peerAddr net.Addr) {
 	fmt.Printf("FLIPPED COIN sending rumor to %s\n", peerAddr.String())
 }
@@ -1,11 +1,20 @@
 package packet
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"github.com/somecookie/Peerster/blockchain"
+	"math"
 )
 
+//TLCMessage is an unconfirmed message (Confirmed is -1) proposing a block, or the confirmation of the message
+//with ID Confirmed sent by the same origin once a majority acknowledged it.
+//Round is the TLC round of the unconfirmed message, so that the nodes agree on it even if some of them missed the
+//previous messages of the origin.
+//Witnesses are the signed acks of a confirmed message: they certify that enough members witnessed the unconfirmed message.
+//HopCount is the number of hops made by the message from its origin to the gossiper that archived it. It is not signed.
 type TLCMessage struct {
 	Origin      string
 	ID          uint32
@@ -13,9 +22,50 @@ type TLCMessage struct {
 	TxBlock     blockchain.BlockPublish
 	VectorClock *StatusPacket
 	Fitness     float32
+	Round       uint32
+	Witnesses   []TLCAck
+	PublicKey   []byte
+	Signature   []byte
+	HopCount    uint32
 }
 
-type TLCAck PrivateMessage
+//TLCAck is sent by a witness of an unconfirmed TLCMessage to its origin.
+//Origin      string is the name of the witness
+//ID          uint32 is the ID of the acknowledged message
+//Destination string is the origin of the acknowledged message
+//HopLimit    uint32
+//Hash        []byte is the hash of the acknowledged message given by TLCMessage.Witnessed
+//PublicKey   []byte is the ed25519 public key of the witness
+//Signature   []byte is the signature of the witness over all the other fields except the HopLimit
+type TLCAck struct {
+	Origin      string
+	ID          uint32
+	Destination string
+	HopLimit    uint32
+	Hash        []byte
+	PublicKey   []byte
+	Signature   []byte
+}
+
+//Witnessed returns the hash signed by the witnesses of an unconfirmed message. It covers the origin, the ID,
+//the round, the fitness and the block of the message. The confirmation of the message gives the same hash.
+func (tlc *TLCMessage) Witnessed() (out [32]byte) {
+	ID := tlc.ID
+	if tlc.Confirmed != -1 {
+		ID = uint32(tlc.Confirmed)
+	}
+
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, uint32(len(tlc.Origin)))
+	h.Write([]byte(tlc.Origin))
+	binary.Write(h, binary.LittleEndian, ID)
+	binary.Write(h, binary.LittleEndian, tlc.Round)
+	binary.Write(h, binary.LittleEndian, math.Float32bits(tlc.Fitness))
+	block := tlc.TxBlock.Hash()
+	h.Write(block[:])
+	copy(out[:], h.Sum(nil))
+	return
+}
 
 func PrintUnconfirmedMessage(message *TLCMessage) {
 	fmt.Printf("UNCONFIRMED GOSSIP origin %s ID %d file name %s size %d metahash %s\n",
@@ -26,6 +76,12 @@ func PrintSendingTLCAck(ack *TLCAck){
 	fmt.Printf("SENDING ACK origin %s ID %d\n", ack.Destination, ack.ID)
 }
 
+//PrintInvalidCertificate prints the message "INVALID CERTIFICATE origin <origin> ID <ID> witnesses <n>"
+//when a confirmed message is dropped because it has not enough valid witnesses
+func PrintInvalidCertificate(message *TLCMessage, witnesses int) {
+	fmt.Printf("INVALID CERTIFICATE origin %s ID %d witnesses %d\n", message.Origin, message.Confirmed, witnesses)
+}
+
 func PrintConfirmedMessage(message *TLCMessage){
 	fmt.Printf("CONFIRMED GOSSIP origin %s ID %d file name %s size %d metahash %s\n",
 		message.Origin, message.Confirmed, message.TxBlock.Transaction.Name, message.TxBlock.Transaction.Size, hex.EncodeToString(message.TxBlock.Transaction.MetafileHash))
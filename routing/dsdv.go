@@ -3,68 +3,202 @@ package routing
 import (
 	"fmt"
 	"net"
+	"sort"
 	"sync"
+	"time"
 )
 
+//Route is an entry of the DSDV routing table.
+//NextHop   net.Addr is the peer to which the packets for the destination are sent
+//Seq       uint32 is the destination sequence number: the highest ID of the messages coming from the destination
+//Hops      uint32 is the number of hops to the destination through NextHop
+//Refreshed time.Time is the last time a message of the destination refreshed the route
+//Valid     bool is false once the route expired. An expired route is kept to remember its sequence number.
+type Route struct {
+	NextHop   net.Addr
+	Seq       uint32
+	Hops      uint32
+	Refreshed time.Time
+	Valid     bool
+}
+
 //This structures contains all the needed data structures to implement the DSDV routing scheme.
-//The methods of DSDV are not thread-safe.
-//NextHop is the next-hop routing table. It maps the destination to the next hop.
-//DstSeq maps a destination to it destination sequence number.
-//The destination sequence number is the highest ID of the RumorMessage coming from the destination.
+//The next hop of a destination is the peer that sent the last message of the destination.
+//The methods of DSDV are thread-safe.
+//NextHops is the next-hop routing table. It maps the destination to the next hop of its valid route.
+//Routes maps a destination to its route, valid or expired.
+//Paths maps a destination to the candidate routes through each of the peers that sent messages of the destination,
+//by address of the peer. They are the alternatives to the route of the destination.
+//MaxAge is the time after which a route that was not refreshed expires. 0 means that the routes never expire.
 type DSDV struct{
-	NextHop map[string]*net.UDPAddr
-	DstSeq map[string]uint32
-	Mutex sync.RWMutex
+	NextHops map[string]net.Addr
+	Routes   map[string]*Route
+	Paths    map[string]map[string]*Route
+	MaxAge   time.Duration
+	Mutex    sync.RWMutex
 }
 
-//DSDVFactory is a factory to create a new empty DSDV.
-func DSDVFactory() *DSDV {
+//DSDVFactory is a factory to create a new empty DSDV whose routes expire after maxAge (never if it is 0).
+func DSDVFactory(maxAge time.Duration) *DSDV {
 	return &DSDV{
-		NextHop: make(map[string]*net.UDPAddr),
-		DstSeq:  make(map[string]uint32),
-		Mutex:   sync.RWMutex{},
+		NextHops: make(map[string]net.Addr),
+		Routes:   make(map[string]*Route),
+		Paths:    make(map[string]map[string]*Route),
+		MaxAge:   maxAge,
+		Mutex:    sync.RWMutex{},
 	}
 }
 
-//Contains verifies if the given origin is already in the next-hop routing table
+//Contains verifies if the given origin has a valid route in the next-hop routing table
 func (dsdv *DSDV) Contains(origin string) bool{
-	_, ok := dsdv.NextHop[origin]
+	dsdv.Mutex.RLock()
+	defer dsdv.Mutex.RUnlock()
+
+	_, ok := dsdv.NextHops[origin]
 	return ok
 }
 
-//Update updates the next-hop table and the destination sequence number.
-//id is the ID of the newly arrived rumorMessage or TLCMessage.
-//origin is the origin of the newly arrived rumorMessage or TLCMessage.
+//NextHop returns the next hop of the valid route to origin, nil if there is none
+func (dsdv *DSDV) NextHop(origin string) net.Addr{
+	dsdv.Mutex.RLock()
+	defer dsdv.Mutex.RUnlock()
+
+	return dsdv.NextHops[origin]
+}
+
+//Candidates returns the next hops of the valid routes to origin, in order of preference: the next hop of the route
+//of origin first, then the other paths by decreasing sequence number and increasing number of hops.
+//Since a peer may route the messages of origin through the gossiper, a packet sent to an alternative
+//can loop until its hop limit is reached.
+func (dsdv *DSDV) Candidates(origin string) []net.Addr{
+	dsdv.Mutex.RLock()
+	defer dsdv.Mutex.RUnlock()
+
+	candidates := make([]net.Addr, 0)
+	best, ok := dsdv.NextHops[origin]
+	if ok {
+		candidates = append(candidates, best)
+	}
+
+	paths := make([]*Route, 0, len(dsdv.Paths[origin]))
+	for address, path := range dsdv.Paths[origin]{
+		if path.Valid && (!ok || address != best.String()) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Seq != paths[j].Seq {
+			return paths[i].Seq > paths[j].Seq
+		}
+		if paths[i].Hops != paths[j].Hops {
+			return paths[i].Hops < paths[j].Hops
+		}
+		return paths[i].NextHop.String() < paths[j].NextHop.String()
+	})
+
+	for _, path := range paths{
+		candidates = append(candidates, path.NextHop)
+	}
+	return candidates
+}
+
+//Update updates the route to origin with a message that came from it.
+//The route is replaced if the message has a higher sequence number, or if it has the same sequence number
+//and came through a shorter path. A replaced route is refreshed and valid again.
+//The path to origin through from is updated with the same rules.
+//id is the ID of the arrived rumorMessage or TLCMessage.
+//origin is the origin of the arrived rumorMessage or TLCMessage.
 //text is the content of the rumorMessage
 //from is the address from which the rumor message arrived.
-func (dsdv *DSDV) Update(id uint32, origin, text string, from *net.UDPAddr){
-	_, ok := dsdv.NextHop[origin]
+//hops is the number of hops made by the message from its origin.
+//now is the time at which the message arrived.
+func (dsdv *DSDV) Update(id uint32, origin, text string, from net.Addr, hops uint32, now time.Time){
+	dsdv.Mutex.Lock()
+	defer dsdv.Mutex.Unlock()
+
+	dsdv.updatePath(id, origin, from, hops, now)
+
+	route, ok := dsdv.Routes[origin]
+
+	if ok && id < route.Seq {
+		return
+	}
+	if ok && id == route.Seq && (!route.Valid || hops >= route.Hops) {
+		return
+	}
+
+	dsdv.Routes[origin] = &Route{
+		NextHop:   from,
+		Seq:       id,
+		Hops:      hops,
+		Refreshed: now,
+		Valid:     true,
+	}
+	dsdv.NextHops[origin] = from
+
+	if text != ""{
+		PrintUpdateDSVD(origin, from)
+	}
+}
 
-	if !ok{
-		dsdv.NextHop[origin] = from
-		dsdv.DstSeq[origin] = id
+//updatePath updates the path to origin through from. The Mutex must be held.
+func (dsdv *DSDV) updatePath(id uint32, origin string, from net.Addr, hops uint32, now time.Time){
+	paths, ok := dsdv.Paths[origin]
+	if !ok {
+		paths = make(map[string]*Route)
+		dsdv.Paths[origin] = paths
+	}
 
-		if text != ""{
-			PrintUpdateDSVD(origin, from)
+	path, ok := paths[from.String()]
+	if !ok || id > path.Seq || (id == path.Seq && (!path.Valid || hops < path.Hops)) {
+		paths[from.String()] = &Route{
+			NextHop:   from,
+			Seq:       id,
+			Hops:      hops,
+			Refreshed: now,
+			Valid:     true,
 		}
+	}
+}
 
-	}else{
-		highestSeq := dsdv.DstSeq[origin]
-		if highestSeq < id {
-			dsdv.DstSeq[origin] = id
-			dsdv.NextHop[origin] = from
+//Expire invalidates the routes and the paths that were not refreshed during MaxAge and removes the routes from
+//the next-hop routing table.
+//It returns the destinations whose route expired.
+func (dsdv *DSDV) Expire(now time.Time) []string{
+	dsdv.Mutex.Lock()
+	defer dsdv.Mutex.Unlock()
 
-			if text != ""{
-				PrintUpdateDSVD(origin, from)
+	expired := make([]string, 0)
+	if dsdv.MaxAge == 0 {
+		return expired
+	}
+
+	for origin, route := range dsdv.Routes{
+		if route.Valid && now.Sub(route.Refreshed) > dsdv.MaxAge {
+			route.Valid = false
+			delete(dsdv.NextHops, origin)
+			expired = append(expired, origin)
+			PrintExpiredDSDV(origin, route.NextHop)
+		}
+	}
+
+	for _, paths := range dsdv.Paths{
+		for _, path := range paths{
+			if now.Sub(path.Refreshed) > dsdv.MaxAge {
+				path.Valid = false
 			}
 		}
 	}
+	return expired
 }
 
 //GetOrigins retrieves a list of node Origins identifier.
 func (dsdv *DSDV) GetOrigins() []string{
-	origins := make([]string, 0, len(dsdv.NextHop))
-	for origin, _ := range dsdv.NextHop{
+	dsdv.Mutex.RLock()
+	defer dsdv.Mutex.RUnlock()
+
+	origins := make([]string, 0, len(dsdv.NextHops))
+	for origin, _ := range dsdv.NextHops{
 		origins = append(origins, origin)
 	}
 
@@ -72,16 +206,24 @@ func (dsdv *DSDV) GetOrigins() []string{
 }
 
 //PrintUpdateDSVD prints the message "DSDV <peer_name> <ip:port> when the the DSDV routing table is updated.
-func PrintUpdateDSVD(origin string, from *net.UDPAddr){
+func PrintUpdateDSVD(origin string, from net.Addr){
 	fmt.Printf("DSDV %s %s\n", origin, from.String())
 }
 
+//PrintExpiredDSDV prints the message "DSDV EXPIRED <peer_name> <ip:port>" when the route to a peer expires.
+func PrintExpiredDSDV(origin string, nextHop net.Addr){
+	fmt.Printf("DSDV EXPIRED %s %s\n", origin, nextHop.String())
+}
+
 //DSDV implements the function of the interface String
 func (dsdv *DSDV) String() string{
-	s := "Origin - Next-Hop - Sequence-Number\n"
-	for origin, nexthop := range dsdv.NextHop{
-		s += fmt.Sprintf("%s - %s - %d\n", origin, nexthop.String(), dsdv.DstSeq[origin])
+	dsdv.Mutex.RLock()
+	defer dsdv.Mutex.RUnlock()
+
+	s := "Origin - Next-Hop - Sequence-Number - Hops - Valid\n"
+	for origin, route := range dsdv.Routes{
+		s += fmt.Sprintf("%s - %s - %d - %d - %t\n", origin, route.NextHop.String(), route.Seq, route.Hops, route.Valid)
 	}
 
 	return s[:len(s)-1]
-}
\ No newline at end of file
+}
@@ -0,0 +1,117 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+//HEALTH_WEIGHT is the weight of the outcome of the last probe in the health score of a peer
+//HEALTH_UNREACHABLE is the score under which a peer is judged unreachable
+const (
+	HEALTH_WEIGHT      = 0.5
+	HEALTH_UNREACHABLE = 0.3
+)
+
+//Health keeps a health score for each peer used as next hop. The score is a moving average of the outcomes
+//of the probes sent to the peer: 1 for an answered probe, 0 for a probe still unanswered when the next one is sent.
+//A peer that was never probed has a score of 1.
+//The methods of Health are thread-safe.
+//Scores maps the address of a peer to its score.
+//Pending maps the address of a peer to the ID of its unanswered probe.
+type Health struct {
+	Scores  map[string]float64
+	Pending map[string]uint32
+	lastID  uint32
+	Mutex   sync.RWMutex
+}
+
+//HealthFactory creates the health scores of peers that were not probed yet
+func HealthFactory() *Health {
+	return &Health{
+		Scores:  make(map[string]float64),
+		Pending: make(map[string]uint32),
+		lastID:  0,
+		Mutex:   sync.RWMutex{},
+	}
+}
+
+//Score returns the health score of peer
+func (h *Health) Score(peer net.Addr) float64 {
+	h.Mutex.RLock()
+	defer h.Mutex.RUnlock()
+
+	return h.score(peer)
+}
+
+//score returns the health score of peer. The Mutex must be held.
+func (h *Health) score(peer net.Addr) float64 {
+	if score, ok := h.Scores[peer.String()]; ok {
+		return score
+	}
+	return 1
+}
+
+//Reachable checks if the score of peer is high enough to send it packets
+func (h *Health) Reachable(peer net.Addr) bool {
+	return h.Score(peer) >= HEALTH_UNREACHABLE
+}
+
+//Select returns the first reachable candidate, or the first candidate if none of them is reachable.
+//It returns nil if there is no candidate.
+func (h *Health) Select(candidates []net.Addr) net.Addr {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	for _, candidate := range candidates {
+		if h.Reachable(candidate) {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
+
+//Probe returns the ID of a new probe for peer. If the previous probe of peer is unanswered, it counts as a failure.
+func (h *Health) Probe(peer net.Addr) uint32 {
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+
+	if _, ok := h.Pending[peer.String()]; ok {
+		h.record(peer, 0)
+	}
+
+	h.lastID += 1
+	h.Pending[peer.String()] = h.lastID
+	return h.lastID
+}
+
+//Answer records the answer of peer to the probe with the given ID. Answers to older probes are ignored.
+func (h *Health) Answer(peer net.Addr, ID uint32) {
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+
+	if pending, ok := h.Pending[peer.String()]; ok && pending == ID {
+		delete(h.Pending, peer.String())
+		h.record(peer, 1)
+	}
+}
+
+//record updates the score of peer with the outcome of a probe and prints when the peer becomes
+//unreachable or reachable again. The Mutex must be held.
+func (h *Health) record(peer net.Addr, outcome float64) {
+	old := h.score(peer)
+	score := (1-HEALTH_WEIGHT)*old + HEALTH_WEIGHT*outcome
+	h.Scores[peer.String()] = score
+
+	if old >= HEALTH_UNREACHABLE && score < HEALTH_UNREACHABLE {
+		PrintHealth("UNREACHABLE", peer, score)
+	} else if old < HEALTH_UNREACHABLE && score >= HEALTH_UNREACHABLE {
+		PrintHealth("REACHABLE", peer, score)
+	}
+}
+
+//PrintHealth prints the message "HEALTH <status> <ip:port> score <score>" when a peer changes status
+func PrintHealth(status string, peer net.Addr, score float64) {
+	fmt.Printf("HEALTH %s %s score %.2f\n", status, peer.String(), score)
+}
@@ -0,0 +1,279 @@
+package routing
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Advertisement is the last link-state advertisement received from a gossiper.
+//Address   string is the address (ip:port) of the gossiper
+//Seq       uint32 is the sequence number of the advertisement
+//Neighbors []string are the addresses of the peers of the gossiper
+//Received  time.Time is the time at which the advertisement arrived
+type Advertisement struct {
+	Address   string
+	Seq       uint32
+	Neighbors []string
+	Received  time.Time
+}
+
+//LinkState implements the link-state routing scheme. Every gossiper floods the list of its neighbors, so that each
+//gossiper knows the whole topology and computes the shortest paths to the other gossipers with Dijkstra.
+//The paths starting with each of the neighbors are kept as alternatives to the shortest one.
+//A link is used only if both of its ends advertise it.
+//The methods of LinkState are thread-safe.
+//Name and Address are the name and the address (ip:port) of the gossiper.
+//MaxAge is the time after which an advertisement that was not refreshed is dropped. 0 means that it is never dropped.
+//Neighbors are the peers of the gossiper, by address.
+//Adverts maps the name of a gossiper to its last advertisement.
+//NextHops is the next-hop routing table computed from the topology.
+//Paths maps a destination to its candidate next hops, best first.
+type LinkState struct {
+	Name      string
+	Address   string
+	MaxAge    time.Duration
+	Neighbors map[string]net.Addr
+	Adverts   map[string]*Advertisement
+	NextHops  map[string]net.Addr
+	Paths     map[string][]net.Addr
+	Mutex     sync.RWMutex
+}
+
+//LinkStateFactory is a factory to create the empty topology of the gossiper called name listening at address.
+//The advertisements are dropped after maxAge (never if it is 0).
+func LinkStateFactory(name, address string, maxAge time.Duration) *LinkState {
+	return &LinkState{
+		Name:      name,
+		Address:   address,
+		MaxAge:    maxAge,
+		Neighbors: make(map[string]net.Addr),
+		Adverts:   make(map[string]*Advertisement),
+		NextHops:  make(map[string]net.Addr),
+		Paths:     make(map[string][]net.Addr),
+		Mutex:     sync.RWMutex{},
+	}
+}
+
+//Contains verifies if there is a path to the given origin in the topology
+func (ls *LinkState) Contains(origin string) bool {
+	ls.Mutex.RLock()
+	defer ls.Mutex.RUnlock()
+
+	_, ok := ls.NextHops[origin]
+	return ok
+}
+
+//NextHop returns the neighbor starting the shortest path to origin, nil if there is none
+func (ls *LinkState) NextHop(origin string) net.Addr {
+	ls.Mutex.RLock()
+	defer ls.Mutex.RUnlock()
+
+	return ls.NextHops[origin]
+}
+
+//Candidates returns the neighbors from which there is a path to origin that does not go through the gossiper,
+//by increasing length of the path
+func (ls *LinkState) Candidates(origin string) []net.Addr {
+	ls.Mutex.RLock()
+	defer ls.Mutex.RUnlock()
+
+	return append([]net.Addr(nil), ls.Paths[origin]...)
+}
+
+//GetOrigins retrieves the names of the gossipers that can be reached.
+func (ls *LinkState) GetOrigins() []string {
+	ls.Mutex.RLock()
+	defer ls.Mutex.RUnlock()
+
+	origins := make([]string, 0, len(ls.NextHops))
+	for origin := range ls.NextHops {
+		origins = append(origins, origin)
+	}
+
+	return origins
+}
+
+//SetNeighbors replaces the neighbors of the gossiper with its current peers and recomputes the routes.
+func (ls *LinkState) SetNeighbors(peers []net.Addr) {
+	ls.Mutex.Lock()
+	defer ls.Mutex.Unlock()
+
+	ls.Neighbors = make(map[string]net.Addr)
+	for _, peer := range peers {
+		ls.Neighbors[peer.String()] = peer
+	}
+	ls.compute()
+}
+
+//Update records the advertisement of origin if it is newer than the known one and recomputes the routes.
+//It returns true if the advertisement is new, in which case it has to be flooded to the peers.
+//now is the time at which the advertisement arrived.
+func (ls *LinkState) Update(origin, address string, seq uint32, neighbors []string, now time.Time) bool {
+	ls.Mutex.Lock()
+	defer ls.Mutex.Unlock()
+
+	if origin == ls.Name || address == ls.Address {
+		return false
+	}
+	if known, ok := ls.Adverts[origin]; ok && seq <= known.Seq {
+		return false
+	}
+
+	ls.Adverts[origin] = &Advertisement{
+		Address:   address,
+		Seq:       seq,
+		Neighbors: neighbors,
+		Received:  now,
+	}
+	ls.compute()
+	return true
+}
+
+//Expire drops the advertisements that were not refreshed during MaxAge and recomputes the routes.
+//Once its advertisement is dropped, a gossiper is accepted again with any sequence number (e.g. after a restart).
+//It returns the gossipers whose advertisement was dropped.
+func (ls *LinkState) Expire(now time.Time) []string {
+	ls.Mutex.Lock()
+	defer ls.Mutex.Unlock()
+
+	expired := make([]string, 0)
+	if ls.MaxAge == 0 {
+		return expired
+	}
+
+	for origin, advert := range ls.Adverts {
+		if now.Sub(advert.Received) > ls.MaxAge {
+			delete(ls.Adverts, origin)
+			expired = append(expired, origin)
+			PrintExpiredLinkState(origin)
+		}
+	}
+	if len(expired) > 0 {
+		ls.compute()
+	}
+	return expired
+}
+
+//candidate is a next hop to a destination with the length of the shortest path through it
+type candidate struct {
+	neighbor string
+	distance uint32
+}
+
+//compute computes the routes on the graph of the bidirectional links, with a weight of 1 per link.
+//For each neighbor, Dijkstra gives the shortest paths starting from the neighbor that do not go through the gossiper.
+//The candidates of a destination are the neighbors that reach it, by increasing length of the path. Among the paths
+//of the same length, the one starting with the smallest neighbor address is preferred, so that the routes do not
+//depend on the order of the maps. The Mutex must be held.
+func (ls *LinkState) compute() {
+	links := make(map[string]map[string]bool)
+	names := make(map[string]string)
+
+	links[ls.Address] = make(map[string]bool)
+	for address := range ls.Neighbors {
+		links[ls.Address][address] = true
+	}
+	for origin, advert := range ls.Adverts {
+		names[advert.Address] = origin
+		links[advert.Address] = make(map[string]bool)
+		for _, neighbor := range advert.Neighbors {
+			links[advert.Address][neighbor] = true
+		}
+	}
+
+	candidates := make(map[string][]candidate)
+	for neighbor := range links[ls.Address] {
+		if !links[neighbor][ls.Address] {
+			continue
+		}
+		for address, distance := range dijkstra(links, neighbor, ls.Address) {
+			if origin, ok := names[address]; ok {
+				candidates[origin] = append(candidates[origin], candidate{neighbor: neighbor, distance: distance + 1})
+			}
+		}
+	}
+
+	paths := make(map[string][]net.Addr)
+	nextHops := make(map[string]net.Addr)
+	for origin, list := range candidates {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].distance != list[j].distance {
+				return list[i].distance < list[j].distance
+			}
+			return list[i].neighbor < list[j].neighbor
+		})
+		for _, c := range list {
+			paths[origin] = append(paths[origin], ls.Neighbors[c.neighbor])
+		}
+		nextHops[origin] = paths[origin][0]
+	}
+
+	for origin, nextHop := range nextHops {
+		if known, ok := ls.NextHops[origin]; !ok || known.String() != nextHop.String() {
+			PrintUpdateLinkState(origin, nextHop)
+		}
+	}
+	ls.NextHops = nextHops
+	ls.Paths = paths
+}
+
+//dijkstra returns the length of the shortest path from source to every address it reaches in the graph of
+//the bidirectional links, without going through excluded.
+func dijkstra(links map[string]map[string]bool, source, excluded string) map[string]uint32 {
+	distances := map[string]uint32{source: 0}
+	done := make(map[string]bool)
+	for {
+		current := ""
+		for address, distance := range distances {
+			if done[address] {
+				continue
+			}
+			if current == "" || distance < distances[current] || (distance == distances[current] && address < current) {
+				current = address
+			}
+		}
+		if current == "" {
+			return distances
+		}
+		done[current] = true
+
+		for neighbor := range links[current] {
+			if neighbor == excluded || done[neighbor] || !links[neighbor][current] {
+				continue
+			}
+			if known, ok := distances[neighbor]; !ok || distances[current]+1 < known {
+				distances[neighbor] = distances[current] + 1
+			}
+		}
+	}
+}
+
+//PrintUpdateLinkState prints the message "LINK-STATE <peer_name> <ip:port>" when the route to a peer changes.
+func PrintUpdateLinkState(origin string, nextHop net.Addr) {
+	fmt.Printf("LINK-STATE %s %s\n", origin, nextHop.String())
+}
+
+//PrintExpiredLinkState prints the message "LINK-STATE EXPIRED <peer_name>" when the advertisement of a peer is dropped.
+func PrintExpiredLinkState(origin string) {
+	fmt.Printf("LINK-STATE EXPIRED %s\n", origin)
+}
+
+//LinkState implements the function of the interface String
+func (ls *LinkState) String() string {
+	ls.Mutex.RLock()
+	defer ls.Mutex.RUnlock()
+
+	s := "Origin - Address - Sequence-Number - Next-Hop\n"
+	for origin, advert := range ls.Adverts {
+		nextHop := "-"
+		if addr, ok := ls.NextHops[origin]; ok {
+			nextHop = addr.String()
+		}
+		s += fmt.Sprintf("%s - %s - %d - %s\n", origin, advert.Address, advert.Seq, nextHop)
+	}
+
+	return s[:len(s)-1]
+}
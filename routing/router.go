@@ -0,0 +1,24 @@
+package routing
+
+import "net"
+
+//Router is the interface of the routing schemes used to forward the point-to-point messages
+//(private messages, data requests and replies, search replies and TLC acks) to their destination.
+//Contains checks if there is a route to the destination.
+//NextHop returns the peer to which the messages for the destination are sent, nil if there is no route.
+//Candidates returns the peers to which the messages for the destination can be sent, best first.
+//The first candidate is the one returned by NextHop.
+//GetOrigins returns the destinations that have a route.
+//The methods of a Router are thread-safe.
+type Router interface {
+	Contains(destination string) bool
+	NextHop(destination string) net.Addr
+	Candidates(destination string) []net.Addr
+	GetOrigins() []string
+}
+
+//ROUTING_DSDV and ROUTING_LINK_STATE are the names of the routing schemes
+const (
+	ROUTING_DSDV       = "dsdv"
+	ROUTING_LINK_STATE = "linkstate"
+)
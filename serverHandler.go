@@ -4,10 +4,14 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/gossip"
 	"github.com/somecookie/Peerster/helper"
 	"github.com/somecookie/Peerster/packet"
-	"net"
+	"github.com/somecookie/Peerster/transport"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 func nodeHandler(w http.ResponseWriter, request *http.Request) {
@@ -35,11 +39,11 @@ func nodeHandler(w http.ResponseWriter, request *http.Request) {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			udpAddr, err := net.ResolveUDPAddr("udp4", peerAddrStr)
+			peerAddr, err := g.ResolvePeer(peerAddrStr)
 
 			if err == nil {
 				g.Peers.Mutex.Lock()
-				g.Peers.Add(udpAddr)
+				g.Peers.Add(peerAddr)
 				g.Peers.Mutex.Unlock()
 			}
 
@@ -90,7 +94,7 @@ func rumorMessagesHandler(w http.ResponseWriter, request *http.Request) {
 			if ok{
 				jsonValue, err = json.Marshal(messages)
 			}else{
-				empty := make([]packet.PrivateMessage,0)
+				empty := make([]gossip.PrivateEntry,0)
 				jsonValue, err = json.Marshal(empty)
 			}
 
@@ -107,11 +111,13 @@ func rumorMessagesHandler(w http.ResponseWriter, request *http.Request) {
 		if err == nil {
 			text := request.Form.Get("value")
 			dest := request.Form.Get("dest")
-			rm := &packet.Message{Text:text, Destination:&dest }
+			encrypt := request.Form.Get("encrypt") == "true"
+			rm := &packet.Message{Text:text, Destination:&dest, Encrypt:encrypt}
 
 
 			if dest == "Rumors"{
 				rm.Destination = nil
+				rm.Encrypt = false
 			}
 
 
@@ -145,9 +151,7 @@ func originHandler(w http.ResponseWriter, r *http.Request) {
 	enableCors(&w)
 	switch r.Method {
 	case "GET":
-		g.DSDV.Mutex.RLock()
-		originsAsJSON, err := json.Marshal(g.DSDV.GetOrigins())
-		g.DSDV.Mutex.RUnlock()
+		originsAsJSON, err := json.Marshal(g.Router.GetOrigins())
 		if err == nil {
 			w.WriteHeader(http.StatusOK)
 			w.Write(originsAsJSON)
@@ -221,9 +225,27 @@ func matchesHandler(w http.ResponseWriter, request *http.Request) {
 	enableCors(&w)
 	switch request.Method{
 	case "GET":
+		//each match is annotated with whether its name is registered for this file
+		type annotatedMatch struct {
+			FileName  string
+			Origin    string
+			MetaHash  string
+			Confirmed bool
+		}
+
 		g.Matches.RLock()
-		matchesAsJSON, err := json.Marshal(g.Matches.Queue)
+		matches := make([]annotatedMatch, 0, len(g.Matches.Queue))
+		for _, m := range g.Matches.Queue {
+			metaHash, _ := hex.DecodeString(m.MetaHash)
+			matches = append(matches, annotatedMatch{
+				FileName:  m.FileName,
+				Origin:    m.Origin,
+				MetaHash:  m.MetaHash,
+				Confirmed: g.Registry.IsConfirmed(m.FileName, metaHash),
+			})
+		}
 		g.Matches.RUnlock()
+		matchesAsJSON, err := json.Marshal(matches)
 		if err == nil {
 			w.WriteHeader(http.StatusOK)
 			w.Write(matchesAsJSON)
@@ -239,6 +261,279 @@ func matchesHandler(w http.ResponseWriter, request *http.Request) {
 
 
 
+//registryHandler returns the registrations of the file names as JSON.
+//If the parameter name is given, only the registration of this name is returned (the list is empty if it is not claimed).
+func registryHandler(w http.ResponseWriter, request *http.Request) {
+	enableCors(&w)
+	switch request.Method {
+	case "GET":
+		registrations := g.Registry.Registrations()
+		if name := request.URL.Query().Get("name"); name != "" {
+			registrations = make([]blockchain.Registration, 0, 1)
+			if registration, ok := g.Registry.Lookup(name); ok {
+				registrations = append(registrations, registration)
+			}
+		}
+
+		registry := make([]struct {
+			Name     string
+			MetaHash string
+			Owner    string
+		}, 0, len(registrations))
+		for _, registration := range registrations {
+			registry = append(registry, struct {
+				Name     string
+				MetaHash string
+				Owner    string
+			}{Name: registration.Name, MetaHash: hex.EncodeToString(registration.MetaHash), Owner: registration.Owner})
+		}
+
+		jsonValue, err := json.Marshal(registry)
+		if err == nil {
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonValue)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+//transactionView is the JSON representation of a transaction in the explorer.
+//Membership is the label of a membership change (e.g. join(A)), empty for a transaction on a file.
+//Label describes the transaction as in the chain (e.g. rename(a->b)).
+type transactionView struct {
+	Name       string
+	Size       int64
+	MetaHash   string
+	Membership string
+	Label      string
+	Owner      string
+}
+
+func viewTransaction(tx blockchain.TxPublish) transactionView {
+	view := transactionView{
+		Name:     tx.Name,
+		Size:     tx.Size,
+		MetaHash: hex.EncodeToString(tx.MetafileHash),
+		Label:    tx.Label(),
+		Owner:    tx.Owner,
+	}
+	if tx.IsMembershipChange() {
+		view.Membership = tx.Membership.Label()
+	}
+	return view
+}
+
+//blockView is the JSON representation of a block in the explorer
+type blockView struct {
+	Hash         string
+	PrevHash     string
+	Height       int
+	Transactions []transactionView
+}
+
+func viewBlock(block blockchain.Linked, height int) blockView {
+	view := blockView{
+		Hash:         blockchain.HashString(block.Hash()),
+		PrevHash:     blockchain.HashString(block.Parent()),
+		Height:       height,
+		Transactions: make([]transactionView, 0),
+	}
+	for _, tx := range block.GetTransactions() {
+		view.Transactions = append(view.Transactions, viewTransaction(tx))
+	}
+	return view
+}
+
+//blocksHandler returns the blocks of the longest chain as JSON, from the head to the first block.
+//If the parameter hash is given, only the block with this hash is returned, even if it is on a fork.
+//An unknown hash gives a 404 and a malformed one a 400.
+func blocksHandler(w http.ResponseWriter, request *http.Request) {
+	enableCors(&w)
+	switch request.Method {
+	case "GET":
+		var jsonValue []byte
+		var err error
+
+		if hashStr := request.URL.Query().Get("hash"); hashStr != "" {
+			decoded, decodeErr := hex.DecodeString(hashStr)
+			if decodeErr != nil || len(decoded) != 32 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			var hash [32]byte
+			copy(hash[:], decoded)
+			block, height, ok := g.Blockchain.Block(hash)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			jsonValue, err = json.Marshal(viewBlock(block, height))
+		} else {
+			blocks := g.Blockchain.Blocks()
+			views := make([]blockView, 0, len(blocks))
+			for i := len(blocks) - 1; i >= 0; i-- {
+				views = append(views, viewBlock(blocks[i], i+1))
+			}
+			jsonValue, err = json.Marshal(views)
+		}
+
+		if err == nil {
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonValue)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+//roundsHandler returns the TLC rounds seen by the gossiper as JSON, from the latest one.
+//Each round gives its number of members, its number of confirmed messages and the origin of the best proposal recorded by QSC.
+//If the parameter round is given, the confirmed messages of this round are returned instead, with the transaction
+//they propose and the witnesses that signed their certificate.
+func roundsHandler(w http.ResponseWriter, request *http.Request) {
+	enableCors(&w)
+	switch request.Method {
+	case "GET":
+		var jsonValue []byte
+		var err error
+		tm := g.TLCMajority
+
+		if roundStr := request.URL.Query().Get("round"); roundStr != "" {
+			round, parseErr := strconv.ParseUint(roundStr, 10, 32)
+			if parseErr != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			type confirmationView struct {
+				Origin      string
+				ID          uint32
+				Fitness     float32
+				BlockHash   string
+				PrevHash    string
+				Transaction transactionView
+				Witnesses   []string
+			}
+
+			tm.RLock()
+			confirmations := make([]confirmationView, 0, len(tm.Confirmed[uint32(round)]))
+			for _, c := range tm.Confirmed[uint32(round)] {
+				view := confirmationView{
+					Origin:      c.Origin,
+					ID:          c.ID,
+					Fitness:     c.Fitness,
+					BlockHash:   blockchain.HashString(c.TxBlock.Hash()),
+					PrevHash:    blockchain.HashString(c.TxBlock.PrevHash),
+					Transaction: viewTransaction(c.TxBlock.Transaction),
+					Witnesses:   make([]string, 0),
+				}
+				if c.Message != nil {
+					view.Witnesses = g.CountedWitnesses(c.Message, uint32(round))
+				}
+				confirmations = append(confirmations, view)
+			}
+			tm.RUnlock()
+			jsonValue, err = json.Marshal(confirmations)
+		} else {
+			type roundView struct {
+				Round     uint32
+				Members   int
+				Confirmed int
+				Best      string
+			}
+
+			tm.RLock()
+			rounds := make([]roundView, 0, tm.MyRound+1)
+			for r := int64(tm.MyRound); r >= 0; r-- {
+				view := roundView{
+					Round:     uint32(r),
+					Members:   len(tm.Members[uint32(r)]),
+					Confirmed: len(tm.Confirmed[uint32(r)]),
+				}
+				if best, ok := tm.Best[uint32(r)]; ok {
+					view.Best = best.Origin
+				}
+				rounds = append(rounds, view)
+			}
+			tm.RUnlock()
+			jsonValue, err = json.Marshal(rounds)
+		}
+
+		if err == nil {
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonValue)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+//faultsHandler exposes the faults injected on the gossip transport.
+//GET returns the faults per link and the partitioned peers.
+//POST accepts the following fields (all optional):
+//link is a link description as in the flag -faults (e.g. peer=127.0.0.1:5001,drop=0.5)
+//clear is a peer whose specific faults are removed
+//partition is a comma separated list of peers to cut
+//heal is a comma separated list of peers to restore, all the peers are restored if it is empty
+func faultsHandler(w http.ResponseWriter, request *http.Request) {
+	enableCors(&w)
+	switch request.Method {
+	case "GET":
+		jsonValue, err := json.Marshal(struct {
+			Links       map[string]transport.LinkFaults
+			Partitioned []string
+		}{Links: faults.Links(), Partitioned: faults.Partitioned()})
+
+		if err == nil {
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonValue)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	case "POST":
+		if err := request.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if spec := request.Form.Get("link"); spec != "" {
+			peer, linkFaults, err := transport.ParseLinkFaults(spec)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			faults.SetLink(peer, linkFaults)
+		}
+
+		if peer := request.Form.Get("clear"); peer != "" {
+			faults.ClearLink(peer)
+		}
+
+		if peers := request.Form.Get("partition"); peers != "" {
+			faults.Partition(strings.Split(peers, ",")...)
+		}
+
+		if _, ok := request.Form["heal"]; ok {
+			if peers := request.Form.Get("heal"); peers != "" {
+				faults.Heal(strings.Split(peers, ",")...)
+			} else {
+				faults.Heal()
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
 func enableCors(w *http.ResponseWriter) {
 	(*w).Header().Set("Access-Control-Allow-Origin", "*")
 }
@@ -254,6 +549,10 @@ func HandleServerGUI() {
 	http.HandleFunc("/download", downloadHandler)
 	http.HandleFunc("/search", searchHandler)
 	http.HandleFunc("/matches", matchesHandler)
+	http.HandleFunc("/faults", faultsHandler)
+	http.HandleFunc("/registry", registryHandler)
+	http.HandleFunc("/blocks", blocksHandler)
+	http.HandleFunc("/rounds", roundsHandler)
 	for {
 		err := http.ListenAndServe(serverAddr, nil)
 		helper.LogError(err)
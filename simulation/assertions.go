@@ -0,0 +1,182 @@
+package simulation
+
+import (
+	"fmt"
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/gossip"
+)
+
+//AssertionError is returned when the state of the gossipers does not satisfy an assertion.
+type AssertionError struct {
+	Assertion string
+	Node      string
+	Reason    string
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("%s does not hold for %s: %s", e.Assertion, e.Node, e.Reason)
+}
+
+//vectorClock returns the vector clock of g as a map from the origins to their next ID
+func vectorClock(g *gossip.Gossiper) map[string]uint32 {
+	g.State.Mutex.RLock()
+	defer g.State.Mutex.RUnlock()
+
+	vc := make(map[string]uint32)
+	for _, peerStatus := range g.State.VectorClock {
+		vc[peerStatus.Identifier] = peerStatus.NextID
+	}
+	return vc
+}
+
+//EqualVectorClocks checks that all the gossipers have the same vector clock
+func (s *Simulator) EqualVectorClocks() error {
+	if len(s.Gossipers) == 0 {
+		return nil
+	}
+
+	reference := vectorClock(s.Gossipers[0])
+	for _, g := range s.Gossipers[1:] {
+		vc := vectorClock(g)
+
+		if len(vc) != len(reference) {
+			return &AssertionError{
+				Assertion: "EqualVectorClocks",
+				Node:      g.Name,
+				Reason:    fmt.Sprintf("knows %d origins instead of %d", len(vc), len(reference)),
+			}
+		}
+
+		for origin, nextID := range reference {
+			if vc[origin] != nextID {
+				return &AssertionError{
+					Assertion: "EqualVectorClocks",
+					Node:      g.Name,
+					Reason:    fmt.Sprintf("next ID of %s is %d instead of %d", origin, vc[origin], nextID),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//FullRoutingTables checks that every gossiper has a route to all the other gossipers
+func (s *Simulator) FullRoutingTables() error {
+	for _, g := range s.Gossipers {
+		for _, other := range s.Gossipers {
+			if other.Name != g.Name && !g.Router.Contains(other.Name) {
+				return &AssertionError{
+					Assertion: "FullRoutingTables",
+					Node:      g.Name,
+					Reason:    "no route to " + other.Name,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//RoundReached checks that every gossiper reached at least the given TLC round
+func (s *Simulator) RoundReached(round uint32) error {
+	for _, g := range s.Gossipers {
+		g.TLCMajority.RLock()
+		myRound := g.TLCMajority.MyRound
+		g.TLCMajority.RUnlock()
+
+		if myRound < round {
+			return &AssertionError{
+				Assertion: "RoundReached",
+				Node:      g.Name,
+				Reason:    fmt.Sprintf("is at round %d instead of %d", myRound, round),
+			}
+		}
+	}
+	return nil
+}
+
+//SameChain checks that all the gossipers have the same head and that their ledger contains at least nbrFiles files
+func (s *Simulator) SameChain(nbrFiles int) error {
+	if len(s.Gossipers) == 0 {
+		return nil
+	}
+
+	reference, _ := s.Gossipers[0].Blockchain.Head()
+	for _, g := range s.Gossipers {
+		if head, _ := g.Blockchain.Head(); head != reference {
+			return &AssertionError{
+				Assertion: "SameChain",
+				Node:      g.Name,
+				Reason:    "head is " + blockchain.HashString(head) + " instead of " + blockchain.HashString(reference),
+			}
+		}
+
+		if ledger := g.Blockchain.Ledger(); len(ledger) < nbrFiles {
+			return &AssertionError{
+				Assertion: "SameChain",
+				Node:      g.Name,
+				Reason:    fmt.Sprintf("ledger contains %d files instead of %d", len(ledger), nbrFiles),
+			}
+		}
+	}
+	return nil
+}
+
+//AgreedChains checks that the chains of the gossipers do not contradict each other (each one is a prefix of the
+//longest one) and that the longest one contains at least nbrBlocks blocks
+func (s *Simulator) AgreedChains(nbrBlocks int) error {
+	chains := make([][]blockchain.Linked, len(s.Gossipers))
+	longest := 0
+	for i, g := range s.Gossipers {
+		chains[i] = g.Blockchain.Blocks()
+		if len(chains[i]) > len(chains[longest]) {
+			longest = i
+		}
+	}
+
+	for i, chain := range chains {
+		for j, block := range chain {
+			if block.Hash() != chains[longest][j].Hash() {
+				return &AssertionError{
+					Assertion: "AgreedChains",
+					Node:      s.Gossipers[i].Name,
+					Reason:    fmt.Sprintf("block %d is %s instead of %s", j, blockchain.HashString(block.Hash()), blockchain.HashString(chains[longest][j].Hash())),
+				}
+			}
+		}
+	}
+
+	if len(chains) > 0 && len(chains[longest]) < nbrBlocks {
+		return &AssertionError{
+			Assertion: "AgreedChains",
+			Node:      s.Gossipers[longest].Name,
+			Reason:    fmt.Sprintf("chain contains %d blocks instead of %d", len(chains[longest]), nbrBlocks),
+		}
+	}
+	return nil
+}
+
+//AgreedMembership checks that every gossiper counts size members of TLC
+func (s *Simulator) AgreedMembership(size int) error {
+	for _, g := range s.Gossipers {
+		if members := g.TLCMajority.Membership.Size(); members != size {
+			return &AssertionError{
+				Assertion: "AgreedMembership",
+				Node:      g.Name,
+				Reason:    fmt.Sprintf("counts %d members instead of %d", members, size),
+			}
+		}
+	}
+	return nil
+}
+
+//All combines several assertions. It returns the error of the first one that does not hold.
+func All(assertions ...func() error) func() error {
+	return func() error {
+		for _, assertion := range assertions {
+			if err := assertion(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
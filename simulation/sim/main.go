@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/simulation"
+	"github.com/somecookie/Peerster/transport"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//sim runs N gossipers in one process over a virtual network and checks their final state.
+//Each gossiper starts a rumor (and indexes a file if -tlc is set), then the simulation runs until
+//the vector clocks are equal, the routing tables are full and the TLC rounds are reached, or until the timeout.
+func main() {
+	n := flag.Int("N", 5, "number of gossipers")
+	topologyName := flag.String("topology", "ring", "topology of the network: ring, star or random")
+	p := flag.Float64("p", 0.3, "probability of a link between two gossipers in the random topology")
+	seed := flag.Int64("seed", 1, "seed of the random topology and of the random choices of the gossipers")
+	rumors := flag.Int("rumors", 1, "number of rumors started by each gossiper")
+	tlc := flag.Bool("tlc", false, "make each gossiper index a file and check that the gossipers agree with QSC on a chain containing all the files")
+	mining := flag.Bool("mining", false, "make each gossiper index a file in mining mode and check that they agree on a chain containing all the files")
+	joining := flag.Int("joining", 0, "number of gossipers (the last ones) that are not founding members of TLC and join the others")
+	faultThreshold := flag.Int("faultThreshold", 0, "number f of faulty members tolerated by TLC: the majorities need more than 2f+1 witnesses")
+	difficulty := flag.Int("difficulty", 8, "number of leading zero bits of the hash of a mined block")
+	rtimer := flag.Int("rtimer", 60, "timeout in seconds to send route rumors")
+	routeExpiry := flag.Int("routeExpiry", 3, "number of route rumor intervals after which a route that was not refreshed expires")
+	routingKind := flag.String("routing", "dsdv", "routing scheme of the gossipers: dsdv or linkstate")
+	probeTimer := flag.Int("probeTimer", 5, "time in seconds between two probes of the peers, 0 to disable the probes")
+	antiEntropy := flag.Int("antiEntropy", 10, "time in seconds for the anti-entropy")
+	timeout := flag.Int("timeout", 300, "timeout of the simulation in (virtual) seconds")
+	faults := flag.String("faults", "", "faults injected on all the links, e.g. \"drop=0.1,latency=50ms,jitter=10ms,reorder=0.05,duplicate=0.01\"")
+	partitions := flag.String("partitions", "", "scheduled partitions of the form start/duration/i,j separated by semicolons, where i,j are the gossipers cut from the others")
+	flag.Parse()
+
+	topology, err := simulation.TopologyFromName(*topologyName, *p, *seed)
+	helper.HandleCrashingErr(err)
+
+	config := simulation.DefaultConfig()
+	config.RTimer = *rtimer
+	config.RouteExpiry = *routeExpiry
+	config.Routing = *routingKind
+	config.ProbeTimer = *probeTimer
+	config.AntiEntropy = *antiEntropy
+	config.Difficulty = *difficulty
+	config.Joining = *joining
+	config.FaultThreshold = *faultThreshold
+	config.Seed = *seed
+	if *mining {
+		config.Miners = 1
+	}
+
+	s, err := simulation.SimulatorFactory(*n, topology, config)
+	helper.HandleCrashingErr(err)
+	defer s.Stop()
+
+	_, linkFaults, err := transport.ParseLinkFaults(*faults)
+	helper.HandleCrashingErr(err)
+	s.SetDefaultFaults(linkFaults)
+
+	scheduled, err := transport.ParsePartitions(*partitions)
+	helper.HandleCrashingErr(err)
+	for _, partition := range scheduled {
+		s.SchedulePartition(parseGroup(partition.Peers), partition.Start, partition.Duration)
+	}
+
+	s.Start()
+
+	for r := 0; r < *rumors; r++ {
+		for i := 0; i < *n; i++ {
+			s.SendRumor(i, "rumor "+strconv.Itoa(r)+" from node"+strconv.Itoa(i))
+		}
+	}
+
+	assertions := []func() error{s.EqualVectorClocks}
+	if *rtimer > 0 {
+		assertions = append(assertions, s.FullRoutingTables)
+	}
+
+	if *tlc || *mining {
+		for i := 0; i < *n; i++ {
+			helper.HandleCrashingErr(s.ShareFile(i, "sim_node"+strconv.Itoa(i)+".txt", []byte("file of node"+strconv.Itoa(i))))
+		}
+	}
+
+	if *mining {
+		assertions = append(assertions, func() error { return s.SameChain(*n) })
+	} else if *tlc {
+		//the joining gossipers publish their files once they caught up with the founding members
+		assertions = append(assertions, func() error { return s.AgreedChains(*n + *joining) })
+		assertions = append(assertions, func() error { return s.AgreedMembership(*n) })
+	}
+
+	elapsed, err := s.RunUntil(simulation.All(assertions...), time.Duration(*timeout)*time.Second)
+	if err != nil {
+		fmt.Printf("SIMULATION FAILED after %s: %s\n", elapsed, err)
+		os.Exit(1)
+	}
+	fmt.Printf("SIMULATION SUCCEEDED after %s\n", elapsed)
+}
+
+//parseGroup converts the indexes of the gossipers of a partition
+func parseGroup(indexes []string) []int {
+	group := make([]int, 0, len(indexes))
+	for _, index := range indexes {
+		i, err := strconv.Atoi(strings.TrimSpace(index))
+		helper.HandleCrashingErr(err)
+		group = append(group, i)
+	}
+	return group
+}
@@ -0,0 +1,325 @@
+package simulation
+
+import (
+	"encoding/hex"
+	"github.com/somecookie/Peerster/clock"
+	"github.com/somecookie/Peerster/fileSharing"
+	"github.com/somecookie/Peerster/gossip"
+	"github.com/somecookie/Peerster/identity"
+	"github.com/somecookie/Peerster/packet"
+	"github.com/somecookie/Peerster/routing"
+	"github.com/somecookie/Peerster/transport"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"time"
+)
+
+//SETTLE_MAX_ROUNDS is the maximal number of observations of the network after a step, so that a gossiper that keeps
+//sending does not block the simulation
+const SETTLE_MAX_ROUNDS = 1000000
+
+//Config contains the parameters of the gossipers of a simulation. The parameters of gossip.Config that are specific to
+//each gossiper (GossipAddr, UIPort, Name, Peers, StorageKind, N, Founders, Join and Seed) are set by the simulator.
+//The i-th gossiper draws its random choices from the seed Seed+i.
+type Config struct {
+	gossip.Config
+	//Joining is the number of gossipers (the last ones) that are not founding members of TLC and ask to join them when they start
+	Joining int
+	//Step is the virtual time added to the clock at each step of the simulation
+	Step time.Duration
+	//Settle is the number of consecutive observations of a quiet network after which a step is over. The simulator
+	//yields the processor between two observations, so that the goroutines started by the gossipers send their packets.
+	Settle int
+}
+
+//DefaultConfig returns the configuration corresponding to the default flags of main.go, with route rumors enabled.
+func DefaultConfig() Config {
+	return Config{
+		Config: gossip.Config{
+			Simple:          false,
+			AckAll:          false,
+			AntiEntropy:     10,
+			RTimer:          60,
+			HopLimit:        10,
+			StubbornTimeout: 5,
+			DownloadWindow:  4,
+			Miners:          0,
+			Difficulty:      8,
+			FaultThreshold:  0,
+			RouteExpiry:     3,
+			Routing:         routing.ROUTING_DSDV,
+			ProbeTimer:      5,
+			OnionRelays:     3,
+			Mailboxes:       []string{},
+			MailboxTimer:    5,
+			Seed:            1,
+		},
+		Joining: 0,
+		Step:    100 * time.Millisecond,
+		Settle:  1000,
+	}
+}
+
+//Simulator runs several gossipers in the same process. They communicate through an in-memory network
+//and share a clock that only moves forward when the simulation advances.
+//The links of every gossiper go through a FaultyTransport so that faults can be injected.
+type Simulator struct {
+	Gossipers []*gossip.Gossiper
+	Faults    []*transport.FaultyTransport
+	Network   *transport.Network
+	Clock     *clock.ManualClock
+	config    Config
+	started   bool
+}
+
+//SimulatorFactory creates n gossipers connected according to topology. The gossipers are named node0, node1...
+func SimulatorFactory(n int, topology Topology, config Config) (*Simulator, error) {
+	s := &Simulator{
+		Gossipers: make([]*gossip.Gossiper, 0, n),
+		Faults:    make([]*transport.FaultyTransport, 0, n),
+		Network:   transport.NetworkFactory(),
+		Clock:     clock.ManualClockFactory(time.Unix(0, 0)),
+		config:    config,
+		started:   false,
+	}
+
+	founders, err := founderKeys(n - config.Joining)
+	if err != nil {
+		return nil, err
+	}
+
+	links := topology(n)
+	for i := 0; i < n; i++ {
+		peers := make([]string, 0, len(links[i]))
+		for _, j := range links[i] {
+			peers = append(peers, nodeAddr(j))
+		}
+
+		memoryTransport, err := s.Network.Listen(nodeAddr(i))
+		if err != nil {
+			s.Stop()
+			return nil, err
+		}
+
+		faults := transport.FaultyTransportFactory(memoryTransport, s.Clock, int64(i))
+		s.Faults = append(s.Faults, faults)
+
+		gossiperConfig := config.Config
+		gossiperConfig.GossipAddr = nodeAddr(i)
+		gossiperConfig.UIPort = ""
+		gossiperConfig.Name = nodeName(i)
+		gossiperConfig.Peers = peers
+		gossiperConfig.StorageKind = "none"
+		gossiperConfig.N = n - config.Joining
+		gossiperConfig.Founders = founders
+		gossiperConfig.Seed = config.Seed + int64(i)
+		gossiperConfig.Join = i >= n-config.Joining
+
+		g, err := gossip.GossiperFactory(gossiperConfig, faults, s.Clock)
+		if err != nil {
+			memoryTransport.Close()
+			s.Stop()
+			return nil, err
+		}
+		s.Gossipers = append(s.Gossipers, g)
+	}
+
+	return s, nil
+}
+
+//founderKeys returns the founding members of TLC, the first n gossipers, with their public keys (of the form name:key).
+//The identities of the founders are generated if needed, so that the gossipers load the same keys.
+func founderKeys(n int) ([]string, error) {
+	founders := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		id, err := identity.LoadOrGenerate(nodeName(i))
+		if err != nil {
+			return nil, err
+		}
+		founders = append(founders, nodeName(i)+":"+hex.EncodeToString(id.PublicKey()))
+	}
+	return founders, nil
+}
+
+//Start starts the routines of all the gossipers, as main.go does.
+func (s *Simulator) Start() {
+	if s.started {
+		return
+	}
+	s.started = true
+
+	for i, g := range s.Gossipers {
+		go g.GossiperListener()
+
+		if s.config.AntiEntropy > 0 {
+			go g.AntiEntropyRoutine()
+		}
+
+		if s.config.RTimer > 0 {
+			go g.RouteRumorRoutine()
+		}
+
+		if s.config.ProbeTimer > 0 {
+			go g.ProbeRoutine()
+		}
+
+		if s.config.MailboxTimer > 0 {
+			go g.MailboxRoutine()
+		}
+
+		g.StartMining()
+
+		if i >= len(s.Gossipers)-s.config.Joining {
+			go g.ChangeMembership(true)
+		}
+	}
+	s.settle()
+}
+
+//Stop detaches all the gossipers from the network. The gossipers cannot be restarted.
+func (s *Simulator) Stop() {
+	for i := range s.Gossipers {
+		s.Network.Close(nodeAddr(i))
+	}
+}
+
+//SetLinkFaults sets the faults injected on the packets sent by the i-th gossiper to the j-th gossiper
+func (s *Simulator) SetLinkFaults(i, j int, faults transport.LinkFaults) {
+	s.Faults[i].SetLink(nodeAddr(j), faults)
+}
+
+//SetDefaultFaults sets the faults injected on all the links that have no specific configuration
+func (s *Simulator) SetDefaultFaults(faults transport.LinkFaults) {
+	for _, ft := range s.Faults {
+		ft.SetLink(transport.DEFAULT_LINK, faults)
+	}
+}
+
+//Partition cuts all the links between the gossipers of group and the other gossipers
+func (s *Simulator) Partition(group []int) {
+	s.forEachCut(group, func(ft *transport.FaultyTransport, peers []string) {
+		ft.Partition(peers...)
+	})
+}
+
+//Heal restores all the links of all the gossipers
+func (s *Simulator) Heal() {
+	for _, ft := range s.Faults {
+		ft.Heal()
+	}
+}
+
+//SchedulePartition cuts the links between group and the other gossipers after start (in virtual time)
+//and restores them after duration. A duration of 0 means that the partition is never healed.
+func (s *Simulator) SchedulePartition(group []int, start, duration time.Duration) {
+	s.forEachCut(group, func(ft *transport.FaultyTransport, peers []string) {
+		ft.SchedulePartition(transport.Partition{
+			Peers:    peers,
+			Start:    start,
+			Duration: duration,
+		})
+	})
+}
+
+//forEachCut calls cut for every gossiper with the addresses of the gossipers on the other side of the partition of group
+func (s *Simulator) forEachCut(group []int, cut func(ft *transport.FaultyTransport, peers []string)) {
+	inGroup := make(map[int]bool)
+	for _, i := range group {
+		inGroup[i] = true
+	}
+
+	for i, ft := range s.Faults {
+		peers := make([]string, 0)
+		for j := range s.Faults {
+			if inGroup[i] != inGroup[j] {
+				peers = append(peers, nodeAddr(j))
+			}
+		}
+		cut(ft, peers)
+	}
+}
+
+//Advance moves the clock forward by d, one step at a time. The network settles after each step.
+func (s *Simulator) Advance(d time.Duration) {
+	for elapsed := time.Duration(0); elapsed < d; elapsed += s.config.Step {
+		s.Clock.Advance(s.config.Step)
+		s.settle()
+	}
+}
+
+//settle waits until the network is quiet: no packet is in flight, no tick of the clock is waiting for its receiver,
+//and neither the number of packets sent nor the number of goroutines changed during Settle consecutive observations.
+//It gives up after SETTLE_MAX_ROUNDS observations.
+func (s *Simulator) settle() {
+	sent, goroutines := s.Network.Sent(), runtime.NumGoroutine()
+	quiet := 0
+	for round := 0; round < SETTLE_MAX_ROUNDS && quiet < s.config.Settle; round++ {
+		runtime.Gosched()
+
+		nowSent, nowGoroutines := s.Network.Sent(), runtime.NumGoroutine()
+		if nowSent != sent || nowGoroutines != goroutines || s.inFlight() > 0 || s.Clock.Pending() > 0 {
+			sent, goroutines = nowSent, nowGoroutines
+			quiet = 0
+		} else {
+			quiet += 1
+		}
+	}
+}
+
+//inFlight returns the number of packets that were sent but not handled yet by their receiver
+func (s *Simulator) inFlight() int {
+	inFlight := s.Network.InFlight()
+	for _, ft := range s.Faults {
+		inFlight += ft.InFlight()
+	}
+	return inFlight
+}
+
+//RunUntil advances the simulation until condition returns nil or until timeout (in virtual time) is reached.
+//It returns the virtual time that elapsed and the last error returned by condition.
+func (s *Simulator) RunUntil(condition func() error, timeout time.Duration) (time.Duration, error) {
+	elapsed := time.Duration(0)
+	err := condition()
+	for err != nil && elapsed < timeout {
+		s.Advance(s.config.Step)
+		elapsed += s.config.Step
+		err = condition()
+	}
+	return elapsed, err
+}
+
+//SendRumor makes the i-th gossiper start a rumor, as if its client sent text
+func (s *Simulator) SendRumor(i int, text string) {
+	s.Gossipers[i].HandleMessage(&packet.Message{Text: text})
+	s.settle()
+}
+
+//SendPrivate makes the i-th gossiper send a private message to the j-th gossiper
+func (s *Simulator) SendPrivate(i, j int, text string) {
+	dest := nodeName(j)
+	s.Gossipers[i].HandleMessage(&packet.Message{Text: text, Destination: &dest})
+	s.settle()
+}
+
+//SendAnonymous makes the i-th gossiper send an anonymous message to the j-th gossiper
+func (s *Simulator) SendAnonymous(i, j int, text string) {
+	dest := nodeName(j)
+	s.Gossipers[i].HandleMessage(&packet.Message{Text: text, Destination: &dest, Anonymous: true})
+	s.settle()
+}
+
+//ShareFile writes content in the shared files and makes the i-th gossiper index it
+func (s *Simulator) ShareFile(i int, fileName string, content []byte) error {
+	if err := os.MkdirAll(fileSharing.PATH_SHAREDFILES, 0755); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(fileSharing.PATH_SHAREDFILES+fileName, content, 0644); err != nil {
+		return err
+	}
+
+	s.Gossipers[i].HandleMessage(&packet.Message{File: &fileName})
+	s.settle()
+	return nil
+}
@@ -0,0 +1,83 @@
+package simulation
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//TEST_NODES is the number of gossipers of each simulated network
+const TEST_NODES = 5
+
+//TEST_TIMEOUT is the virtual time after which a simulation fails
+const TEST_TIMEOUT = 300 * time.Second
+
+//TestMain runs the simulations in a temporary directory, since the gossipers store their keys and their shared files
+//in the working directory
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "simulation")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+//simulate starts TEST_NODES gossipers connected according to topology and makes each of them start a rumor
+func simulate(t *testing.T, topology Topology) *Simulator {
+	s, err := SimulatorFactory(TEST_NODES, topology, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+
+	for i := 0; i < TEST_NODES; i++ {
+		s.SendRumor(i, "rumor from node"+strconv.Itoa(i))
+	}
+	return s
+}
+
+func TestRumors(t *testing.T) {
+	for _, name := range []string{"ring", "star", "random"} {
+		t.Run(name, func(t *testing.T) {
+			topology, err := TopologyFromName(name, 0.3, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := simulate(t, topology)
+			defer s.Stop()
+
+			if elapsed, err := s.RunUntil(All(s.EqualVectorClocks, s.FullRoutingTables), TEST_TIMEOUT); err != nil {
+				t.Fatalf("not converged after %s: %s", elapsed, err)
+			}
+		})
+	}
+}
+
+func TestTLC(t *testing.T) {
+	s := simulate(t, Star)
+	defer s.Stop()
+
+	for i := 0; i < TEST_NODES; i++ {
+		if err := s.ShareFile(i, "test_node"+strconv.Itoa(i)+".txt", []byte("file of node"+strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	agreed := All(
+		s.EqualVectorClocks,
+		func() error { return s.AgreedChains(TEST_NODES) },
+		func() error { return s.AgreedMembership(TEST_NODES) },
+	)
+	if elapsed, err := s.RunUntil(agreed, TEST_TIMEOUT); err != nil {
+		t.Fatalf("no agreement after %s: %s", elapsed, err)
+	}
+}
@@ -0,0 +1,88 @@
+package simulation
+
+import (
+	"github.com/somecookie/Peerster/helper"
+	"math/rand"
+	"strconv"
+)
+
+//Topology gives, for each of the n gossipers of a simulation, the indexes of its initial peers.
+//The links are not necessarily symmetric, as with the -peers flag.
+type Topology func(n int) [][]int
+
+//Ring connects each gossiper to the next one, the last gossiper being connected to the first one.
+func Ring(n int) [][]int {
+	peers := make([][]int, n)
+	for i := 0; i < n; i++ {
+		if n > 1 {
+			peers[i] = []int{(i + 1) % n}
+		}
+	}
+	return peers
+}
+
+//Star connects every gossiper to the gossiper 0 and the gossiper 0 to all the others.
+func Star(n int) [][]int {
+	peers := make([][]int, n)
+	for i := 1; i < n; i++ {
+		peers[0] = append(peers[0], i)
+		peers[i] = []int{0}
+	}
+	return peers
+}
+
+//RandomGraph returns a Topology where every pair of gossipers is linked (in both directions) with probability p.
+//A ring is added so that the graph is always connected. The graph only depends on seed.
+func RandomGraph(p float64, seed int64) Topology {
+	return func(n int) [][]int {
+		r := rand.New(rand.NewSource(seed))
+		peers := Ring(n)
+
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				if r.Float64() < p {
+					peers[i] = appendIfMissing(peers[i], j)
+					peers[j] = appendIfMissing(peers[j], i)
+				}
+			}
+		}
+		return peers
+	}
+}
+
+//TopologyFromName returns the topology called name: "ring", "star" or "random".
+//p and seed are only used by the random graph.
+func TopologyFromName(name string, p float64, seed int64) (Topology, error) {
+	switch name {
+	case "ring":
+		return Ring, nil
+	case "star":
+		return Star, nil
+	case "random":
+		return RandomGraph(p, seed), nil
+	default:
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: "unknown topology " + name,
+			Where:        "topology.go",
+		}
+	}
+}
+
+func appendIfMissing(ls []int, x int) []int {
+	for _, y := range ls {
+		if x == y {
+			return ls
+		}
+	}
+	return append(ls, x)
+}
+
+//nodeName is the name of the i-th gossiper of a simulation
+func nodeName(i int) string {
+	return "node" + strconv.Itoa(i)
+}
+
+//nodeAddr is the address of the i-th gossiper of a simulation
+func nodeAddr(i int) string {
+	return "127.0.0.1:" + strconv.Itoa(5000+i)
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/dedis/protobuf"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//FileStore is an append-only Backend. Each record is serialized and written to the log file
+//prefixed by its length on 4 bytes (big endian). The file is synced after each append.
+//A record that was only partially written (e.g., because of a crash) is truncated when the log file is opened,
+//so that the records appended afterwards are framed correctly.
+//All operations on the FileStore are thread-safe.
+type FileStore struct {
+	sync.Mutex
+	path string
+	file *os.File
+}
+
+//FileStoreFactory opens (or creates) the log file at path
+func FileStoreFactory(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStore{
+		Mutex: sync.Mutex{},
+		path:  path,
+		file:  file,
+	}
+	if err := fs.Replay(nil); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileStore) Append(record *Record) error {
+	recordBytes, err := protobuf.Encode(record)
+	if err != nil {
+		return err
+	}
+
+	entry := make([]byte, 4, 4+len(recordBytes))
+	binary.BigEndian.PutUint32(entry, uint32(len(recordBytes)))
+	entry = append(entry, recordBytes...)
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	if _, err := fs.file.Write(entry); err != nil {
+		return err
+	}
+	return fs.file.Sync()
+}
+
+//Replay calls handler on every complete record of the log file and truncates the file after the last one.
+//A nil handler only truncates the file.
+func (fs *FileStore) Replay(handler func(record *Record)) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	file, err := os.Open(fs.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, 4)
+	var complete int64
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fs.truncate(complete)
+		} else if err != nil {
+			return err
+		}
+
+		recordBytes := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(reader, recordBytes); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fs.truncate(complete)
+		} else if err != nil {
+			return err
+		}
+		complete += int64(len(header) + len(recordBytes))
+
+		if handler != nil {
+			record := &Record{}
+			if err := protobuf.Decode(recordBytes, record); err != nil {
+				return err
+			}
+			handler(record)
+		}
+	}
+}
+
+//truncate drops the end of the log file after size bytes, if any. fs must be locked.
+func (fs *FileStore) truncate(size int64) error {
+	info, err := fs.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() <= size {
+		return nil
+	}
+	if err := fs.file.Truncate(size); err != nil {
+		return err
+	}
+	return fs.file.Sync()
+}
+
+func (fs *FileStore) Close() error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	return fs.file.Close()
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/somecookie/Peerster/packet"
+)
+
+//depositRecord returns a record whose message is identified by id
+func depositRecord(id uint32) *Record {
+	return &Record{Deposit: &DepositRecord{Message: packet.PrivateMessage{
+		Origin:      "A",
+		ID:          id,
+		Text:        "message " + strconv.Itoa(int(id)),
+		Destination: "B",
+	}}}
+}
+
+//replayIDs returns the ids of the messages of the records of fs, in order
+func replayIDs(t *testing.T, fs *FileStore) []uint32 {
+	ids := make([]uint32, 0)
+	err := fs.Replay(func(record *Record) {
+		if record.Deposit == nil {
+			t.Fatalf("unexpected record %v", record)
+		}
+		ids = append(ids, record.Deposit.Message.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func checkIDs(t *testing.T, ids []uint32, expected ...uint32) {
+	if len(ids) != len(expected) {
+		t.Fatalf("replayed %v, expected %v", ids, expected)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("replayed %v, expected %v", ids, expected)
+		}
+	}
+}
+
+//openStore opens the FileStore at path, failing the test on error
+func openStore(t *testing.T, path string) *FileStore {
+	fs, err := FileStoreFactory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func appendRecords(t *testing.T, fs *FileStore, ids ...uint32) {
+	for _, id := range ids {
+		if err := fs.Append(depositRecord(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tempLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "state", "test.log"), func() { os.RemoveAll(dir) }
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	path, clean := tempLog(t)
+	defer clean()
+
+	fs := openStore(t, path)
+	appendRecords(t, fs, 1, 2, 3)
+	checkIDs(t, replayIDs(t, fs), 1, 2, 3)
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openStore(t, path)
+	defer fs.Close()
+	appendRecords(t, fs, 4)
+	checkIDs(t, replayIDs(t, fs), 1, 2, 3, 4)
+}
+
+func TestFileStoreTornTail(t *testing.T) {
+	for _, torn := range []int{1, 3, 4, 6} {
+		t.Run(strconv.Itoa(torn), func(t *testing.T) {
+			path, clean := tempLog(t)
+			defer clean()
+
+			fs := openStore(t, path)
+			appendRecords(t, fs, 1, 2)
+			fs.Close()
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			complete := info.Size()
+
+			//a crash in the middle of the append of the third record
+			fs = openStore(t, path)
+			appendRecords(t, fs, 3)
+			fs.Close()
+			if err := os.Truncate(path, complete+int64(torn)); err != nil {
+				t.Fatal(err)
+			}
+
+			fs = openStore(t, path)
+			defer fs.Close()
+			if info, err := os.Stat(path); err != nil {
+				t.Fatal(err)
+			} else if info.Size() != complete {
+				t.Fatalf("size %d after opening, expected %d", info.Size(), complete)
+			}
+
+			appendRecords(t, fs, 4, 5)
+			checkIDs(t, replayIDs(t, fs), 1, 2, 4, 5)
+		})
+	}
+}
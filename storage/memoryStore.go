@@ -0,0 +1,42 @@
+package storage
+
+import "sync"
+
+//MemoryStore is a Backend keeping the records in memory. It is volatile: its records are lost with the MemoryStore,
+//so a new gossiper never replays the records of a previous one.
+//All operations on the MemoryStore are thread-safe.
+type MemoryStore struct {
+	sync.RWMutex
+	records []*Record
+}
+
+func MemoryStoreFactory() *MemoryStore {
+	return &MemoryStore{
+		RWMutex: sync.RWMutex{},
+		records: make([]*Record, 0),
+	}
+}
+
+func (ms *MemoryStore) Append(record *Record) error {
+	ms.Lock()
+	defer ms.Unlock()
+
+	ms.records = append(ms.records, record)
+	return nil
+}
+
+func (ms *MemoryStore) Replay(handler func(record *Record)) error {
+	ms.RLock()
+	records := make([]*Record, len(ms.records))
+	copy(records, ms.records)
+	ms.RUnlock()
+
+	for _, record := range records {
+		handler(record)
+	}
+	return nil
+}
+
+func (ms *MemoryStore) Close() error {
+	return nil
+}
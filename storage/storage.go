@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"github.com/somecookie/Peerster/blockchain"
+	"github.com/somecookie/Peerster/helper"
+	"github.com/somecookie/Peerster/packet"
+)
+
+const PATH_STATE = "./_State/"
+
+//Record is an entry of the log of the gossiper state or of the ledger log. One and only one field should be non-nil.
+//Packet    *packet.GossipPacket is a rumor or TLC message that has been archived
+//Private   *PrivateRecord is a private message that has been added to a conversation
+//Deposit   *DepositRecord is a private message deposited at the gossiper, or removed from its mailbox
+//Confirmed *packet.TLCMessage is a confirmed TLC message together with its witnesses (ledger log)
+//Block     *BlockRecord is a block added to the chain (ledger log)
+//Snapshot  *Snapshot is the state derived from the chain when the snapshot was taken (ledger log)
+type Record struct {
+	Packet    *packet.GossipPacket
+	Private   *PrivateRecord
+	Deposit   *DepositRecord
+	Confirmed *packet.TLCMessage
+	Block     *BlockRecord
+	Snapshot  *Snapshot
+}
+
+//PrivateRecord is a private message together with the name of the peer of the conversation.
+//A record for a message that is already in the conversation updates its delivery status.
+//Status    string is the delivery status of the message
+//Encrypted bool tells if the message is sent encrypted for its destination
+type PrivateRecord struct {
+	Peer      string
+	Message   packet.PrivateMessage
+	Status    string
+	Encrypted bool
+}
+
+//DepositRecord is a private message deposited at the gossiper by another gossiper, which keeps it until its destination
+//acknowledges it.
+//Message packet.PrivateMessage is the deposited message
+//Removed bool tells that the destination acknowledged the message and that it is no longer stored
+type DepositRecord struct {
+	Message packet.PrivateMessage
+	Removed bool
+}
+
+//BlockRecord is a block added to the chain. One and only one field should be non-nil.
+//Mined     *blockchain.Block is a block mined with a proof of work
+//Published *blockchain.BlockPublish is a block agreed on with TLC
+type BlockRecord struct {
+	Mined     *blockchain.Block
+	Published *blockchain.BlockPublish
+}
+
+//Linked returns the block of the record, nil if the record is empty
+func (br *BlockRecord) Linked() blockchain.Linked {
+	switch {
+	case br.Mined != nil:
+		return br.Mined
+	case br.Published != nil:
+		return br.Published
+	default:
+		return nil
+	}
+}
+
+//Snapshot is the state derived from the chain, so that it does not have to be rebuilt from the whole log.
+//Head          [32]byte is the head of the longest chain when the snapshot was taken
+//Registrations []blockchain.Registration are the registered file names, including the names claimed by confirmed TLC messages
+//Membership    blockchain.MembershipSnapshot are the members of TLC
+type Snapshot struct {
+	Head          [32]byte
+	Registrations []blockchain.Registration
+	Membership    blockchain.MembershipSnapshot
+}
+
+//Backend is the interface of the storages able to persist the state of a gossiper.
+//Append durably stores a new record.
+//Replay calls handler on every stored record, in the order they were appended.
+//Close releases the resources of the backend.
+type Backend interface {
+	Append(record *Record) error
+	Replay(handler func(record *Record)) error
+	Close() error
+}
+
+//BackendFactory creates the backend called kind for the state of the gossiper called name.
+//kind is either "none", "memory" or "file". The "none" backend is nil and the "memory" backend is volatile.
+func BackendFactory(kind, name string) (Backend, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		return MemoryStoreFactory(), nil
+	case "file":
+		return FileStoreFactory(PATH_STATE + name + ".log")
+	default:
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: "unknown storage backend " + kind,
+			Where:        "storage.go",
+		}
+	}
+}
+
+//LedgerBackendFactory creates the backend called kind for the ledger log of the gossiper called name.
+//It is kept apart from the state, so that it can be verified offline. kind is the same as for BackendFactory.
+func LedgerBackendFactory(kind, name string) (Backend, error) {
+	if kind == "file" {
+		return FileStoreFactory(LedgerPath(name))
+	}
+	return BackendFactory(kind, name)
+}
+
+//LedgerPath returns the path of the file of the ledger log of the gossiper called name
+func LedgerPath(name string) string {
+	return PATH_STATE + name + ".ledger"
+}
@@ -0,0 +1,377 @@
+package transport
+
+import (
+	"github.com/somecookie/Peerster/clock"
+	"github.com/somecookie/Peerster/helper"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//DEFAULT_LINK is the key of the faults applied to the peers that have no specific configuration.
+const DEFAULT_LINK = "*"
+
+//REORDER_DELAY is the additional delay of a reordered packet.
+const REORDER_DELAY = 100 * time.Millisecond
+
+//LinkFaults describes the faults injected on the packets sent to a peer.
+//DropRate  float64 is the probability that a packet is dropped
+//Latency   time.Duration is the delay added to every packet
+//Jitter    time.Duration is the maximal random delay added on top of the latency
+//Reorder   float64 is the probability that a packet is held back so that it arrives after the next ones
+//Duplicate float64 is the probability that a packet is sent twice
+type LinkFaults struct {
+	DropRate  float64
+	Latency   time.Duration
+	Jitter    time.Duration
+	Reorder   float64
+	Duplicate float64
+}
+
+//Partition is a scheduled partition: the peers are unreachable from Start to Start+Duration.
+//A Duration of 0 means that the partition is never healed.
+type Partition struct {
+	Peers    []string
+	Start    time.Duration
+	Duration time.Duration
+}
+
+//FaultyTransport wraps a Transport and injects faults on its packets.
+//The faults of LinkFaults are applied on the packets sent to a peer.
+//A partitioned peer is cut in both directions: its packets are dropped on reception as well.
+//All operations on the FaultyTransport are thread-safe.
+type FaultyTransport struct {
+	sync.RWMutex
+	inner       Transport
+	clock       clock.Clock
+	links       map[string]LinkFaults
+	partitioned map[string]bool
+	randMutex   sync.Mutex
+	random      *rand.Rand
+	incoming    chan received
+	pending     int
+	handling    bool
+	closed      chan struct{}
+	err         error
+}
+
+//FaultyTransportFactory wraps inner. The delays are measured with clk and the random faults only depend on seed.
+func FaultyTransportFactory(inner Transport, clk clock.Clock, seed int64) *FaultyTransport {
+	ft := &FaultyTransport{
+		RWMutex:     sync.RWMutex{},
+		inner:       inner,
+		clock:       clk,
+		links:       make(map[string]LinkFaults),
+		partitioned: make(map[string]bool),
+		randMutex:   sync.Mutex{},
+		random:      rand.New(rand.NewSource(seed)),
+		incoming:    make(chan received, MEMORY_QUEUE_SIZE),
+		pending:     0,
+		handling:    false,
+		closed:      make(chan struct{}),
+	}
+
+	go ft.receiveLoop()
+	return ft
+}
+
+//SetLink sets the faults injected on the packets sent to peer. peer can be DEFAULT_LINK.
+func (ft *FaultyTransport) SetLink(peer string, faults LinkFaults) {
+	ft.Lock()
+	defer ft.Unlock()
+	ft.links[peer] = faults
+}
+
+//ClearLink removes the specific faults of peer
+func (ft *FaultyTransport) ClearLink(peer string) {
+	ft.Lock()
+	defer ft.Unlock()
+	delete(ft.links, peer)
+}
+
+//Links returns a copy of the configured faults per peer
+func (ft *FaultyTransport) Links() map[string]LinkFaults {
+	ft.RLock()
+	defer ft.RUnlock()
+
+	links := make(map[string]LinkFaults, len(ft.links))
+	for peer, faults := range ft.links {
+		links[peer] = faults
+	}
+	return links
+}
+
+//Partition cuts the links with peers
+func (ft *FaultyTransport) Partition(peers ...string) {
+	ft.Lock()
+	defer ft.Unlock()
+
+	for _, peer := range peers {
+		ft.partitioned[peer] = true
+	}
+}
+
+//Heal restores the links with peers. If no peer is given, all the links are restored.
+func (ft *FaultyTransport) Heal(peers ...string) {
+	ft.Lock()
+	defer ft.Unlock()
+
+	if len(peers) == 0 {
+		ft.partitioned = make(map[string]bool)
+	}
+	for _, peer := range peers {
+		delete(ft.partitioned, peer)
+	}
+}
+
+//Partitioned returns the sorted list of the peers that are currently cut
+func (ft *FaultyTransport) Partitioned() []string {
+	ft.RLock()
+	defer ft.RUnlock()
+
+	peers := make([]string, 0, len(ft.partitioned))
+	for peer := range ft.partitioned {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+//SchedulePartition applies the partition p when the clock reaches p.Start (relative to now) and heals it after p.Duration
+func (ft *FaultyTransport) SchedulePartition(p Partition) {
+	go func() {
+		clock.Sleep(ft.clock, p.Start)
+		ft.Partition(p.Peers...)
+
+		if p.Duration > 0 {
+			clock.Sleep(ft.clock, p.Duration)
+			ft.Heal(p.Peers...)
+		}
+	}()
+}
+
+func (ft *FaultyTransport) Send(packetBytes []byte, dest net.Addr) error {
+	ft.RLock()
+	partitioned := ft.partitioned[dest.String()]
+	faults, ok := ft.links[dest.String()]
+	if !ok {
+		faults = ft.links[DEFAULT_LINK]
+	}
+	ft.RUnlock()
+
+	if partitioned || ft.draw() < faults.DropRate {
+		return nil
+	}
+
+	copies := 1
+	if ft.draw() < faults.Duplicate {
+		copies = 2
+	}
+
+	for i := 0; i < copies; i++ {
+		delay := faults.Latency
+		if faults.Jitter > 0 {
+			delay += time.Duration(ft.draw() * float64(faults.Jitter))
+		}
+		if ft.draw() < faults.Reorder {
+			delay += faults.Latency + faults.Jitter + REORDER_DELAY
+		}
+
+		if delay == 0 {
+			if err := ft.inner.Send(packetBytes, dest); err != nil {
+				return err
+			}
+			continue
+		}
+
+		go func(delay time.Duration) {
+			clock.Sleep(ft.clock, delay)
+			helper.LogError(ft.inner.Send(packetBytes, dest))
+		}(delay)
+	}
+	return nil
+}
+
+//Receive returns the next packet that was not dropped. The previous one is considered handled by the receiver.
+func (ft *FaultyTransport) Receive() ([]byte, net.Addr, error) {
+	ft.Lock()
+	if ft.handling {
+		ft.handling = false
+		ft.pending -= 1
+	}
+	ft.Unlock()
+
+	select {
+	case r := <-ft.incoming:
+		ft.Lock()
+		ft.handling = true
+		ft.Unlock()
+		return r.packetBytes, r.from, nil
+	case <-ft.closed:
+		return nil, nil, ft.err
+	}
+}
+
+func (ft *FaultyTransport) Resolve(address string) (net.Addr, error) {
+	return ft.inner.Resolve(address)
+}
+
+func (ft *FaultyTransport) LocalAddr() net.Addr {
+	return ft.inner.LocalAddr()
+}
+
+func (ft *FaultyTransport) Close() error {
+	return ft.inner.Close()
+}
+
+//receiveLoop receives the packets of the inner transport and drops those coming from partitioned peers
+func (ft *FaultyTransport) receiveLoop() {
+	for {
+		packetBytes, from, err := ft.inner.Receive()
+		if _, closed := err.(*ClosedError); closed {
+			ft.err = err
+			close(ft.closed)
+			return
+		} else if err != nil {
+			helper.LogError(err)
+			continue
+		}
+
+		ft.Lock()
+		partitioned := ft.partitioned[from.String()]
+		if !partitioned {
+			ft.pending += 1
+		}
+		ft.Unlock()
+
+		if !partitioned {
+			ft.incoming <- received{packetBytes: packetBytes, from: from}
+		}
+	}
+}
+
+//InFlight returns the number of received packets that were not handled by the receiver yet.
+//A packet is handled once the receiver asks for the next one.
+func (ft *FaultyTransport) InFlight() int {
+	ft.RLock()
+	defer ft.RUnlock()
+	return ft.pending
+}
+
+//draw returns a random number in [0,1)
+func (ft *FaultyTransport) draw() float64 {
+	ft.randMutex.Lock()
+	defer ft.randMutex.Unlock()
+	return ft.random.Float64()
+}
+
+//ParseLinkFaults parses a description of the form "peer=ip:port,drop=0.1,latency=50ms,jitter=10ms,reorder=0.05,duplicate=0.01".
+//All the keys are optional. If there is no peer, the faults apply to DEFAULT_LINK.
+func ParseLinkFaults(spec string) (string, LinkFaults, error) {
+	peer := DEFAULT_LINK
+	faults := LinkFaults{}
+
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		keyValue := strings.SplitN(field, "=", 2)
+		if len(keyValue) != 2 {
+			return "", faults, faultsError(field)
+		}
+
+		var err error
+		switch keyValue[0] {
+		case "peer":
+			peer = keyValue[1]
+		case "drop":
+			faults.DropRate, err = parseProbability(keyValue[1])
+		case "latency":
+			faults.Latency, err = time.ParseDuration(keyValue[1])
+		case "jitter":
+			faults.Jitter, err = time.ParseDuration(keyValue[1])
+		case "reorder":
+			faults.Reorder, err = parseProbability(keyValue[1])
+		case "duplicate":
+			faults.Duplicate, err = parseProbability(keyValue[1])
+		default:
+			err = faultsError(field)
+		}
+
+		if err != nil {
+			return "", faults, faultsError(field)
+		}
+	}
+	return peer, faults, nil
+}
+
+//ParseFaults parses a list of link descriptions (see ParseLinkFaults) separated by semicolons
+func ParseFaults(spec string) (map[string]LinkFaults, error) {
+	links := make(map[string]LinkFaults)
+	for _, linkSpec := range strings.Split(spec, ";") {
+		if strings.TrimSpace(linkSpec) == "" {
+			continue
+		}
+
+		peer, faults, err := ParseLinkFaults(linkSpec)
+		if err != nil {
+			return nil, err
+		}
+		links[peer] = faults
+	}
+	return links, nil
+}
+
+//ParsePartitions parses a list of partitions separated by semicolons.
+//A partition is of the form "start/duration/peer1,peer2", e.g. "10s/30s/127.0.0.1:5001".
+func ParsePartitions(spec string) ([]Partition, error) {
+	partitions := make([]Partition, 0)
+	for _, partitionSpec := range strings.Split(spec, ";") {
+		if strings.TrimSpace(partitionSpec) == "" {
+			continue
+		}
+
+		fields := strings.SplitN(partitionSpec, "/", 3)
+		if len(fields) != 3 {
+			return nil, faultsError(partitionSpec)
+		}
+
+		start, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, faultsError(partitionSpec)
+		}
+
+		duration, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return nil, faultsError(partitionSpec)
+		}
+
+		partitions = append(partitions, Partition{
+			Peers:    strings.Split(fields[2], ","),
+			Start:    start,
+			Duration: duration,
+		})
+	}
+	return partitions, nil
+}
+
+func parseProbability(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p < 0 || p > 1 {
+		return 0, faultsError(s)
+	}
+	return p, nil
+}
+
+func faultsError(spec string) error {
+	return &helper.IllegalArgumentError{
+		ErrorMessage: "invalid fault description " + spec,
+		Where:        "faults.go",
+	}
+}
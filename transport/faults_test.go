@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFaults(t *testing.T) {
+	links, err := ParseFaults("drop=0.1,latency=50ms ; peer=127.0.0.1:5001,jitter=10ms,reorder=0.05,duplicate=1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]LinkFaults{
+		DEFAULT_LINK:     {DropRate: 0.1, Latency: 50 * time.Millisecond},
+		"127.0.0.1:5001": {Jitter: 10 * time.Millisecond, Reorder: 0.05, Duplicate: 1},
+	}
+	if !reflect.DeepEqual(links, expected) {
+		t.Fatalf("parsed %v, expected %v", links, expected)
+	}
+
+	if links, err := ParseFaults(""); err != nil || len(links) != 0 {
+		t.Fatalf("parsed %v (%v) for no fault", links, err)
+	}
+}
+
+func TestParseFaultsInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"drop",
+		"drop=1.5",
+		"drop=-0.1",
+		"reorder=often",
+		"latency=50",
+		"jitter=fast",
+		"loss=0.1",
+		"drop=0.1;duplicate=2",
+	} {
+		if _, err := ParseFaults(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestParsePartitions(t *testing.T) {
+	partitions, err := ParsePartitions("10s/30s/127.0.0.1:5001,127.0.0.1:5002;1m/0s/127.0.0.1:5003;")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Partition{
+		{Peers: []string{"127.0.0.1:5001", "127.0.0.1:5002"}, Start: 10 * time.Second, Duration: 30 * time.Second},
+		{Peers: []string{"127.0.0.1:5003"}, Start: time.Minute, Duration: 0},
+	}
+	if !reflect.DeepEqual(partitions, expected) {
+		t.Fatalf("parsed %v, expected %v", partitions, expected)
+	}
+
+	for _, spec := range []string{"10s/30s", "10/30s/127.0.0.1:5001", "10s/forever/127.0.0.1:5001"} {
+		if _, err := ParsePartitions(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package transport
+
+import (
+	"encoding/binary"
+	"github.com/somecookie/Peerster/clock"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//MAX_UDP_SIZE is the size of the read buffers. It is large enough for any UDP datagram.
+const MAX_UDP_SIZE = 65535
+
+//MAX_DATAGRAM_SIZE is the largest datagram sent by the gossiper. Larger packets are fragmented.
+const MAX_DATAGRAM_SIZE = 8192
+
+//MAX_PACKET_SIZE is the largest encoded packet that can be fragmented and reassembled.
+const MAX_PACKET_SIZE = 1 << 24
+
+//REASSEMBLY_TIMEOUT is the time after which an incomplete packet is dropped.
+const REASSEMBLY_TIMEOUT = 5 * time.Second
+
+//MAX_PARTIALS_PER_PEER is the number of incomplete packets kept per peer
+//MAX_PARTIALS is the number of incomplete packets kept in total
+//MAX_BUFFERED_SIZE is the number of bytes of fragments kept in total
+const (
+	MAX_PARTIALS_PER_PEER = 8
+	MAX_PARTIALS          = 128
+	MAX_BUFFERED_SIZE     = 2 * MAX_PACKET_SIZE
+)
+
+//A fragment starts with a header of HEADER_SIZE bytes:
+//  - FRAGMENT_MARKER on 1 byte. A protobuf message never starts with 0 (field numbers start at 1),
+//    so a datagram that is not fragmented can be sent as is.
+//  - the ID of the packet on 4 bytes (big endian)
+//  - the index of the fragment on 2 bytes (big endian)
+//  - the total number of fragments on 2 bytes (big endian)
+const (
+	FRAGMENT_MARKER  = 0
+	HEADER_SIZE      = 9
+	FRAGMENT_PAYLOAD = MAX_DATAGRAM_SIZE - HEADER_SIZE
+	MAX_FRAGMENTS    = (MAX_PACKET_SIZE + FRAGMENT_PAYLOAD - 1) / FRAGMENT_PAYLOAD
+)
+
+//Fragmenter splits encoded packets into datagrams of at most MAX_DATAGRAM_SIZE bytes.
+//It is thread-safe.
+type Fragmenter struct {
+	nextID uint32
+}
+
+func FragmenterFactory() *Fragmenter {
+	return &Fragmenter{nextID: 0}
+}
+
+//Fragment returns the datagrams that must be sent for packetBytes.
+//A packet that fits in a single datagram is returned unchanged.
+func (f *Fragmenter) Fragment(packetBytes []byte) ([][]byte, error) {
+	if len(packetBytes) > MAX_PACKET_SIZE {
+		return nil, &PacketTooLargeError{Size: len(packetBytes)}
+	}
+
+	if len(packetBytes) <= MAX_DATAGRAM_SIZE && (len(packetBytes) == 0 || packetBytes[0] != FRAGMENT_MARKER) {
+		return [][]byte{packetBytes}, nil
+	}
+
+	ID := atomic.AddUint32(&f.nextID, 1)
+	total := (len(packetBytes) + FRAGMENT_PAYLOAD - 1) / FRAGMENT_PAYLOAD
+	datagrams := make([][]byte, 0, total)
+
+	for i := 0; i < total; i++ {
+		start := i * FRAGMENT_PAYLOAD
+		end := start + FRAGMENT_PAYLOAD
+		if end > len(packetBytes) {
+			end = len(packetBytes)
+		}
+
+		datagram := make([]byte, HEADER_SIZE, HEADER_SIZE+end-start)
+		datagram[0] = FRAGMENT_MARKER
+		binary.BigEndian.PutUint32(datagram[1:5], ID)
+		binary.BigEndian.PutUint16(datagram[5:7], uint16(i))
+		binary.BigEndian.PutUint16(datagram[7:9], uint16(total))
+		datagrams = append(datagrams, append(datagram, packetBytes[start:end]...))
+	}
+
+	return datagrams, nil
+}
+
+//partialPacket contains the fragments of a packet received so far
+type partialPacket struct {
+	from      string
+	fragments [][]byte
+	received  int
+	size      int
+	started   time.Time
+	order     uint64
+}
+
+//Reassembler puts the fragments received from the peers back together.
+//Incomplete packets are dropped after timeout, measured with clock. At most MAX_PARTIALS_PER_PEER incomplete packets
+//per peer, MAX_PARTIALS incomplete packets in total and MAX_BUFFERED_SIZE bytes of fragments are kept: the oldest
+//incomplete packet is dropped to make room for a new one, so that a peer cannot exhaust the memory.
+//All operations on the Reassembler are thread-safe.
+type Reassembler struct {
+	sync.Mutex
+	partials  map[string]*partialPacket
+	perPeer   map[string]int
+	buffered  int
+	nextOrder uint64
+	timeout   time.Duration
+	clock     clock.Clock
+}
+
+func ReassemblerFactory(timeout time.Duration, clk clock.Clock) *Reassembler {
+	return &Reassembler{
+		Mutex:     sync.Mutex{},
+		partials:  make(map[string]*partialPacket),
+		perPeer:   make(map[string]int),
+		buffered:  0,
+		nextOrder: 0,
+		timeout:   timeout,
+		clock:     clk,
+	}
+}
+
+//Add processes the datagram received from the peer at address from.
+//It returns the encoded packet and true if the datagram completes a packet (or was not fragmented).
+//The returned slice does not share memory with datagram when the packet was fragmented.
+func (r *Reassembler) Add(from string, datagram []byte) ([]byte, bool, error) {
+	if len(datagram) == 0 || datagram[0] != FRAGMENT_MARKER {
+		return datagram, true, nil
+	}
+
+	if len(datagram) < HEADER_SIZE {
+		return nil, false, &FragmentError{From: from, Reason: "truncated header"}
+	}
+
+	ID := binary.BigEndian.Uint32(datagram[1:5])
+	index := int(binary.BigEndian.Uint16(datagram[5:7]))
+	total := int(binary.BigEndian.Uint16(datagram[7:9]))
+
+	if total == 0 || total > MAX_FRAGMENTS || index >= total {
+		return nil, false, &FragmentError{From: from, Reason: "invalid index " + strconv.Itoa(index) + "/" + strconv.Itoa(total)}
+	}
+
+	key := from + "/" + strconv.FormatUint(uint64(ID), 10)
+
+	r.Lock()
+	defer r.Unlock()
+
+	now := r.clock.Now()
+	r.expire(now)
+
+	partial, ok := r.partials[key]
+	if !ok {
+		for r.perPeer[from] >= MAX_PARTIALS_PER_PEER {
+			r.dropOldest(from)
+		}
+		for len(r.partials) >= MAX_PARTIALS {
+			r.dropOldest("")
+		}
+
+		partial = &partialPacket{
+			from:      from,
+			fragments: make([][]byte, total),
+			received:  0,
+			size:      0,
+			started:   now,
+			order:     r.nextOrder,
+		}
+		r.nextOrder++
+		r.partials[key] = partial
+		r.perPeer[from]++
+	}
+
+	if len(partial.fragments) != total {
+		return nil, false, &FragmentError{From: from, Reason: "inconsistent number of fragments"}
+	}
+
+	if partial.fragments[index] != nil {
+		return nil, false, nil
+	}
+
+	payload := make([]byte, len(datagram)-HEADER_SIZE)
+	copy(payload, datagram[HEADER_SIZE:])
+	partial.fragments[index] = payload
+	partial.received++
+	partial.size += len(payload)
+	r.buffered += len(payload)
+
+	if partial.size > MAX_PACKET_SIZE {
+		r.remove(key)
+		return nil, false, &PacketTooLargeError{Size: partial.size}
+	}
+
+	for r.buffered > MAX_BUFFERED_SIZE {
+		r.dropOldest("")
+	}
+	if r.partials[key] != partial {
+		return nil, false, nil
+	}
+
+	if partial.received < total {
+		return nil, false, nil
+	}
+
+	r.remove(key)
+
+	packetBytes := make([]byte, 0, partial.size)
+	for _, fragment := range partial.fragments {
+		packetBytes = append(packetBytes, fragment...)
+	}
+	return packetBytes, true, nil
+}
+
+//expire drops the incomplete packets that were started more than timeout before now. The Mutex must be held.
+func (r *Reassembler) expire(now time.Time) {
+	for key, partial := range r.partials {
+		if now.Sub(partial.started) > r.timeout {
+			r.remove(key)
+		}
+	}
+}
+
+//dropOldest drops the oldest incomplete packet of the peer at address from, or of any peer if from is empty.
+//The Mutex must be held.
+func (r *Reassembler) dropOldest(from string) {
+	oldest := ""
+	for key, partial := range r.partials {
+		if from != "" && partial.from != from {
+			continue
+		}
+		if oldest == "" || partial.order < r.partials[oldest].order {
+			oldest = key
+		}
+	}
+	if oldest != "" {
+		r.remove(oldest)
+	}
+}
+
+//remove forgets the incomplete packet stored at key. The Mutex must be held.
+func (r *Reassembler) remove(key string) {
+	partial, ok := r.partials[key]
+	if !ok {
+		return
+	}
+
+	delete(r.partials, key)
+	r.buffered -= partial.size
+	r.perPeer[partial.from]--
+	if r.perPeer[partial.from] <= 0 {
+		delete(r.perPeer, partial.from)
+	}
+}
+
+//PacketTooLargeError is returned when a packet is larger than MAX_PACKET_SIZE.
+type PacketTooLargeError struct {
+	Size int
+}
+
+func (e *PacketTooLargeError) Error() string {
+	return "packet of " + strconv.Itoa(e.Size) + " bytes is larger than " + strconv.Itoa(MAX_PACKET_SIZE) + " bytes"
+}
+
+//FragmentError is returned when a malformed fragment is received.
+type FragmentError struct {
+	From   string
+	Reason string
+}
+
+func (e *FragmentError) Error() string {
+	return "invalid fragment from " + e.From + ": " + e.Reason
+}
@@ -0,0 +1,161 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/somecookie/Peerster/clock"
+)
+
+//fragment builds the datagram of the fragment index/total of the packet ID
+func fragment(ID uint32, index, total int, payload []byte) []byte {
+	datagram := make([]byte, HEADER_SIZE, HEADER_SIZE+len(payload))
+	datagram[0] = FRAGMENT_MARKER
+	binary.BigEndian.PutUint32(datagram[1:5], ID)
+	binary.BigEndian.PutUint16(datagram[5:7], uint16(index))
+	binary.BigEndian.PutUint16(datagram[7:9], uint16(total))
+	return append(datagram, payload...)
+}
+
+func reassemblerAt(start time.Time) (*Reassembler, *clock.ManualClock) {
+	clk := clock.ManualClockFactory(start)
+	return ReassemblerFactory(REASSEMBLY_TIMEOUT, clk), clk
+}
+
+//add adds the datagram and fails the test on error
+func add(t *testing.T, r *Reassembler, from string, datagram []byte) ([]byte, bool) {
+	packetBytes, complete, err := r.Add(from, datagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packetBytes, complete
+}
+
+func TestFragmentRoundTrip(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	large := make([]byte, 3*FRAGMENT_PAYLOAD+100)
+	random.Read(large)
+	large[0] = 1
+
+	packets := map[string][]byte{
+		"small":  []byte("a small packet"),
+		"marker": {FRAGMENT_MARKER, 1, 2, 3},
+		"large":  large,
+	}
+	for name, packetBytes := range packets {
+		t.Run(name, func(t *testing.T) {
+			datagrams, err := FragmenterFactory().Fragment(packetBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, datagram := range datagrams {
+				if len(datagram) > MAX_DATAGRAM_SIZE {
+					t.Fatalf("datagram of %d bytes", len(datagram))
+				}
+			}
+
+			r, _ := reassemblerAt(time.Unix(0, 0))
+			//the fragments arrive in the reverse order and the last one is duplicated
+			for i := len(datagrams) - 1; i > 0; i-- {
+				if _, complete := add(t, r, "peer", datagrams[i]); complete {
+					t.Fatalf("complete after %d fragments out of %d", len(datagrams)-i, len(datagrams))
+				}
+			}
+			if len(datagrams) > 1 {
+				if _, complete := add(t, r, "peer", datagrams[len(datagrams)-1]); complete {
+					t.Fatal("complete after a duplicated fragment")
+				}
+			}
+
+			reassembled, complete := add(t, r, "peer", datagrams[0])
+			if !complete || !bytes.Equal(reassembled, packetBytes) {
+				t.Fatalf("reassembled %d bytes (complete %t), expected %d bytes", len(reassembled), complete, len(packetBytes))
+			}
+		})
+	}
+}
+
+func TestFragmentTooLarge(t *testing.T) {
+	if _, err := FragmenterFactory().Fragment(make([]byte, MAX_PACKET_SIZE+1)); err == nil {
+		t.Fatal("a packet larger than MAX_PACKET_SIZE was fragmented")
+	}
+}
+
+func TestReassemblerInvalidFragments(t *testing.T) {
+	r, _ := reassemblerAt(time.Unix(0, 0))
+	invalid := map[string][]byte{
+		"truncated header": fragment(1, 0, 2, nil)[:HEADER_SIZE-1],
+		"no fragment":      fragment(1, 0, 0, []byte("a")),
+		"index too large":  fragment(1, 2, 2, []byte("a")),
+		"too many":         fragment(1, 0, MAX_FRAGMENTS+1, []byte("a")),
+	}
+	for name, datagram := range invalid {
+		if _, complete, err := r.Add("peer", datagram); err == nil || complete {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	add(t, r, "peer", fragment(2, 0, 2, []byte("a")))
+	if _, _, err := r.Add("peer", fragment(2, 1, 3, []byte("b"))); err == nil {
+		t.Error("inconsistent number of fragments accepted")
+	}
+}
+
+func TestReassemblerTimeout(t *testing.T) {
+	r, clk := reassemblerAt(time.Unix(0, 0))
+
+	add(t, r, "peer", fragment(1, 0, 2, []byte("a")))
+	add(t, r, "peer", fragment(2, 0, 2, []byte("c")))
+	clk.Advance(REASSEMBLY_TIMEOUT / 2)
+	if packetBytes, complete := add(t, r, "peer", fragment(1, 1, 2, []byte("b"))); !complete || string(packetBytes) != "ab" {
+		t.Fatalf("reassembled %q before the timeout", packetBytes)
+	}
+
+	clk.Advance(REASSEMBLY_TIMEOUT)
+	if _, complete := add(t, r, "peer", fragment(2, 1, 2, []byte("d"))); complete {
+		t.Fatal("packet reassembled after the timeout")
+	}
+}
+
+func TestReassemblerPeerCap(t *testing.T) {
+	r, _ := reassemblerAt(time.Unix(0, 0))
+
+	add(t, r, "other", fragment(1, 0, 2, []byte("o")))
+	for ID := uint32(1); ID <= MAX_PARTIALS_PER_PEER+1; ID++ {
+		add(t, r, "peer", fragment(ID, 0, 2, []byte("a")))
+	}
+
+	//the oldest incomplete packet of the peer was dropped, the others and those of the other peer are kept
+	for ID := uint32(2); ID <= MAX_PARTIALS_PER_PEER+1; ID++ {
+		if _, complete := add(t, r, "peer", fragment(ID, 1, 2, []byte("b"))); !complete {
+			t.Fatalf("incomplete packet %d was dropped", ID)
+		}
+	}
+	if _, complete := add(t, r, "peer", fragment(1, 1, 2, []byte("b"))); complete {
+		t.Fatal("the oldest incomplete packet of the peer was kept")
+	}
+	if _, complete := add(t, r, "other", fragment(1, 1, 2, []byte("p"))); !complete {
+		t.Fatal("the incomplete packet of the other peer was dropped")
+	}
+}
+
+func TestReassemblerTotalCap(t *testing.T) {
+	r, _ := reassemblerAt(time.Unix(0, 0))
+
+	for i := 0; i <= MAX_PARTIALS; i++ {
+		add(t, r, "peer"+strconv.Itoa(i), fragment(1, 0, 2, []byte("a")))
+	}
+	if len(r.partials) != MAX_PARTIALS {
+		t.Fatalf("%d incomplete packets kept", len(r.partials))
+	}
+	if _, complete := add(t, r, "peer0", fragment(1, 1, 2, []byte("b"))); complete {
+		t.Fatal("the oldest incomplete packet was kept")
+	}
+	if _, complete := add(t, r, "peer"+strconv.Itoa(MAX_PARTIALS), fragment(1, 1, 2, []byte("b"))); !complete {
+		t.Fatal("the newest incomplete packet was dropped")
+	}
+}
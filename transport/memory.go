@@ -0,0 +1,192 @@
+package transport
+
+import (
+	"net"
+	"sync"
+)
+
+//MEMORY_QUEUE_SIZE is the number of packets that can wait in the queue of a MemoryTransport.
+//Packets sent to a full queue are dropped, as a UDP socket would do.
+const MEMORY_QUEUE_SIZE = 1024
+
+//DefaultNetwork is the Network used by TransportFactory for the "memory" transport.
+var DefaultNetwork = NetworkFactory()
+
+//MemoryAddr is the address of a MemoryTransport. It is of the form ip:port, like the other transports.
+type MemoryAddr string
+
+func (ma MemoryAddr) Network() string {
+	return "memory"
+}
+
+func (ma MemoryAddr) String() string {
+	return string(ma)
+}
+
+//Network connects the MemoryTransports of a single process.
+//It counts the packets sent and the packets in flight, so that the network can be observed until it is quiet.
+//A packet is in flight until its receiver asks for the next one, i.e. until the receiver handled it.
+//All operations on the Network are thread-safe.
+type Network struct {
+	sync.RWMutex
+	endpoints map[string]*MemoryTransport
+	sent      uint64
+}
+
+func NetworkFactory() *Network {
+	return &Network{
+		RWMutex:   sync.RWMutex{},
+		endpoints: make(map[string]*MemoryTransport),
+		sent:      0,
+	}
+}
+
+//Sent returns the number of packets sent on the network so far
+func (n *Network) Sent() uint64 {
+	n.RLock()
+	defer n.RUnlock()
+	return n.sent
+}
+
+//InFlight returns the number of packets waiting in the queue of a transport or being handled by their receiver
+func (n *Network) InFlight() int {
+	n.RLock()
+	defer n.RUnlock()
+
+	inFlight := 0
+	for _, mt := range n.endpoints {
+		inFlight += len(mt.queue)
+		if mt.handling {
+			inFlight += 1
+		}
+	}
+	return inFlight
+}
+
+//Listen creates a MemoryTransport that receives the packets sent to address on the network
+func (n *Network) Listen(address string) (*MemoryTransport, error) {
+	n.Lock()
+	defer n.Unlock()
+
+	if _, ok := n.endpoints[address]; ok {
+		return nil, &AddressInUseError{Addr: address}
+	}
+
+	mt := &MemoryTransport{
+		network: n,
+		address: MemoryAddr(address),
+		queue:   make(chan received, MEMORY_QUEUE_SIZE),
+		closed:  make(chan struct{}),
+	}
+	n.endpoints[address] = mt
+	return mt, nil
+}
+
+//deliver puts a copy of packetBytes in the queue of the transport listening at to.
+//The packet is dropped if there is no such transport or if its queue is full.
+func (n *Network) deliver(packetBytes []byte, from, to net.Addr) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.sent += 1
+	mt, ok := n.endpoints[to.String()]
+	if !ok {
+		return
+	}
+
+	select {
+	case mt.queue <- received{packetBytes: append([]byte(nil), packetBytes...), from: from}:
+	default:
+	}
+}
+
+//setHandling records whether the receiver of mt is handling a packet
+func (n *Network) setHandling(mt *MemoryTransport, handling bool) {
+	n.Lock()
+	defer n.Unlock()
+	mt.handling = handling
+}
+
+//Close closes the transport listening at address, if any
+func (n *Network) Close(address string) {
+	n.RLock()
+	mt, ok := n.endpoints[address]
+	n.RUnlock()
+
+	if ok {
+		mt.Close()
+	}
+}
+
+//remove detaches mt from the network
+func (n *Network) remove(mt *MemoryTransport) {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.endpoints[mt.address.String()] == mt {
+		delete(n.endpoints, mt.address.String())
+	}
+}
+
+//MemoryTransport sends the packets through a Network shared by the gossipers of a process.
+//It is mainly used to run several gossipers in the same process.
+type MemoryTransport struct {
+	network   *Network
+	address   MemoryAddr
+	queue     chan received
+	closed    chan struct{}
+	closeOnce sync.Once
+	handling  bool
+}
+
+func (mt *MemoryTransport) Send(packetBytes []byte, dest net.Addr) error {
+	if len(packetBytes) > MAX_PACKET_SIZE {
+		return &PacketTooLargeError{Size: len(packetBytes)}
+	}
+
+	select {
+	case <-mt.closed:
+		return &ClosedError{Addr: mt.address.String()}
+	default:
+	}
+
+	mt.network.deliver(packetBytes, mt.address, dest)
+	return nil
+}
+
+//Receive returns the next packet. The previous one is considered handled by the receiver.
+func (mt *MemoryTransport) Receive() ([]byte, net.Addr, error) {
+	mt.network.setHandling(mt, false)
+	select {
+	case r := <-mt.queue:
+		mt.network.setHandling(mt, true)
+		return r.packetBytes, r.from, nil
+	case <-mt.closed:
+		return nil, nil, &ClosedError{Addr: mt.address.String()}
+	}
+}
+
+func (mt *MemoryTransport) Resolve(address string) (net.Addr, error) {
+	return MemoryAddr(address), nil
+}
+
+func (mt *MemoryTransport) LocalAddr() net.Addr {
+	return mt.address
+}
+
+func (mt *MemoryTransport) Close() error {
+	mt.closeOnce.Do(func() {
+		mt.network.remove(mt)
+		close(mt.closed)
+	})
+	return nil
+}
+
+//AddressInUseError is returned when two MemoryTransports listen at the same address of a Network.
+type AddressInUseError struct {
+	Addr string
+}
+
+func (e *AddressInUseError) Error() string {
+	return "address " + e.Addr + " is already in use"
+}
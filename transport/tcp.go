@@ -0,0 +1,313 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/somecookie/Peerster/helper"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+//DIAL_TIMEOUT is the maximal time spent to open a connection to a peer.
+const DIAL_TIMEOUT = 3 * time.Second
+
+//WRITE_TIMEOUT is the maximal time spent to write a frame, so that a stalled peer does not block the senders.
+//HANDSHAKE_TIMEOUT is the maximal time given to a peer to send its address after opening a connection.
+const (
+	WRITE_TIMEOUT     = 3 * time.Second
+	HANDSHAKE_TIMEOUT = 3 * time.Second
+)
+
+//TCPTransport sends the packets over TCP connections. Each packet is framed with its length on 4 bytes (big endian).
+//The first frame sent on a connection is the address on which the sender listens,
+//so that the packets received on the connection are attributed to the listening address of the peer.
+//The IP of that address must be the one the connection comes from, and it never replaces an existing connection.
+//One connection is kept open per peer. All operations on the TCPTransport are thread-safe.
+type TCPTransport struct {
+	sync.Mutex
+	listener net.Listener
+	address  *net.TCPAddr
+	conns    map[string]*tcpConn
+	incoming chan received
+	closed   chan struct{}
+}
+
+//received is a packet received on one of the connections
+type received struct {
+	packetBytes []byte
+	from        net.Addr
+}
+
+//tcpConn is a connection to a peer. Writes are serialized with the Mutex.
+type tcpConn struct {
+	sync.Mutex
+	conn net.Conn
+}
+
+//TCPTransportFactory listens for TCP connections at address (ip:port)
+func TCPTransportFactory(address string) (*TCPTransport, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.ListenTCP("tcp4", tcpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	tt := &TCPTransport{
+		Mutex:    sync.Mutex{},
+		listener: listener,
+		address:  listener.Addr().(*net.TCPAddr),
+		conns:    make(map[string]*tcpConn),
+		incoming: make(chan received, 100),
+		closed:   make(chan struct{}),
+	}
+
+	go tt.accept()
+	return tt, nil
+}
+
+func (tt *TCPTransport) Send(packetBytes []byte, dest net.Addr) error {
+	if len(packetBytes) > MAX_PACKET_SIZE {
+		return &PacketTooLargeError{Size: len(packetBytes)}
+	}
+
+	tc, err := tt.getConn(dest)
+	if err != nil {
+		return err
+	}
+
+	tc.Lock()
+	err = writeFrameWithDeadline(tc.conn, packetBytes)
+	tc.Unlock()
+
+	if err != nil {
+		tt.removeConn(dest.String(), tc)
+	}
+	return err
+}
+
+func (tt *TCPTransport) Receive() ([]byte, net.Addr, error) {
+	select {
+	case r := <-tt.incoming:
+		return r.packetBytes, r.from, nil
+	case <-tt.closed:
+		return nil, nil, &ClosedError{Addr: tt.address.String()}
+	}
+}
+
+func (tt *TCPTransport) Resolve(address string) (net.Addr, error) {
+	return net.ResolveTCPAddr("tcp4", address)
+}
+
+func (tt *TCPTransport) LocalAddr() net.Addr {
+	return tt.address
+}
+
+func (tt *TCPTransport) Close() error {
+	tt.Lock()
+	defer tt.Unlock()
+
+	select {
+	case <-tt.closed:
+		return nil
+	default:
+	}
+
+	close(tt.closed)
+	for key, tc := range tt.conns {
+		tc.conn.Close()
+		delete(tt.conns, key)
+	}
+	return tt.listener.Close()
+}
+
+//getConn returns the connection to dest. A new connection is opened if there is none.
+func (tt *TCPTransport) getConn(dest net.Addr) (*tcpConn, error) {
+	key := dest.String()
+
+	tt.Lock()
+	tc, ok := tt.conns[key]
+	tt.Unlock()
+	if ok {
+		return tc, nil
+	}
+
+	conn, err := net.DialTimeout("tcp4", key, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFrameWithDeadline(conn, []byte(tt.address.String())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tc = &tcpConn{Mutex: sync.Mutex{}, conn: conn}
+	if !tt.addConn(key, tc) {
+		conn.Close()
+		return tt.getConn(dest)
+	}
+
+	go tt.read(tc, dest)
+	return tc, nil
+}
+
+//addConn stores the connection tc to the peer listening at key.
+//It returns false if there is already a connection to this peer or if the transport is closed.
+func (tt *TCPTransport) addConn(key string, tc *tcpConn) bool {
+	tt.Lock()
+	defer tt.Unlock()
+
+	select {
+	case <-tt.closed:
+		return false
+	default:
+	}
+
+	if _, ok := tt.conns[key]; ok {
+		return false
+	}
+	tt.conns[key] = tc
+	return true
+}
+
+//removeConn closes tc and removes it if it is still the connection to the peer listening at key
+func (tt *TCPTransport) removeConn(key string, tc *tcpConn) {
+	tc.conn.Close()
+
+	tt.Lock()
+	defer tt.Unlock()
+	if tt.conns[key] == tc {
+		delete(tt.conns, key)
+	}
+}
+
+//accept accepts the connections opened by the peers until the transport is closed
+func (tt *TCPTransport) accept() {
+	for {
+		conn, err := tt.listener.Accept()
+		if err != nil {
+			select {
+			case <-tt.closed:
+				return
+			default:
+				helper.LogError(err)
+				continue
+			}
+		}
+		go tt.handshake(conn)
+	}
+}
+
+//handshake reads the listening address of the peer that opened conn and starts reading its packets.
+//The connection is rejected if the address does not have the IP the connection comes from.
+func (tt *TCPTransport) handshake(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	helper.LogError(conn.SetReadDeadline(time.Now().Add(HANDSHAKE_TIMEOUT)))
+	addrBytes, err := readFrame(reader)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	helper.LogError(conn.SetReadDeadline(time.Time{}))
+
+	from, err := net.ResolveTCPAddr("tcp4", string(addrBytes))
+	if err != nil {
+		helper.LogError(err)
+		conn.Close()
+		return
+	}
+
+	remote, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !remote.IP.Equal(from.IP) {
+		helper.LogError(&HandshakeError{Claimed: from.String(), Remote: conn.RemoteAddr().String()})
+		conn.Close()
+		return
+	}
+
+	tc := &tcpConn{Mutex: sync.Mutex{}, conn: conn}
+
+	//the connection is also used to send packets to the peer, unless we already have one
+	tt.addConn(from.String(), tc)
+	tt.readFrom(reader, tc, from)
+}
+
+//read reads the packets sent by the peer at from on tc
+func (tt *TCPTransport) read(tc *tcpConn, from net.Addr) {
+	tt.readFrom(bufio.NewReader(tc.conn), tc, from)
+}
+
+func (tt *TCPTransport) readFrom(reader *bufio.Reader, tc *tcpConn, from net.Addr) {
+	defer tt.removeConn(from.String(), tc)
+
+	for {
+		packetBytes, err := readFrame(reader)
+		if err != nil {
+			select {
+			case <-tt.closed:
+			default:
+				if err != io.EOF {
+					helper.LogError(err)
+				}
+			}
+			return
+		}
+
+		select {
+		case tt.incoming <- received{packetBytes: packetBytes, from: from}:
+		case <-tt.closed:
+			return
+		}
+	}
+}
+
+//writeFrameWithDeadline writes a frame on conn, failing if it takes more than WRITE_TIMEOUT
+func writeFrameWithDeadline(conn net.Conn, data []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT)); err != nil {
+		return err
+	}
+	return writeFrame(conn, data)
+}
+
+//writeFrame writes data prefixed by its length
+func writeFrame(w io.Writer, data []byte) error {
+	frame := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	frame = append(frame, data...)
+	_, err := w.Write(frame)
+	return err
+}
+
+//readFrame reads a frame written by writeFrame
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > MAX_PACKET_SIZE {
+		return nil, &PacketTooLargeError{Size: int(size)}
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+//HandshakeError is returned when a peer claims a listening address whose IP is not the one it connects from.
+type HandshakeError struct {
+	Claimed string
+	Remote  string
+}
+
+func (e *HandshakeError) Error() string {
+	return "peer connecting from " + e.Remote + " claims the address " + e.Claimed
+}
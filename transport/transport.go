@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"github.com/somecookie/Peerster/clock"
+	"github.com/somecookie/Peerster/helper"
+	"net"
+)
+
+//Transport is the interface of the layers able to carry encoded packets between gossipers.
+//Send sends the encoded packet packetBytes to dest. Delivery is not guaranteed.
+//Receive blocks until a complete packet is received and returns it together with the address of the sender.
+//The returned slice is owned by the caller. Receive should only be called by a single goroutine.
+//Resolve converts an address of the form ip:port into an address that can be used with Send.
+//LocalAddr is the address on which the transport receives packets.
+//Close releases the resources of the transport. Receive returns an error once the transport is closed.
+type Transport interface {
+	Send(packetBytes []byte, dest net.Addr) error
+	Receive() ([]byte, net.Addr, error)
+	Resolve(address string) (net.Addr, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+//TransportFactory creates the transport called kind listening at address.
+//kind is either "udp", "tcp" or "memory". The "memory" transport is attached to DefaultNetwork.
+//clk is the clock of the timeouts of the transport.
+func TransportFactory(kind, address string, clk clock.Clock) (Transport, error) {
+	switch kind {
+	case "", "udp":
+		return UDPTransportFactory(address, clk)
+	case "tcp":
+		return TCPTransportFactory(address)
+	case "memory":
+		return DefaultNetwork.Listen(address)
+	default:
+		return nil, &helper.IllegalArgumentError{
+			ErrorMessage: "unknown transport " + kind,
+			Where:        "transport.go",
+		}
+	}
+}
+
+//ClosedError is returned when a closed transport is used.
+type ClosedError struct {
+	Addr string
+}
+
+func (e *ClosedError) Error() string {
+	return "transport " + e.Addr + " is closed"
+}
@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"errors"
+	"github.com/somecookie/Peerster/clock"
+	"github.com/somecookie/Peerster/helper"
+	"net"
+)
+
+//UDPTransport sends the packets as UDP datagrams.
+//Packets larger than MAX_DATAGRAM_SIZE are fragmented and reassembled on reception.
+type UDPTransport struct {
+	conn        *net.UDPConn
+	fragmenter  *Fragmenter
+	reassembler *Reassembler
+	buffer      []byte
+}
+
+//UDPTransportFactory listens for UDP datagrams at address (ip:port).
+//The incomplete packets are dropped after REASSEMBLY_TIMEOUT, measured with clk.
+func UDPTransportFactory(address string, clk clock.Clock) (*UDPTransport, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UDPTransport{
+		conn:        conn,
+		fragmenter:  FragmenterFactory(),
+		reassembler: ReassemblerFactory(REASSEMBLY_TIMEOUT, clk),
+		buffer:      make([]byte, MAX_UDP_SIZE),
+	}, nil
+}
+
+func (ut *UDPTransport) Send(packetBytes []byte, dest net.Addr) error {
+	udpAddr, ok := dest.(*net.UDPAddr)
+	if !ok {
+		return &AddressError{Addr: dest, Network: "udp"}
+	}
+
+	datagrams, err := ut.fragmenter.Fragment(packetBytes)
+	if err != nil {
+		return err
+	}
+
+	for _, datagram := range datagrams {
+		if _, err := ut.conn.WriteToUDP(datagram, udpAddr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ut *UDPTransport) Receive() ([]byte, net.Addr, error) {
+	for {
+		n, from, err := ut.conn.ReadFromUDP(ut.buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return nil, nil, &ClosedError{Addr: ut.conn.LocalAddr().String()}
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		packetBytes, complete, err := ut.reassembler.Add(from.String(), ut.buffer[:n])
+		helper.LogError(err)
+
+		if complete {
+			if n > 0 && ut.buffer[0] != FRAGMENT_MARKER {
+				packetBytes = append([]byte(nil), packetBytes...)
+			}
+			return packetBytes, from, nil
+		}
+	}
+}
+
+func (ut *UDPTransport) Resolve(address string) (net.Addr, error) {
+	return net.ResolveUDPAddr("udp4", address)
+}
+
+func (ut *UDPTransport) LocalAddr() net.Addr {
+	return ut.conn.LocalAddr()
+}
+
+func (ut *UDPTransport) Close() error {
+	return ut.conn.Close()
+}
+
+//AddressError is returned when an address of another network is given to a transport.
+type AddressError struct {
+	Addr    net.Addr
+	Network string
+}
+
+func (e *AddressError) Error() string {
+	return "address " + e.Addr.String() + " (" + e.Addr.Network() + ") cannot be used with the " + e.Network + " transport"
+}